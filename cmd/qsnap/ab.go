@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/maxischmaxi/qsnap/internal/browser"
+	"github.com/maxischmaxi/qsnap/internal/compare"
+	"github.com/maxischmaxi/qsnap/internal/config"
+	"github.com/maxischmaxi/qsnap/internal/pool"
+	"github.com/maxischmaxi/qsnap/internal/report"
+	"github.com/maxischmaxi/qsnap/internal/snapshot"
+	"github.com/maxischmaxi/qsnap/internal/storybook"
+	"github.com/maxischmaxi/qsnap/internal/tools"
+)
+
+// runAB serves two static Storybook builds and compares every case of build
+// b against the same case of build a, without stored baselines.
+func runAB(args []string) int {
+	fset := flag.NewFlagSet("ab", flag.ExitOnError)
+	ef := registerEnvFlags(fset)
+	var (
+		buildA = fset.String("a", "", "the reference Storybook build, e.g. of main (relative to -input)")
+		buildB = fset.String("b", "", "the Storybook build to check, e.g. of the PR (relative to -input)")
+		limit  = fset.Int("limit", 0, "if > 0, only compare this many stories")
+		filter = fset.String("filter", "", "only compare stories whose name or URL matches this glob (or regex with a \"re:\" prefix)")
+		out    = fset.String("out", "ab-report.json", "where to write the report (relative to -input)")
+	)
+	_ = fset.Parse(args)
+	if *buildA == "" || *buildB == "" {
+		log.Fatal("ab needs both -a and -b")
+	}
+
+	rootCtx, stop := signalContext()
+	defer stop()
+	started := time.Now()
+
+	e, err := ef.load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer e.close()
+
+	discovered, err := e.cfg.FindAndParseConfigs(e.baseDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, fe := range discovered.Errors {
+		fmt.Println("skipping config:", fe.Error())
+	}
+	configs, err := selectConfigs(discovered.Configs, *filter, *limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Captures von a sind die Baselines, getrennt von den echten
+	layout := e.layout
+	layout.Root, layout.Branch = filepath.Join(e.layout.Root, "__ab__"), ""
+
+	var ports [2]int
+	for i, dir := range []string{*buildA, *buildB} {
+		port, ctrl, err := serveBuild(rootCtx, e, filepath.Join(e.baseDir, dir))
+		if err != nil {
+			log.Fatalf("%s: %v", dir, err)
+		}
+		defer ctrl.Stop()
+		ports[i] = port
+		fmt.Printf("serving %s on port %d\n", dir, port)
+	}
+	if err := e.launchBrowsers(rootCtx); err != nil {
+		log.Fatal(err)
+	}
+
+	results := make([]report.CaseResult, len(configs))
+	var mu sync.Mutex
+	wp := pool.New(*ef.concurrency)
+	for i, s := range configs {
+		wp.Go(func() (err error) {
+			defer pool.Recover(&err)
+			res, ok := abCase(rootCtx, e, layout, ports, s)
+			if !ok {
+				return nil
+			}
+			if res.Status != "pass" {
+				fmt.Printf("%s - %s %s\n", res.Label(), res.Status, res.Error)
+			}
+			mu.Lock()
+			results[i] = res
+			mu.Unlock()
+			return nil
+		})
+	}
+	if err := wp.Wait(); err != nil {
+		log.Println("internal error:", err)
+	}
+
+	for i, s := range configs {
+		if results[i].Status == "" {
+			results[i] = compare.NewResult(layout, s)
+			results[i].Status = "skipped"
+		}
+	}
+	finished := time.Now()
+	rep := report.Report{
+		GeneratedAt: finished.Format(time.RFC3339),
+		Cases:       results,
+		Analytics:   report.Analyze(results, 5),
+		Timing:      report.NewTiming(started, finished, results),
+	}
+	rep.Recount()
+	outPath := filepath.Join(e.baseDir, *out)
+	if err := report.Write(outPath, rep); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%d cases: %d same, %d differ, %d errors - report written to %s\n", rep.Total, rep.Passed, rep.Failed, rep.Errored, outPath)
+
+	if rootCtx.Err() != nil {
+		return report.ExitInterrupted
+	}
+	// a ohne Capture zählt wie ein Fehler, nicht wie ein neuer Case
+	return report.ExitCode(rep, "error")
+}
+
+// serveBuild serves dir on a free port from -storybookPort upwards. Running
+// servers are never reused, they could serve the other build.
+func serveBuild(ctx context.Context, e *env, dir string) (int, *storybook.Controller, error) {
+	f := e.flags
+	port, err := storybook.FreePort(*f.sbPort, *f.sbPort+100)
+	if err != nil {
+		return 0, nil, err
+	}
+	ctrl, _, err := storybook.ServeBuildIfNeeded(ctx, port, dir, *f.sbHealth, time.Duration(*f.sbWaitSec)*time.Second, "")
+	return port, ctrl, err
+}
+
+// abCase captures s from both builds in one tab slot; the capture of a is
+// written as the baseline, b is compared against it.
+func abCase(ctx context.Context, e *env, layout config.Layout, ports [2]int, s *config.OsnapConfig) (report.CaseResult, bool) {
+	if s.Skip.Enabled {
+		return compare.Skipped(layout, s), true
+	}
+	res := compare.NewResult(layout, s)
+	res.StartedAt = time.Now()
+	if !browser.Available(s.Browser) {
+		res.Status = "error"
+		res.ErrorKind = "browser"
+		res.Error = fmt.Sprintf("browser %q is not available", s.Browser)
+		return res, true
+	}
+
+	b, release, err := e.browsers.Acquire(ctx)
+	if err != nil {
+		return res, false
+	}
+	defer release()
+	res.WaitTime = time.Since(res.StartedAt)
+
+	var shots [2][]byte
+	captureStart := time.Now()
+	for i, port := range ports {
+		u := fmt.Sprintf("http://127.0.0.1:%d%s", port, s.URL)
+		if e.cfg.Seed != nil {
+			u = snapshot.WithSeedGlobal(u, *e.cfg.Seed)
+		}
+		capCtx, cancel := context.WithTimeout(ctx, e.timeout())
+		shot, err := snapshot.Capture(capCtx, b, u, e.captureOptions(s))
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return res, false
+			}
+			res.Status = "error"
+			res.ErrorKind = snapshot.ErrorKind(err)
+			res.Error = fmt.Sprintf("build %s: %v", []string{"a", "b"}[i], err)
+			return res, true
+		}
+		shots[i] = shot.PNG
+	}
+	res.CaptureTime = time.Since(captureStart)
+
+	if err := tools.WriteFile(res.Baseline, shots[0]); err != nil {
+		res.Status = "error"
+		res.ErrorKind = "compare"
+		res.Error = err.Error()
+		return res, true
+	}
+	compare.Case(e.cfg, s, &res, shots[1])
+	if res.Status == "fail" {
+		if err := tools.WriteFile(layout.ActualPath(s), shots[1]); err == nil {
+			res.Actual = layout.ActualPath(s)
+		}
+	} else {
+		_ = os.Remove(layout.ActualPath(s))
+	}
+	return res, true
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/maxischmaxi/qsnap/internal/compare"
+	"github.com/maxischmaxi/qsnap/internal/config"
+	"github.com/maxischmaxi/qsnap/internal/report"
+	"github.com/maxischmaxi/qsnap/internal/storage"
+	"github.com/maxischmaxi/qsnap/internal/tools"
+)
+
+// runApprove copies the actual captures of failed and new cases from the last
+// report over their baselines and pushes them to remote storage if configured.
+func runApprove(args []string) int {
+	fset := flag.NewFlagSet("approve", flag.ExitOnError)
+	var (
+		input      = fset.String("input", ".", "the storybook directory the report belongs to")
+		baseConfig = fset.String("baseConfig", "osnap.config.yaml", "path to the base osnap config file")
+		reportFile = fset.String("report", "report.json", "path to the report to approve (relative to -input)")
+	)
+	_ = fset.Parse(args)
+
+	baseDir, err := tools.ExpandPath(*input)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cfg, err := config.NewOsnapBaseConfig(filepath.Join(baseDir, *baseConfig))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	store, err := storage.New(cfg.BaselineStorage)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	reportPath := filepath.Join(baseDir, *reportFile)
+	rep, err := report.Read(reportPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+	approved := 0
+	for i, c := range rep.Cases {
+		if c.Status != "fail" && c.Status != "unstable" && c.Status != "no-baseline" {
+			continue
+		}
+		if c.Actual == "" || !tools.FileExists(c.Actual) {
+			fmt.Printf("%s - no capture to approve (rerun with -emitNew for new stories)\n", c.Name)
+			continue
+		}
+
+		if err := approveCase(ctx, store, &rep.Cases[i]); err != nil {
+			log.Fatal(err)
+		}
+
+		approved++
+		fmt.Printf("%s - approved\n", c.Name)
+	}
+
+	if err := report.Write(reportPath, rep); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("approved", approved, "baselines")
+	return report.ExitOK
+}
+
+// approveCase copies the actual capture over the baseline and uploads it.
+func approveCase(ctx context.Context, store storage.Backend, c *report.CaseResult) error {
+	data, err := os.ReadFile(c.Actual)
+	if err != nil {
+		return err
+	}
+	return compare.WriteBaseline(ctx, store, c, data)
+}
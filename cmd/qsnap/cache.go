@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheSalt collects the inputs shared by all cases. iframe.html references
+// the hashed bundles, so any rebuilt component changes it.
+func (e *env) cacheSalt() ([]byte, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(e.pageURL("/iframe.html"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET iframe.html: %s", resp.Status)
+	}
+	iframe, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	baseCfg, err := os.ReadFile(filepath.Join(e.baseDir, *e.flags.baseConfig))
+	if err != nil {
+		return nil, err
+	}
+
+	salt := append(iframe, 0)
+	salt = append(salt, baseCfg...)
+	salt = append(salt, 0)
+	salt = append(salt, *e.flags.chromeArgs...)
+	return salt, nil
+}
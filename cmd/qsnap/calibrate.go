@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/maxischmaxi/qsnap/internal/browser"
+	"github.com/maxischmaxi/qsnap/internal/diff"
+	"github.com/maxischmaxi/qsnap/internal/pool"
+	"github.com/maxischmaxi/qsnap/internal/report"
+	"github.com/maxischmaxi/qsnap/internal/snapshot"
+)
+
+type calibration struct {
+	Name      string  `json:"name"`
+	Width     int     `json:"width"`
+	Height    int     `json:"height"`
+	Runs      int     `json:"runs"`
+	MaxNoise  float64 `json:"maxNoise"`  // largest ratioDiff between two captures of the same story
+	Suggested float64 `json:"suggested"` // suggested threshold in percent, as in the config
+	Error     string  `json:"error,omitempty"`
+}
+
+// runCalibrate captures every story several times against itself and
+// suggests per-story thresholds that absorb the natural rendering noise.
+func runCalibrate(args []string) int {
+	fset := flag.NewFlagSet("calibrate", flag.ExitOnError)
+	ef := registerEnvFlags(fset)
+	var (
+		runs   = fset.Int("runs", 5, "how many times each story is captured")
+		margin = fset.Float64("margin", 1.5, "factor applied to the measured noise to get the suggested threshold")
+		limit  = fset.Int("limit", 0, "if > 0, only calibrate this many stories")
+		filter = fset.String("filter", "", "only calibrate stories whose name or URL matches this glob (or regex with a \"re:\" prefix)")
+		out    = fset.String("out", "calibration.json", "where to write the suggestions (relative to -input)")
+	)
+	_ = fset.Parse(args)
+
+	rootCtx, stop := signalContext()
+	defer stop()
+
+	e, err := ef.load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer e.close()
+
+	discovered, err := e.cfg.FindAndParseConfigs(e.baseDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, fe := range discovered.Errors {
+		fmt.Println("skipping config:", fe.Error())
+	}
+
+	configs, err := selectConfigs(discovered.Configs, *filter, *limit)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := e.start(rootCtx); err != nil {
+		log.Fatal(err)
+	}
+
+	results := make([]calibration, len(configs))
+	wp := pool.New(*ef.concurrency)
+
+	for i, s := range configs {
+		wp.Go(func() (err error) {
+			c := calibration{Name: s.Name, Width: s.Width, Height: s.Height}
+			defer func() {
+				if err != nil {
+					c.Error = err.Error()
+				}
+				results[i] = c
+			}()
+			defer pool.Recover(&err)
+
+			if !browser.Available(s.Browser) {
+				c.Error = fmt.Sprintf("browser %q is not available", s.Browser)
+				return nil
+			}
+
+			var first []byte
+			for run := 0; run < max(*runs, 2); run++ {
+				if rootCtx.Err() != nil {
+					c.Error = rootCtx.Err().Error()
+					return nil
+				}
+
+				b, release, err := e.browsers.Acquire(rootCtx)
+				if err != nil {
+					c.Error = err.Error()
+					return nil
+				}
+				ctx, cancel := context.WithTimeout(rootCtx, e.timeout())
+				shot, err := snapshot.Capture(ctx, b, e.storyURL(s), e.captureOptions(s))
+				cancel()
+				release()
+				if err != nil {
+					c.Error = err.Error()
+					return nil
+				}
+				c.Runs++
+
+				if first == nil {
+					first = shot.PNG
+					continue
+				}
+				ratio, err := diff.PixelRatio(first, shot.PNG, e.cfg.Gutter(s))
+				if err != nil {
+					c.Error = err.Error()
+					return nil
+				}
+				c.MaxNoise = math.Max(c.MaxNoise, ratio)
+			}
+
+			// in Prozent, auf 4 Nachkommastellen aufgerundet, damit die Vorschläge lesbar bleiben
+			c.Suggested = math.Ceil(c.MaxNoise**margin*1e6) / 1e4
+			fmt.Printf("%s (%dx%d) - noise %.5f%%, suggested threshold %.4f\n", s.Name, s.Width, s.Height, c.MaxNoise*100, c.Suggested)
+			return nil
+		})
+	}
+	_ = wp.Wait() // Fehler stehen schon in results
+
+	b, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	outPath := filepath.Join(e.baseDir, *out)
+	if err := os.WriteFile(outPath, b, 0o644); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("wrote calibration to", outPath)
+
+	if rootCtx.Err() != nil {
+		return report.ExitInterrupted
+	}
+	return report.ExitOK
+}
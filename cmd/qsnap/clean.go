@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/maxischmaxi/qsnap/internal/report"
+)
+
+// runClean deletes baselines, diff images and captures in __image-snapshots__
+// that belong to no case of the current story configs.
+func runClean(args []string) int {
+	fset := flag.NewFlagSet("clean", flag.ExitOnError)
+	ef := registerEnvFlags(fset)
+	var (
+		dryRun = fset.Bool("dry-run", false, "only list orphaned images")
+		force  = fset.Bool("force", false, "clean even if some story config files cannot be parsed")
+	)
+	_ = fset.Parse(args)
+
+	e, err := ef.load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	discovered, err := e.cfg.FindAndParseConfigs(e.baseDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Cases aus kaputten Configs fehlen in der Liste, ihre Baselines sähen
+	// sonst verwaist aus
+	if len(discovered.Errors) > 0 && !*force {
+		for _, fe := range discovered.Errors {
+			fmt.Println("cannot parse config:", fe.Error())
+		}
+		log.Printf("%d config files could not be parsed, not cleaning (use -force to ignore them)", len(discovered.Errors))
+		return report.ExitErrors
+	}
+
+	keep := map[string]bool{}
+	for _, s := range discovered.Configs {
+		diffPath, baselinePath := e.layout.CasePaths(s)
+		keep[diffPath] = true
+		keep[baselinePath] = true
+		keep[e.layout.ActualPath(s)] = true
+	}
+
+	root := e.layout.Root
+	var orphans []string
+	for _, dir := range []string{"__base_images__", "__diff__", "__actual__"} {
+		err := filepath.WalkDir(filepath.Join(root, dir), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() || filepath.Ext(path) != ".png" || keep[path] {
+				return nil
+			}
+			orphans = append(orphans, path)
+			return nil
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	failed := 0
+	for _, path := range orphans {
+		rel, _ := filepath.Rel(root, path)
+		if *dryRun {
+			fmt.Println("orphaned", rel)
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			fmt.Println("could not delete", rel, "-", err)
+			failed++
+			continue
+		}
+		fmt.Println("deleted", rel)
+	}
+
+	fmt.Println(len(orphans), "orphaned images in", root)
+	if failed > 0 {
+		return report.ExitErrors
+	}
+	return report.ExitOK
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/maxischmaxi/qsnap/internal/procs"
+	"github.com/maxischmaxi/qsnap/internal/report"
+)
+
+// runCleanup kills Chrome processes left behind by qsnap runs that died
+// without shutting down (e.g. after SIGKILL on a CI agent).
+func runCleanup(args []string) int {
+	fset := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	dryRun := fset.Bool("dry-run", false, "only list orphaned processes")
+	_ = fset.Parse(args)
+
+	orphans, err := procs.Cleanup(*dryRun)
+	for _, o := range orphans {
+		action := "killed"
+		if *dryRun {
+			action = "orphaned"
+		}
+		if o.Kind == "server" {
+			action = "stale pid file"
+		}
+		fmt.Printf("%s %d (owner %d) - %s\n", o.Kind, o.PID, o.Owner, action)
+	}
+	if err != nil {
+		log.Println(err)
+		return report.ExitErrors
+	}
+
+	fmt.Println(len(orphans), "orphans found in", procs.Dir())
+	return report.ExitOK
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/maxischmaxi/qsnap/internal/compare"
+	"github.com/maxischmaxi/qsnap/internal/config"
+	"github.com/maxischmaxi/qsnap/internal/remote"
+	"github.com/maxischmaxi/qsnap/internal/report"
+	"github.com/maxischmaxi/qsnap/internal/tools"
+)
+
+// workerInFlight Batches pro Worker, damit er beim Zurückschicken der
+// Ergebnisse nicht leer läuft
+const workerInFlight = 2
+
+// maxBatchAttempts: so oft wird ein Case an (verschiedene) Worker geschickt
+const maxBatchAttempts = 3
+
+// caseRef addresses s on a worker with the same checkout.
+func (e *env) caseRef(s *config.OsnapConfig) remote.CaseRef {
+	return remote.CaseRef{Source: s.StoryFile, Browser: s.Browser, Label: compare.NewResult(e.layout, s).Label()}
+}
+
+// distribute runs the cases of order on the workers instead of the local
+// browser pool and calls done for every finished case. Batches of a worker
+// that fails go to the remaining workers; when no worker is left the
+// unfinished cases end as errors and distribute returns an error.
+func (e *env) distribute(ctx context.Context, workers []string, token string, batchSize int, configs []*config.OsnapConfig, order []int, update string, emitNew bool, done func(i int, res report.CaseResult)) error {
+	var (
+		mu        sync.Mutex
+		remaining = len(order)
+		attempts  = map[int]int{}
+		finished  = map[int]bool{}
+		alive     = len(workers)
+		allDone   = make(chan struct{})
+		allDead   = make(chan struct{})
+		// jeder Batch hat mindestens einen offenen Case, mehr als len(order) gibt es nie
+		queue = make(chan []int, max(len(order), 1))
+	)
+	if remaining == 0 {
+		return nil
+	}
+	for start := 0; start < len(order); start += batchSize {
+		queue <- order[start:min(start+batchSize, len(order))]
+	}
+
+	finish := func(i int, res report.CaseResult) {
+		mu.Lock()
+		if finished[i] {
+			mu.Unlock()
+			return
+		}
+		finished[i] = true
+		remaining--
+		last := remaining == 0
+		mu.Unlock()
+		done(i, res)
+		if last {
+			close(allDone)
+		}
+	}
+	// requeue schickt die offenen Cases eines Batches erneut los
+	requeue := func(batch []int, cause error) {
+		var again []int
+		for _, i := range batch {
+			mu.Lock()
+			open := !finished[i]
+			attempts[i]++
+			n := attempts[i]
+			mu.Unlock()
+			if !open {
+				continue
+			}
+			if n < maxBatchAttempts {
+				again = append(again, i)
+				continue
+			}
+			res := compare.NewResult(e.layout, configs[i])
+			res.Status = "error"
+			res.ErrorKind = "worker"
+			res.Error = fmt.Sprintf("no worker could run the case: %v", cause)
+			finish(i, res)
+		}
+		if len(again) > 0 {
+			queue <- again
+		}
+	}
+
+	runBatch := func(wctx context.Context, addr string, batch []int) error {
+		b := remote.Batch{ID: fmt.Sprintf("%s-%d", addr, batch[0]), UpdateBaselines: update, EmitNew: emitNew}
+		for _, i := range batch {
+			b.Cases = append(b.Cases, e.caseRef(configs[i]))
+		}
+		err := remote.Run(wctx, addr, token, b, func(r remote.Result) {
+			i := batch[r.Index]
+			finish(i, e.applyRemote(configs[i], r))
+		})
+		if err == nil {
+			// ein Worker, der Cases auslässt, ist kaputt
+			for _, i := range batch {
+				mu.Lock()
+				open := !finished[i]
+				mu.Unlock()
+				if open {
+					return fmt.Errorf("worker %s: stream ended without all results", addr)
+				}
+			}
+		}
+		return err
+	}
+
+	var (
+		wg    sync.WaitGroup
+		deads []context.CancelFunc
+	)
+	defer func() {
+		for _, dead := range deads {
+			dead()
+		}
+	}()
+	for _, addr := range workers {
+		wctx, dead := context.WithCancel(ctx)
+		deads = append(deads, dead)
+		var once sync.Once
+		for range workerInFlight {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-wctx.Done():
+						return
+					case <-allDone:
+						return
+					case batch := <-queue:
+						err := runBatch(wctx, addr, batch)
+						if err == nil {
+							continue
+						}
+						if ctx.Err() != nil {
+							return
+						}
+						once.Do(func() {
+							slog.Warn("worker failed, sending its cases to the other workers", "worker", addr, "err", err)
+							dead()
+							mu.Lock()
+							alive--
+							if alive == 0 {
+								close(allDead)
+							}
+							mu.Unlock()
+						})
+						requeue(batch, err)
+						return
+					}
+				}
+			}()
+		}
+	}
+
+	var err error
+	select {
+	case <-allDone:
+	case <-allDead:
+		err = errors.New("all workers failed")
+	case <-ctx.Done():
+	}
+	wg.Wait()
+	if err != nil {
+		for _, i := range order {
+			res := compare.NewResult(e.layout, configs[i])
+			res.Status = "error"
+			res.ErrorKind = "worker"
+			res.Error = err.Error()
+			finish(i, res)
+		}
+	}
+	return err
+}
+
+// applyRemote copies the files of a worker result into the local snapshot
+// directory and points the result's paths at them.
+func (e *env) applyRemote(s *config.OsnapConfig, r remote.Result) report.CaseResult {
+	local := compare.NewResult(e.layout, s)
+	c := r.Case
+	c.Source, c.OutPath = local.Source, local.OutPath
+	c.Baseline, c.BranchBaseline = local.Baseline, local.BranchBaseline
+	if (c.Status == "created" || c.Status == "updated") && c.BranchBaseline != "" {
+		// wie compare.WriteBaseline: neue Baselines landen im Branch
+		c.Baseline, c.BranchBaseline = c.BranchBaseline, ""
+	}
+
+	write := func(path string, b []byte) bool {
+		if err := tools.WriteFile(path, b); err != nil {
+			c.Warnings = append(c.Warnings, "could not copy worker file: "+err.Error())
+			return false
+		}
+		return true
+	}
+	c.Actual = ""
+	if b, ok := r.Files["actual"]; ok && write(e.layout.ActualPath(s), b) {
+		c.Actual = e.layout.ActualPath(s)
+	}
+	if c.PixelDiff != nil && c.PixelDiff.DiffImagePath != "" {
+		c.PixelDiff.DiffImagePath = ""
+		if b, ok := r.Files["diff"]; ok && write(local.OutPath, b) {
+			c.PixelDiff.DiffImagePath = local.OutPath
+		}
+	}
+	if b, ok := r.Files["baseline"]; ok {
+		write(c.Baseline, b)
+	}
+
+	dir := e.layout.DebugDir(s)
+	if c.Debug != "" || c.HAR != "" {
+		_ = os.RemoveAll(dir)
+	}
+	for name, b := range r.Files {
+		if rest, ok := strings.CutPrefix(name, "debug/"); ok && rest != "" && !strings.ContainsAny(rest, `/\`) && rest != ".." {
+			write(filepath.Join(dir, rest), b)
+		}
+	}
+	if c.Debug != "" {
+		c.Debug = dir
+	}
+	if c.HAR != "" {
+		c.HAR = filepath.Join(dir, "network.har")
+	}
+	return c
+}
+
+// workerFiles collects the files of res that the coordinator needs.
+func workerFiles(res report.CaseResult) map[string][]byte {
+	files := map[string][]byte{}
+	add := func(name, path string) {
+		if path == "" {
+			return
+		}
+		if b, err := os.ReadFile(path); err == nil {
+			files[name] = b
+		}
+	}
+	add("actual", res.Actual)
+	if px := res.PixelDiff; px != nil {
+		add("diff", px.DiffImagePath)
+	}
+	if res.Status == "created" || res.Status == "updated" {
+		add("baseline", res.Baseline)
+	}
+	dir := res.Debug
+	if dir == "" && res.HAR != "" {
+		dir = filepath.Dir(res.HAR)
+	}
+	if dir != "" {
+		entries, _ := os.ReadDir(dir)
+		for _, de := range entries {
+			if !de.IsDir() {
+				add("debug/"+de.Name(), filepath.Join(dir, de.Name()))
+			}
+		}
+	}
+	return files
+}
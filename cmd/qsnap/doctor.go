@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/maxischmaxi/qsnap/internal/browser"
+	"github.com/maxischmaxi/qsnap/internal/report"
+	"github.com/maxischmaxi/qsnap/internal/storybook"
+	"github.com/maxischmaxi/qsnap/internal/tools"
+)
+
+// minFreeDisk: darunter warnt doctor, ein Run mit Diffs und Captures
+// braucht schnell einige hundert MB
+const minFreeDisk = 1 << 30
+
+// doctor collects the results of the checks; every problem comes with a hint
+// what to do about it.
+type doctor struct {
+	failed, warned int
+}
+
+func (d *doctor) ok(format string, args ...any) {
+	fmt.Printf("  ok    "+format+"\n", args...)
+}
+
+func (d *doctor) warn(hint, format string, args ...any) {
+	d.warned++
+	fmt.Printf("  warn  "+format+"\n", args...)
+	if hint != "" {
+		fmt.Println("        → " + hint)
+	}
+}
+
+func (d *doctor) fail(hint, format string, args ...any) {
+	d.failed++
+	fmt.Printf("  FAIL  "+format+"\n", args...)
+	if hint != "" {
+		fmt.Println("        → " + hint)
+	}
+}
+
+// runDoctor checks the environment a run needs: Chrome, the configs, the
+// Storybook build and the snapshot directory.
+func runDoctor(args []string) int {
+	fset := flag.NewFlagSet("doctor", flag.ExitOnError)
+	ef := registerEnvFlags(fset)
+	_ = fset.Parse(args)
+
+	d := &doctor{}
+	fmt.Println("Chrome")
+	var chromeArgs []string
+	envs, err := ef.loadAll()
+	if err == nil {
+		chromeArgs = slices.Clone(envs[0].cfg.ChromeArgs)
+		if v := envs[0].chromeVersion(); v != "" {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+			if perr := pinChrome(ctx, v); perr != nil {
+				d.fail("check the version and the network, or remove chromeVersion to use the installed Chrome", "%v", perr)
+			} else {
+				d.ok("chromeVersion %s", v)
+			}
+			cancel()
+		}
+	}
+	flagArgs, argsErr := browser.ParseChromeArgs(*ef.chromeArgs)
+	if argsErr != nil {
+		d.fail("fix the quoting of -chromeArgs", "-chromeArgs: %v", argsErr)
+	}
+	d.chrome(append(chromeArgs, flagArgs...))
+
+	if err != nil {
+		fmt.Println("\nConfig")
+		d.fail("fix the base config or point -baseConfig at it", "%v", err)
+		return d.summary()
+	}
+	for _, e := range envs {
+		fmt.Println("\nConfig", e.baseDir)
+		d.configs(e)
+		if e.cfg.BaseURL != "" {
+			d.baseURL(e.cfg.BaseURL)
+		} else {
+			d.storybook(e)
+		}
+		d.snapshotDir(e.layout.Root)
+	}
+	return d.summary()
+}
+
+func (d *doctor) summary() int {
+	fmt.Printf("\n%d problems, %d warnings\n", d.failed, d.warned)
+	if d.failed > 0 {
+		return report.ExitFailures
+	}
+	return report.ExitOK
+}
+
+func (d *doctor) chrome(chromeArgs []string) {
+	path, err := browser.ChromePath()
+	switch {
+	case err != nil && os.Getenv("CHROME_BIN") != "":
+		d.fail("unset CHROME_BIN or point it at the Chrome binary", "%v", err)
+		return
+	case err != nil:
+		d.warn("install Chrome or Chromium, or set CHROME_BIN to its binary", "no Chrome found in the usual places, leaving the lookup to chromedp")
+	default:
+		d.ok("binary %s", path)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	brs, err := browser.LaunchPool(ctx, 1, chromeArgs)
+	if err != nil {
+		hint := "check that the binary starts: run it with --headless --dump-dom about:blank"
+		if path == "" {
+			hint = "install Chrome or Chromium, or set CHROME_BIN to its binary"
+		} else if runtime.GOOS == "linux" && os.Geteuid() == 0 && !slices.ContainsFunc(chromeArgs, func(a string) bool { return strings.Contains(a, "no-sandbox") }) {
+			hint = "Chrome refuses to run as root with its sandbox, add chromeArgs: [no-sandbox] or -chromeArgs no-sandbox"
+		}
+		d.fail(hint, "cannot launch Chrome: %v", err)
+		return
+	}
+	defer brs.CloseAll()
+
+	product, major, err := brs[0].Version(ctx)
+	switch {
+	case err != nil:
+		d.fail("", "cannot read the Chrome version: %v", err)
+	case major > 0 && major < browser.MinChromeMajor:
+		d.fail(fmt.Sprintf("update Chrome to %d or newer", browser.MinChromeMajor), "%s is too old", product)
+	default:
+		d.ok("launched %s", product)
+	}
+}
+
+func (d *doctor) configs(e *env) {
+	d.ok("base config %s", filepath.Join(e.baseDir, *e.flags.baseConfig))
+	discovered, err := e.cfg.FindAndParseConfigs(e.baseDir)
+	if err != nil {
+		d.fail("", "cannot search story configs: %v", err)
+		return
+	}
+	for _, fe := range discovered.Errors {
+		d.fail("fix the file or exclude its directory with ignorePatterns", "%s", fe.Error())
+	}
+	if len(discovered.Configs) == 0 {
+		d.warn(fmt.Sprintf("story configs are the files below -input that match testPattern %q", e.cfg.TestPattern), "no stories found")
+		return
+	}
+	d.ok("%d cases in story configs", len(discovered.Configs))
+}
+
+func (d *doctor) baseURL(u string) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(u)
+	if err != nil {
+		d.fail("start the site or fix baseUrl", "baseUrl %s: %v", u, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		d.warn("check that baseUrl points at the site", "baseUrl %s answers %s", u, resp.Status)
+		return
+	}
+	d.ok("baseUrl %s answers %s", u, resp.Status)
+}
+
+func (d *doctor) storybook(e *env) {
+	f := e.flags
+	if storybook.IsPortOpen(e.port, 200*time.Millisecond) {
+		if err := storybook.CheckStorybook(e.port); err != nil {
+			d.fail("stop the process on the port, pass another -storybookPort or set -storybookPortRange", "%v", err)
+		} else {
+			d.ok("storybook is running on port %d", e.port)
+		}
+	}
+
+	buildDir := filepath.Join(e.baseDir, *f.sbBuildDir)
+	if tools.FileExists(filepath.Join(buildDir, "iframe.html")) {
+		d.ok("storybook build %s", buildDir)
+		return
+	}
+	buildCmd := *f.sbBuildCmd
+	if strings.TrimSpace(buildCmd) == "" {
+		buildCmd = storybook.DetectPackageManager(e.baseDir) + " run " + storybook.DefaultBuildScript
+	}
+	parts, err := tools.SplitCommand(buildCmd)
+	if err != nil || len(parts) == 0 {
+		d.fail("fix -storybookBuildCmd", "build command %q: %v", buildCmd, err)
+		return
+	}
+	if _, err := exec.LookPath(parts[0]); err != nil {
+		d.fail(fmt.Sprintf("install %s or pass -storybookBuildCmd", parts[0]), "no storybook build in %s and %s is not in PATH", buildDir, parts[0])
+		return
+	}
+	d.warn("", "no storybook build in %s yet, the next run builds it with %q", buildDir, buildCmd)
+}
+
+func (d *doctor) snapshotDir(root string) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		d.fail("set outputDir to a writable directory", "cannot create snapshot directory: %v", err)
+		return
+	}
+	tmp, err := os.CreateTemp(root, ".qsnap-doctor-*")
+	if err != nil {
+		d.fail("fix the permissions or set outputDir to a writable directory", "snapshot directory %s is not writable: %v", root, err)
+		return
+	}
+	tmp.Close()
+	_ = os.Remove(tmp.Name())
+	d.ok("snapshot directory %s is writable", root)
+
+	free, err := tools.FreeDiskSpace(root)
+	switch {
+	case err != nil:
+		d.warn("", "cannot determine free disk space: %v", err)
+	case free < minFreeDisk:
+		d.warn("free up disk space, diff images and captures of a run can take several hundred MB", "only %s free on the snapshot volume", humanBytes(free))
+	default:
+		d.ok("%s free on the snapshot volume", humanBytes(free))
+	}
+}
+
+func humanBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
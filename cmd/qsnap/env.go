@@ -0,0 +1,553 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/maxischmaxi/qsnap/internal/browser"
+	"github.com/maxischmaxi/qsnap/internal/chromedl"
+	"github.com/maxischmaxi/qsnap/internal/config"
+	"github.com/maxischmaxi/qsnap/internal/events"
+	"github.com/maxischmaxi/qsnap/internal/firefox"
+	"github.com/maxischmaxi/qsnap/internal/plugin"
+	"github.com/maxischmaxi/qsnap/internal/procs"
+	"github.com/maxischmaxi/qsnap/internal/runner"
+	"github.com/maxischmaxi/qsnap/internal/snapshot"
+	"github.com/maxischmaxi/qsnap/internal/storage"
+	"github.com/maxischmaxi/qsnap/internal/storybook"
+	"github.com/maxischmaxi/qsnap/internal/tools"
+)
+
+// signalContext is cancelled by Ctrl+C/SIGTERM so running captures stop and
+// the report can still be written. A second signal kills the process.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+// envFlags are shared by every command that needs Storybook and a browser pool.
+type envFlags struct {
+	input       *inputList
+	baseConfig  *string
+	concurrency *int
+	instances   *int
+	tabsPerInst *int
+	recycle     *int
+	timeoutSec  *int
+	softTimeout *int
+	sbPort      *int
+	sbPortRange *string
+	sbBuildCmd  *string
+	sbBuildLog  *string
+	sbBuildDir  *string
+	sbForce     *bool
+	sbWaitSec   *int
+	sbHealth    *string
+	chromeArgs  *string
+	chromeVer   *string
+	engine      *string
+	sbVerify    *string
+	healthSec   *int
+	eventsAddr  *string
+	dashboard   *string
+	outputDir   *string
+	branch      *string
+}
+
+func registerEnvFlags(fset *flag.FlagSet) *envFlags {
+	return &envFlags{
+		input:       registerInputs(fset),
+		concurrency: fset.Int("concurrency", 10, "number of concurrent screenshot tasks"),
+		instances:   fset.Int("instances", 4, "number of browser instances to use"),
+		tabsPerInst: fset.Int("tabsPerInstance", 0, "maximum concurrent tabs per browser instance (0 = -concurrency divided by -instances)"),
+		recycle:     fset.Int("recycleAfter", 0, "restart a browser instance after it has served this many captures, once its open tabs are done, to keep memory flat on long runs (0 = never)"),
+		timeoutSec:  fset.Int("timeout", 30, "timeout in seconds for each screenshot task"),
+		softTimeout: fset.Int("softTimeout", 10, "warn about captures that take longer than this many seconds (0 disables)"),
+		baseConfig:  fset.String("baseConfig", "osnap.config.yaml", "path to the base osnap config file"),
+		sbPort:      fset.Int("storybookPort", 3000, "the port where storybook is running (if empty, assumes storybook is already running)"),
+		sbPortRange: fset.String("storybookPortRange", "", "if -storybookPort is taken by something that is not storybook, serve the build on the first free port of this range, e.g. 3000-3100 (default: fail)"),
+		sbBuildCmd:  fset.String("storybookBuildCmd", "", "the command to build storybook, quoted like in a shell (default: \"<npm|pnpm|yarn|bun> run project:build:storybook\", detected from the lockfile)"),
+		sbBuildLog:  fset.String("storybookBuildLog", filepath.Join(os.TempDir(), "qsnap-storybook-build.log"), "file that receives the output of the storybook build (empty discards it)"),
+		sbBuildDir:  fset.String("storybookBuildDir", "storybook-static", "the directory where the built storybook files are located (relative to -input)"),
+		sbForce:     fset.Bool("storybookForce", false, "force rebuild of storybook even if -storybookPort is set"),
+		sbWaitSec:   fset.Int("storybookWaitSec", 60, "how many seconds to wait for storybook to become available"),
+		sbHealth:    fset.String("sb-health-path", "/index.html", "the HTTP path to check for storybook health"),
+		sbVerify:    fset.String("storybookVerify", "off", "check that an already running server serves the local build: off, warn or fail"),
+		healthSec:   fset.Int("healthCheckSec", 10, "how often to check that Chrome instances still respond and relaunch dead ones (0 disables)"),
+		outputDir:   fset.String("outputDir", "", "directory for __base_images__, __diff__ and __actual__ (relative to -input; default: the config's outputDir or <input>/../__image-snapshots__)"),
+		branch:      fset.String("branch", "", "git branch for branchBaselines (default: from the CI environment or git)"),
+		eventsAddr:  fset.String("eventsAddr", "", "serve run events as JSON over a local WebSocket on this address, e.g. 127.0.0.1:7357"),
+		dashboard:   fset.String("serveDashboard", "", "serve a live web UI of the run with the images of failed cases on this address, e.g. :8088"),
+		chromeVer:   fset.String("chromeVersion", "", "download and use this Chrome for Testing build: a version like 126.0.6478.126, a milestone like 126, or stable, beta, dev or canary (default: the config's chromeVersion)"),
+		engine:      fset.String("browser", "", "engine for stories without their own browser, chrome or firefox (default: the base config's browser)"),
+		chromeArgs:  fset.String("chromeArgs", "", "additional arguments for the Chrome instances, comma-separated (\"no-sandbox,lang=de-DE,hide-scrollbars=false\") or as flags (\"--disable-features=A,B --user-data-dir=/tmp/qsnap-{id}\"); {id} is replaced by the instance number"),
+	}
+}
+
+type env struct {
+	flags    *envFlags
+	baseDir  string
+	cfg      *config.OsnapBaseConfig
+	layout   config.Layout
+	ctrl     *storybook.Controller
+	port     int
+	browsers browser.Instances
+	firefox  *lazyFirefox
+	plugins  plugin.Set
+	events   *events.Hub
+	// shared: Browser, Plugins und Events gehören der ersten Umgebung
+	shared bool
+}
+
+// inputList is -input: comma-separated and/or repeated directories.
+type inputList []string
+
+func registerInputs(fset *flag.FlagSet) *inputList {
+	l := &inputList{}
+	fset.Var(l, "input", "the storybook directory you want to run snapshot tests in; several comma-separated or repeated directories share one browser pool and report (default \".\")")
+	return l
+}
+
+func (l *inputList) String() string { return strings.Join(*l, ",") }
+
+func (l *inputList) Set(v string) error {
+	for _, d := range strings.Split(v, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			*l = append(*l, d)
+		}
+	}
+	return nil
+}
+
+func (l *inputList) dirs() []string {
+	if len(*l) == 0 {
+		return []string{"."}
+	}
+	return *l
+}
+
+// load is used by commands that work on a single -input.
+func (f *envFlags) load() (*env, error) {
+	if len(f.input.dirs()) > 1 {
+		return nil, errors.New("this command supports only one -input directory")
+	}
+	envs, err := f.loadAll()
+	if err != nil {
+		return nil, err
+	}
+	return envs[0], nil
+}
+
+// loadAll returns one env per -input directory. Each serves its own
+// Storybook on -storybookPort plus its index.
+func (f *envFlags) loadAll() ([]*env, error) {
+	switch *f.sbVerify {
+	case "off", "warn", "fail":
+	default:
+		return nil, fmt.Errorf("invalid -storybookVerify %q: expected off, warn or fail", *f.sbVerify)
+	}
+
+	if *f.engine != "" && !slices.Contains(config.Browsers, *f.engine) {
+		return nil, fmt.Errorf("invalid -browser %q: expected one of %s", *f.engine, strings.Join(config.Browsers, ", "))
+	}
+
+	var envs []*env
+	// Firefox startet erst mit dem ersten Case, der es braucht, und gehört allen Eingaben
+	ff := &lazyFirefox{}
+	for i, dir := range f.input.dirs() {
+		baseDir, err := tools.ExpandPath(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg, err := config.NewOsnapBaseConfig(filepath.Join(baseDir, *f.baseConfig))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", dir, err)
+		}
+		if *f.engine != "" {
+			cfg.Browser = *f.engine
+		}
+		layout := cfg.Layout(baseDir)
+		if out := *f.outputDir; out != "" {
+			if !filepath.IsAbs(out) {
+				out = filepath.Join(baseDir, out)
+			}
+			layout.Root = filepath.Clean(out)
+		}
+		if cfg.BranchBaselines {
+			if layout.Branch, err = f.baselineBranch(baseDir, cfg.MainBranch); err != nil {
+				return nil, err
+			}
+		}
+		envs = append(envs, &env{flags: f, baseDir: baseDir, cfg: cfg, layout: layout, port: *f.sbPort + i, firefox: ff})
+	}
+	return envs, nil
+}
+
+// baselineBranch returns the branch whose baselines are used, or "" on the
+// main branch.
+func (f *envFlags) baselineBranch(baseDir, mainBranch string) (string, error) {
+	branch := *f.branch
+	if branch == "" {
+		var err error
+		if branch, err = tools.CurrentBranch(baseDir); err != nil {
+			return "", fmt.Errorf("branchBaselines: cannot detect the git branch, pass -branch: %w", err)
+		}
+		if branch == "" {
+			fmt.Println("branchBaselines: detached HEAD, using the", mainBranch, "baselines")
+		}
+	}
+	if branch == mainBranch || config.BranchSlug(branch) == "" {
+		return "", nil
+	}
+	fmt.Printf("branchBaselines: using baselines of %s, falling back to %s\n", branch, mainBranch)
+	return branch, nil
+}
+
+// envOfSource returns the env whose -input contains the story file source.
+func envOfSource(envs []*env, source string) *env {
+	for _, e := range envs {
+		if rel, err := filepath.Rel(e.baseDir, source); err == nil && !strings.HasPrefix(rel, "..") {
+			return e
+		}
+	}
+	return envs[0]
+}
+
+// startAll starts the first env completely and only Storybook for the others,
+// which share its browser pool, plugins and event hub.
+func startAll(ctx context.Context, envs []*env) error {
+	first := envs[0]
+	if err := first.start(ctx); err != nil {
+		return err
+	}
+	for _, e := range envs[1:] {
+		e.browsers, e.plugins, e.events, e.shared = first.browsers, first.plugins, first.events, true
+		e.events.AllowImages(e.layout.Root)
+		if err := e.startSite(ctx); err != nil {
+			return fmt.Errorf("%s: %w", e.baseDir, err)
+		}
+	}
+	return nil
+}
+
+// start builds and serves storybook if needed and launches the browser pool.
+// With baseUrl set the stories are loaded from there and nothing is built or served.
+func (e *env) start(ctx context.Context) error {
+	if err := e.startHooks(); err != nil {
+		return err
+	}
+	if err := e.startSite(ctx); err != nil {
+		return err
+	}
+	return e.launchBrowsers(ctx)
+}
+
+// startHooks starts the event hub, dashboard and plugins, everything a run
+// needs that does not capture itself.
+func (e *env) startHooks() error {
+	if addr := *e.flags.eventsAddr; addr != "" {
+		hub, err := events.Listen(addr)
+		if err != nil {
+			return err
+		}
+		e.events = hub
+		fmt.Println("serving run events on ws://" + hub.Addr())
+	}
+	if addr := *e.flags.dashboard; addr != "" {
+		if e.events == nil {
+			e.events = events.New()
+		}
+		a, err := e.events.ServeDashboard(addr)
+		if err != nil {
+			return err
+		}
+		fmt.Println("serving the dashboard on " + dashboardURL(a))
+	}
+	e.events.AllowImages(e.layout.Root)
+
+	plugins, err := plugin.StartAll(e.cfg.Plugins, e.baseDir)
+	if err != nil {
+		return err
+	}
+	e.plugins = plugins
+	return nil
+}
+
+func (e *env) startSite(ctx context.Context) error {
+	if e.cfg.BaseURL != "" {
+		fmt.Println("using baseUrl", e.cfg.BaseURL, "- skipping storybook build and serve")
+		return nil
+	}
+	return e.startStorybook(ctx)
+}
+
+func (e *env) startStorybook(ctx context.Context) error {
+	f := e.flags
+	buildDir := filepath.Join(e.baseDir, *f.sbBuildDir)
+
+	if err := storybook.BuildIfNeeded(ctx, *f.sbBuildCmd, buildDir, e.baseDir, *f.sbForce, *f.sbBuildLog); err != nil {
+		return err
+	}
+
+	if err := e.checkPort(); err != nil {
+		return err
+	}
+	ctrl, started, err := storybook.ServeBuildIfNeeded(
+		ctx,
+		e.port,
+		buildDir,
+		*f.sbHealth,
+		time.Duration(*f.sbWaitSec)*time.Second,
+		"",
+	)
+	if err != nil {
+		return err
+	}
+	e.ctrl = ctrl
+
+	if started {
+		fmt.Println("started storybook server on port", e.port)
+		_ = procs.Write("server", os.Getpid())
+	} else {
+		fmt.Println("using existing storybook server on port", e.port)
+		if *f.sbVerify != "off" {
+			if err := storybook.VerifyBuild(e.port, buildDir); err != nil {
+				if *f.sbVerify == "fail" {
+					return err
+				}
+				fmt.Println("warning:", err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkPort makes sure a server on e.port is storybook. Otherwise a free port
+// from -storybookPortRange is used, so pageURL points at our own server.
+func (e *env) checkPort() error {
+	if !storybook.IsPortOpen(e.port, 200*time.Millisecond) {
+		return nil
+	}
+	err := storybook.CheckStorybook(e.port)
+	if err == nil {
+		return nil
+	}
+	first, last, rangeErr := storybook.ParsePortRange(*e.flags.sbPortRange)
+	if rangeErr != nil {
+		return fmt.Errorf("-storybookPortRange: %w", rangeErr)
+	}
+	if first == 0 {
+		return fmt.Errorf("%w (use another -storybookPort or set -storybookPortRange)", err)
+	}
+	port, err := storybook.FreePort(first, last)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("port %d is taken by a server that is not storybook, using port %d\n", e.port, port)
+	e.port = port
+	return nil
+}
+
+func (e *env) launchBrowsers(ctx context.Context) error {
+	f := e.flags
+	flagArgs, err := browser.ParseChromeArgs(*f.chromeArgs)
+	if err != nil {
+		return fmt.Errorf("-chromeArgs: %w", err)
+	}
+	// -chromeArgs kommt nach der Config und gewinnt damit bei gleichen Flags
+	chromeArgsList := append(slices.Clone(e.cfg.ChromeArgs), flagArgs...)
+	if len(chromeArgsList) > 0 {
+		fmt.Println("Using additional Chrome args:", chromeArgsList)
+	}
+
+	if err := pinChrome(ctx, e.chromeVersion()); err != nil {
+		return err
+	}
+	brs, err := browser.LaunchPool(ctx, max(*f.instances, 1), chromeArgsList)
+	if err != nil {
+		return err
+	}
+	e.browsers = brs
+	// Default: Concurrency gleichmäßig auf die Instanzen verteilen
+	tabs := *f.tabsPerInst
+	if tabs == 0 {
+		tabs = (max(*f.concurrency, 1) + len(brs) - 1) / len(brs)
+	}
+	brs.SetTabLimit(tabs)
+	brs.SetRecycleAfter(*f.recycle)
+	if *f.healthSec > 0 {
+		go brs.WatchHealth(ctx, time.Duration(*f.healthSec)*time.Second)
+	}
+
+	return nil
+}
+
+// chromeVersion is -chromeVersion or the config's chromeVersion.
+func (e *env) chromeVersion() string {
+	if v := *e.flags.chromeVer; v != "" {
+		return v
+	}
+	return e.cfg.ChromeVersion
+}
+
+// pinChrome downloads the Chrome for Testing build version into the cache
+// if needed and launches it instead of the installed Chrome.
+func pinChrome(ctx context.Context, version string) error {
+	if version == "" {
+		return nil
+	}
+	c := &chromedl.Client{Progress: func(format string, args ...any) { fmt.Printf(format+"\n", args...) }}
+	path, err := c.Install(ctx, version)
+	if err != nil {
+		return fmt.Errorf("chromeVersion: %w", err)
+	}
+	browser.UseChrome(path)
+	return nil
+}
+
+// lazyFirefox launches Firefox for the first case that asks for it.
+type lazyFirefox struct {
+	mu  sync.Mutex
+	b   *firefox.Browser
+	err error
+}
+
+// get returns the running Firefox. A failed launch is not retried, every
+// later case gets the same error.
+func (l *lazyFirefox) get(ctx context.Context) (*firefox.Browser, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.b != nil || l.err != nil {
+		return l.b, l.err
+	}
+	fmt.Println("launching firefox")
+	b, err := firefox.Launch(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		l.err = fmt.Errorf("firefox: %w", err)
+		return nil, l.err
+	}
+	l.b = b
+	return b, nil
+}
+
+func (l *lazyFirefox) close() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.b != nil {
+		l.b.Close()
+		l.b = nil
+	}
+}
+
+// dashboardURL macht aus ":8088" bzw. "[::]:8088" eine klickbare URL
+func dashboardURL(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "http://" + addr
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+func (e *env) close() {
+	if !e.shared {
+		e.events.Close()
+		e.plugins.Close()
+		if e.browsers != nil {
+			e.browsers.CloseAll()
+		}
+		e.firefox.close()
+	}
+	if e.ctrl != nil {
+		e.ctrl.Stop()
+		procs.Remove("server", os.Getpid())
+	}
+}
+
+// pageURL resolves a path against baseUrl or the local storybook server.
+func (e *env) pageURL(path string) string {
+	if base := e.cfg.BaseURL; base != "" {
+		return strings.TrimRight(base, "/") + "/" + strings.TrimLeft(path, "/")
+	}
+	return fmt.Sprintf("http://127.0.0.1:%d%s", e.port, path)
+}
+
+func (e *env) storyURL(s *config.OsnapConfig) string {
+	u := e.pageURL(s.URL)
+	if e.cfg.Seed != nil {
+		u = snapshot.WithSeedGlobal(u, *e.cfg.Seed)
+	}
+	return u
+}
+
+func (e *env) timeout() time.Duration {
+	return time.Duration(*e.flags.timeoutSec) * time.Second
+}
+
+func (e *env) softTimeout() time.Duration {
+	return time.Duration(*e.flags.softTimeout) * time.Second
+}
+
+// selectConfigs applies `only: true` first, then -filter and -limit.
+func selectConfigs(configs []*config.OsnapConfig, filter string, limit int) ([]*config.OsnapConfig, error) {
+	configs, only := config.Only(configs)
+	if only {
+		fmt.Println("only running the", len(configs), "cases marked with only: true")
+	}
+	configs, err := config.Filter(configs, filter)
+	if err != nil {
+		return nil, err
+	}
+	if filter != "" {
+		fmt.Println("filter", filter, "matched", len(configs), "cases")
+	}
+	if limit > 0 && limit < len(configs) {
+		configs = configs[:limit]
+		fmt.Println("limiting to first", limit, "stories")
+	}
+	return configs, nil
+}
+
+func (e *env) captureOptions(s *config.OsnapConfig) snapshot.Options {
+	return snapshot.StoryOptions(s, e.cfg.Seed)
+}
+
+// runner returns a case runner on e's browsers, plugins and event hub.
+func (e *env) runner(store storage.Backend) *runner.Runner {
+	return &runner.Runner{
+		Config:      e.cfg,
+		Layout:      e.layout,
+		Store:       store,
+		Browsers:    e.browsers,
+		Firefox:     e.firefox.get,
+		URL:         e.storyURL,
+		Timeout:     e.timeout(),
+		SoftTimeout: e.softTimeout(),
+		Plugins:     e.plugins,
+		Events:      e.events,
+	}
+}
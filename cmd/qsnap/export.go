@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/maxischmaxi/qsnap/internal/export"
+	"github.com/maxischmaxi/qsnap/internal/report"
+	"github.com/maxischmaxi/qsnap/internal/tools"
+)
+
+// runExport converts the last report for review tools of other projects.
+func runExport(args []string) int {
+	fset := flag.NewFlagSet("export", flag.ExitOnError)
+	var (
+		input      = fset.String("input", ".", "the storybook directory the report belongs to")
+		reportFile = fset.String("report", "report.json", "path to the report to export (relative to -input)")
+		format     = fset.String("format", "backstop", "export format: "+strings.Join(export.Formats(), ", "))
+		out        = fset.String("out", "qsnap-export", "output directory (relative to -input)")
+	)
+	_ = fset.Parse(args)
+
+	baseDir, err := tools.ExpandPath(*input)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rep, err := report.Read(filepath.Join(baseDir, *reportFile))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dir := filepath.Join(baseDir, *out)
+	if err := export.Write(*format, rep, dir); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("exported %d cases as %s to %s\n", len(rep.Cases), *format, dir)
+	return report.ExitOK
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/maxischmaxi/qsnap/internal/config"
+	"github.com/maxischmaxi/qsnap/internal/importer"
+	"github.com/maxischmaxi/qsnap/internal/report"
+	"github.com/maxischmaxi/qsnap/internal/tools"
+	"gopkg.in/yaml.v3"
+)
+
+// runImport copies baselines from osnap, Loki, BackstopJS, Percy or Chromatic into the qsnap
+// layout and writes a story config for the imported cases.
+func runImport(args []string) int {
+	fset := flag.NewFlagSet("import", flag.ExitOnError)
+	var (
+		input      = fset.String("input", ".", "the storybook directory to import into")
+		baseConfig = fset.String("baseConfig", "osnap.config.yaml", "path to the base osnap config file")
+		from       = fset.String("from", "", "tool to import from: "+strings.Join(importer.Sources, ", "))
+		source     = fset.String("source", "", "osnap snapshot directory, loki project directory, backstop.json or percy/chromatic screenshots in qsnap's <snapshot>/<width>.png layout (default: -input)")
+		sbIndex    = fset.String("storybookIndex", "", "Storybook index.json used to map loki, percy and chromatic snapshots to stories (default: storybook-static/index.json)")
+		out        = fset.String("out", "imported.osnap.yaml", "story config file to write (relative to -input)")
+		overwrite  = fset.Bool("overwrite", false, "replace baselines that already exist")
+		dryRun     = fset.Bool("dry-run", false, "only print what would be imported")
+	)
+	_ = fset.Parse(args)
+
+	baseDir, err := tools.ExpandPath(*input)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg, err := config.NewOsnapBaseConfig(filepath.Join(baseDir, *baseConfig))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	src := *source
+	if src == "" {
+		src = baseDir
+	}
+	if src, err = tools.ExpandPath(src); err != nil {
+		log.Fatal(err)
+	}
+
+	plan, err := importer.Import(*from, importer.Options{Source: src, StorybookIndex: *sbIndex, Dir: baseDir})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, w := range plan.Warnings {
+		fmt.Println("warning:", w)
+	}
+
+	copied, kept := 0, 0
+	for _, b := range plan.Baselines {
+		s := &config.OsnapConfig{Name: b.Name, Width: b.Width, Height: b.Height, Browser: config.DefaultBrowser}
+		_, dst := cfg.Layout(baseDir).CasePaths(s)
+		if tools.FileExists(dst) && !*overwrite {
+			fmt.Printf("%s - baseline exists, keeping it\n", dst)
+			kept++
+			continue
+		}
+		fmt.Printf("%s -> %s\n", b.Src, dst)
+		if *dryRun {
+			continue
+		}
+		data, err := os.ReadFile(b.Src)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := tools.WriteFile(dst, data); err != nil {
+			log.Fatal(err)
+		}
+		copied++
+	}
+
+	if len(plan.Stories) > 0 {
+		outPath := filepath.Join(baseDir, *out)
+		if !cfg.IsTestFile(baseDir, outPath) {
+			fmt.Printf("warning: %s does not match testPattern %q and will not be picked up\n", outPath, cfg.TestPattern)
+		}
+		data, err := yaml.Marshal(plan.Stories)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("writing %d stories to %s\n", len(plan.Stories), outPath)
+		if !*dryRun {
+			if tools.FileExists(outPath) && !*overwrite {
+				log.Fatalf("%s exists, use -overwrite to replace it", outPath)
+			}
+			if err := tools.WriteFile(outPath, data); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	fmt.Printf("imported %d baselines (%d kept, %d warnings)\n", copied, kept, len(plan.Warnings))
+	if len(plan.Warnings) > 0 {
+		return report.ExitFailures
+	}
+	return report.ExitOK
+}
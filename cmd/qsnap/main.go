@@ -2,211 +2,680 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/maxischmaxi/qsnap/internal/browser"
+	"github.com/maxischmaxi/qsnap/internal/cache"
+	"github.com/maxischmaxi/qsnap/internal/compare"
 	"github.com/maxischmaxi/qsnap/internal/config"
-	"github.com/maxischmaxi/qsnap/internal/diff"
+	"github.com/maxischmaxi/qsnap/internal/events"
+	"github.com/maxischmaxi/qsnap/internal/logging"
+	"github.com/maxischmaxi/qsnap/internal/notify"
 	"github.com/maxischmaxi/qsnap/internal/pool"
 	"github.com/maxischmaxi/qsnap/internal/report"
+	"github.com/maxischmaxi/qsnap/internal/runner"
 	"github.com/maxischmaxi/qsnap/internal/snapshot"
-	"github.com/maxischmaxi/qsnap/internal/storybook"
+	"github.com/maxischmaxi/qsnap/internal/storage"
 	"github.com/maxischmaxi/qsnap/internal/tools"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "approve":
+			os.Exit(runApprove(os.Args[2:]))
+		case "ab":
+			os.Exit(runAB(os.Args[2:]))
+		case "calibrate":
+			os.Exit(runCalibrate(os.Args[2:]))
+		case "cleanup":
+			os.Exit(runCleanup(os.Args[2:]))
+		case "clean":
+			os.Exit(runClean(os.Args[2:]))
+		case "watch":
+			os.Exit(runWatch(os.Args[2:]))
+		case "export":
+			os.Exit(runExport(os.Args[2:]))
+		case "review":
+			os.Exit(runReview(os.Args[2:]))
+		case "import":
+			os.Exit(runImport(os.Args[2:]))
+		case "report-diff":
+			os.Exit(runReportDiff(os.Args[2:]))
+		case "serve-api":
+			os.Exit(runServeAPI(os.Args[2:]))
+		case "trends":
+			os.Exit(runTrends(os.Args[2:]))
+		case "worker":
+			os.Exit(runWorker(os.Args[2:]))
+		case "merge-reports":
+			os.Exit(runMergeReports(os.Args[2:]))
+		case "doctor":
+			os.Exit(runDoctor(os.Args[2:]))
+		case "version":
+			os.Exit(runVersion(os.Args[2:]))
+		case "self-update":
+			os.Exit(runSelfUpdate(os.Args[2:]))
+		}
+	}
+	os.Exit(run())
+}
+
+func run() int {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 	fmt.Printf("num of cpu cores: %d\n", runtime.NumCPU())
 
+	ef := registerEnvFlags(flag.CommandLine)
 	var (
-		input       = flag.String("input", ".", "the storybook directory you want to run snapshot tests in")
-		concurrency = flag.Int("concurrency", 10, "number of concurrent screenshot tasks")
-		instances   = flag.Int("instances", 4, "number of browser instances to use")
-		timeoutSec  = flag.Int("timeout", 30, "timeout in seconds for each screenshot task")
-		baseConfig  = flag.String("baseConfig", "osnap.config.yaml", "path to the base osnap config file")
-		sbPort      = flag.Int("storybookPort", 3000, "the port where storybook is running (if empty, assumes storybook is already running)")
-		sbBuildCmd  = flag.String("storybookBuildCmd", "npm run project:build:storybook", "the command to build storybook (only if -storybookPort is empty)")
-		sbBuildDir  = flag.String("storybookBuildDir", "storybook-static", "the directory where the built storybook files are located (relative to -input)")
-		sbForce     = flag.Bool("storybookForce", false, "force rebuild of storybook even if -storybookPort is set")
-		sbWaitSec   = flag.Int("storybookWaitSec", 60, "how many seconds to wait for storybook to become available")
-		sbHealth    = flag.String("sb-health-path", "/index.html", "the HTTP path to check for storybook health")
-		chromeArgs  = flag.String("chromeArgs", "", "additional comma-separated arguments to pass to Chrome instances")
 		limit       = flag.Int("limit", 0, "if > 0, only process this many stories from the config files")
+		filter      = flag.String("filter", "", "only run stories whose name or URL matches this glob (or regex with a \"re:\" prefix)")
+		updateMiss  = flag.Bool("updateMissing", false, "shorthand for -updateBaselines missing: accept the captures of new cases as their baselines")
+		updateBase  = flag.String("updateBaselines", "", "write captures as baselines and report them as created/updated: missing (only cases without a baseline) or all (also overwrite failing baselines)")
+		prComment   = flag.String("baselineComment", "", "write a Markdown list of created and updated baselines to this file, e.g. for a PR comment")
+		emitNew     = flag.Bool("emitNew", false, "write captures of cases without a baseline to their output path as soon as they are taken")
+		requireBase = flag.Bool("requireBaselines", false, "abort before capturing if any case has no baseline")
+		strictCfg   = flag.Bool("strictConfig", false, "abort if any story config file cannot be parsed")
+		forbidOnly  = flag.Bool("forbidOnly", false, "abort if any story is marked with only: true (for CI, so a focused run isn't merged by accident)")
+		reportFmt   = flag.String("reportFormat", "json", "comma-separated report formats to write: json, junit, markdown")
+		annotations = flag.String("githubAnnotations", "auto", "print GitHub Actions ::error annotations and ::group:: sections per story file: auto (when GITHUB_ACTIONS is set), on or off")
+		stepSummary = flag.Bool("stepSummary", false, "append a Markdown summary to $GITHUB_STEP_SUMMARY")
+		imageBase   = flag.String("summaryImageBase", "", "URL where CI publishes __image-snapshots__; image links in Markdown summaries point below it")
+		topN        = flag.Int("analyticsTop", 5, "number of near-misses and worst failures to list in the report analytics")
+		noBaseline  = flag.String("noBaselineAs", "fail", "how cases without a baseline affect the exit code: pass, fail or error")
+		badge       = flag.String("badge", "", "also write an SVG summary badge (passed/total) to this path")
+		reviewAfter = flag.Bool("review", false, "open the interactive review for failed and new cases after the run (needs a terminal)")
+		cacheFile   = flag.String("cache", "", "skip cases whose inputs are unchanged since they last passed, using this cache file (relative to -input)")
+		sampleFlag  = flag.String("sample", "", "only run a random subset of stories, e.g. 10% or 25")
+		sampleSeed  = flag.Uint64("sampleSeed", 0, "seed for -sample (0 picks one and prints it)")
+		resultsFile = flag.String("resultsFile", "results.ndjson", "stream each finished case as a JSON line to this file (relative to -input), so results survive a crash; empty disables it")
+		maxDuration = flag.Duration("maxDuration", 0, "stop starting cases when the run gets close to this duration, e.g. 20m, and report the rest as skipped-deadline (0 = no limit)")
+		failFast    = flag.Bool("failFast", false, "stop the run at the first case that fails or errors and write a partial report")
+		failedFirst = flag.Bool("failedFirst", false, "run the cases that failed or errored in the previous report.json first")
+		resume      = flag.Bool("resume", false, "continue an interrupted run: keep the cases already in -resultsFile and only run the rest")
+		historyDir  = flag.String("historyDir", "", "also archive the JSON report of every run in this directory (relative to -input), for qsnap trends")
+		workersFlag = flag.String("workers", "", "comma-separated qsnap worker addresses (host:port) to run the cases on instead of local browsers, see qsnap worker")
+		workerToken = flag.String("workerToken", "", "bearer token the workers require (default: $QSNAP_WORKER_TOKEN)")
+		workerBatch = flag.Int("workerBatch", 20, "number of cases sent to a worker at once")
+		shardFlag   = flag.String("shard", "", "only run shard <index>/<total> of the cases, e.g. 2/5 (combine reports with qsnap merge-reports)")
+		logLevel    = flag.String("logLevel", "info", "minimum level of diagnostic log messages: debug, info, warn or error")
+		logFile     = flag.String("logFile", "", "append diagnostic log messages to this file instead of stderr")
+		logJSON     = flag.Bool("logJSON", false, "write diagnostic log messages as JSON lines")
 	)
 
 	flag.Parse()
 
-	chromeArgsList := []string{}
-	if *chromeArgs != "" {
-		chromeArgsList = strings.Split(*chromeArgs, ",")
-	}
-	if len(chromeArgsList) > 0 {
-		fmt.Println("Using additional Chrome args:", chromeArgsList)
+	closeLog, err := logging.Init(*logLevel, *logFile, *logJSON)
+	if err != nil {
+		log.Fatal(err)
 	}
+	defer closeLog()
+
+	rootCtx, stop := signalContext()
+	defer stop()
+	started := time.Now()
 
-	baseDir, err := tools.ExpandPath(*input)
+	noBaselineStatus, err := report.ParseNoBaselinePolicy(*noBaseline)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	cfg, err := config.NewOsnapBaseConfig(filepath.Join(baseDir, *baseConfig))
+	formats, err := report.ParseFormats(*reportFmt)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if *updateMiss {
+		if *updateBase == "all" {
+			log.Fatal("-updateMissing and -updateBaselines all contradict each other")
+		}
+		*updateBase = "missing"
+	}
+	switch *annotations {
+	case "auto", "on", "off":
+	default:
+		log.Fatalf("invalid -githubAnnotations %q: expected auto, on or off", *annotations)
+	}
+	switch *updateBase {
+	case "", "missing", "all":
+	default:
+		log.Fatalf("invalid -updateBaselines %q: expected missing or all", *updateBase)
+	}
 
-	configs, err := cfg.FindAndParseConfigs(*input)
+	shard, err := config.ParseShard(*shardFlag)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	err = os.MkdirAll(cfg.SnapshotDirectory, 0o755)
+	sample, err := config.ParseSample(*sampleFlag)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	rootCtx, rootCancel := context.WithCancel(context.Background())
-	defer rootCancel()
-
-	if err := storybook.BuildIfNeeded(rootCtx, *sbBuildCmd, filepath.Join(baseDir, *sbBuildDir), baseDir, *sbForce); err != nil {
-		log.Fatal(err)
+	var workers []string
+	if *workersFlag != "" {
+		for _, w := range strings.Split(*workersFlag, ",") {
+			if w = strings.TrimSpace(w); w != "" {
+				workers = append(workers, w)
+			}
+		}
+		if *workerToken == "" {
+			*workerToken = os.Getenv("QSNAP_WORKER_TOKEN")
+		}
+		if *workerBatch < 1 {
+			log.Fatal("-workerBatch must be at least 1")
+		}
 	}
 
-	ctrl, started, err := storybook.ServeBuildIfNeeded(
-		rootCtx,
-		*sbPort,
-		filepath.Join(baseDir, *sbBuildDir),
-		*sbHealth,
-		time.Duration(*sbWaitSec)*time.Second,
-		"",
-	)
+	envs, err := ef.loadAll()
 	if err != nil {
 		log.Fatal(err)
 	}
+	if len(workers) > 0 && len(envs) > 1 {
+		log.Fatal("-workers supports only one -input directory")
+	}
 	defer func() {
-		if ctrl != nil {
-			ctrl.Stop()
+		for _, e := range envs {
+			e.close()
 		}
 	}()
+	// Reports, Plugins und Events gehören zum ersten -input
+	e := envs[0]
+	baseDir := e.baseDir
 
-	if started {
-		fmt.Println("started storybook server on port", *sbPort)
-	} else {
-		fmt.Println("using existing storybook server on port", *sbPort)
-	}
+	// Cases aller Inputs laufen durch eine gemeinsame Auswahl; envOf merkt sich,
+	// zu welchem Input ein Case gehört
+	var (
+		configs []*config.OsnapConfig
+		skipped []report.SkippedConfig
+		envOf   = map[*config.OsnapConfig]*env{}
+		stores  = map[*env]storage.Backend{}
+	)
+	for _, e := range envs {
+		discovered, err := e.cfg.FindAndParseConfigs(e.baseDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, fe := range discovered.Errors {
+			fmt.Println("skipping config:", fe.Error())
+			skipped = append(skipped, report.SkippedConfig{Path: fe.Path, Error: fe.Err.Error()})
+		}
+		for _, s := range discovered.Configs {
+			envOf[s] = e
+		}
+		configs = append(configs, discovered.Configs...)
 
-	instancesClamped := max(*instances, 1)
+		if stores[e], err = storage.New(e.cfg.BaselineStorage); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(skipped) > 0 && *strictCfg {
+		log.Printf("%d config files could not be parsed and -strictConfig is set", len(skipped))
+		return report.ExitErrors
+	}
 
-	brs, err := browser.LaunchPool(rootCtx, instancesClamped, chromeArgsList)
+	if *forbidOnly {
+		if focused, only := config.Only(configs); only {
+			for _, s := range focused {
+				fmt.Printf("only: true in %s (%s)\n", s.Source, s.Name)
+			}
+			log.Printf("%d cases are marked with only: true and -forbidOnly is set", len(focused))
+			return report.ExitErrors
+		}
+	}
+	configsToProcess, err := selectConfigs(configs, *filter, *limit)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer brs.CloseAll()
-
-	wp := pool.New(*concurrency)
-	results := make([]report.CaseResult, len(configs))
-	waitSelList := snapshot.ParseSelectors("#storybook-root, #root")
-
-	configsToProcess := configs
-	if *limit > 0 && *limit < len(configs) {
-		configsToProcess = configs[:*limit]
-		fmt.Println("limiting to first", *limit, "stories")
+	if sample != nil {
+		seed := *sampleSeed
+		if seed == 0 {
+			seed = uint64(time.Now().UnixNano())
+		}
+		configsToProcess = sample.Apply(configsToProcess, seed)
+		fmt.Printf("sampled %d cases with -sampleSeed %d\n", len(configsToProcess), seed)
+	}
+	if shard != nil {
+		configsToProcess = shard.Apply(configsToProcess)
+		fmt.Printf("shard %d/%d has %d cases\n", shard.Index, shard.Total, len(configsToProcess))
 	}
 
 	fmt.Println("Processing", len(configsToProcess), "stories")
 
-	for i, s := range configsToProcess {
-		i, s := i, s // capture loop variables
-
-		wp.Go(func() {
-			b := brs.Pick()
+	for _, e := range envs {
+		store := stores[e]
+		if store == nil {
+			continue
+		}
+		var own []*config.OsnapConfig
+		for _, s := range configsToProcess {
+			if envOf[s] == e {
+				own = append(own, s)
+			}
+		}
+		pulled, err := compare.PullBaselines(rootCtx, store, e.layout, own)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("pulled", pulled, "baselines from", e.cfg.BaselineStorage)
+	}
 
-			ctx, cancel := context.WithTimeout(rootCtx, time.Duration(*timeoutSec)*time.Second)
-			defer cancel()
+	// Cases ohne Baseline zuerst, damit neue Stories schnell ihre Bilder bekommen
+	missing := make([]bool, len(configsToProcess))
+	order := make([]int, 0, len(configsToProcess))
+	for i, s := range configsToProcess {
+		baselinePath := envOf[s].layout.Baseline(s)
+		if !s.Skip.Enabled && !tools.FileExists(baselinePath) {
+			missing[i] = true
+			order = append(order, i)
+		}
+	}
+	if n := len(order); n > 0 {
+		fmt.Printf("%d of %d cases have no baseline:\n", n, len(configsToProcess))
+		for _, i := range order {
+			s := configsToProcess[i]
+			baselinePath := envOf[s].layout.Baseline(s)
+			fmt.Printf("  - %s (%dx%d): %s\n", s.Name, s.Width, s.Height, baselinePath)
+		}
+		if *requireBase {
+			log.Printf("missing baselines and -requireBaselines is set")
+			return report.ExitErrors
+		}
+	} else {
+		fmt.Println("all", len(configsToProcess), "cases have a baseline")
+	}
+	for i := range configsToProcess {
+		if !missing[i] {
+			order = append(order, i)
+		}
+	}
+	if *failedFirst {
+		if prev, err := report.Read(filepath.Join(baseDir, "report.json")); err == nil {
+			if n := failedFirstOrder(order, matchResumed(configsToProcess, envOf, prev.Cases)); n > 0 {
+				fmt.Printf("running %d cases that failed in the previous run first\n", n)
+			}
+		}
+	}
 
-			filename := fmt.Sprintf("%s_%dx%d.png", s.Name, s.Width, s.Height)
+	for _, e := range envs {
+		if err := os.MkdirAll(e.cfg.SnapshotDirectory, 0o755); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-			diffPath := filepath.Join(baseDir, "..", "__image-snapshots__", "__diff__", filename)
-			baselinePath := filepath.Join(baseDir, "..", "__image-snapshots__", "__base_images__", filename)
+	if len(workers) > 0 {
+		// die Worker bauen, serven und capturen selbst
+		err = e.startHooks()
+	} else {
+		err = startAll(rootCtx, envs)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 
-			url := fmt.Sprintf("http://127.0.0.1:%d%s", *sbPort, s.URL)
+	e.events.Publish(events.Event{Type: events.RunStarted, Total: len(configsToProcess)})
 
-			buf, err := snapshot.Capture(ctx, b, url, diffPath, s.Width, s.Height, waitSelList)
-			if err != nil {
-				results[i] = report.CaseResult{
-					Name:     s.Name,
-					URL:      s.URL,
-					Status:   "error",
-					Error:    err.Error(),
-					OutPath:  diffPath,
-					Baseline: baselinePath,
+	if *resume && *resultsFile == "" {
+		log.Fatal("-resume needs -resultsFile")
+	}
+	var (
+		stream  *report.Stream
+		resumed = map[int]report.CaseResult{}
+	)
+	if *resultsFile != "" {
+		path := inputRelative(baseDir, *resultsFile)
+		var prev []report.CaseResult
+		if *resume {
+			prev, err = report.ReadStream(path)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Fatal(err)
+			}
+			resumed = matchResumed(configsToProcess, envOf, prev)
+			fmt.Printf("resuming: %d of %d cases already finished in %s\n", len(resumed), len(configsToProcess), path)
+		}
+		if stream, err = report.CreateStream(path); err != nil {
+			log.Fatal(err)
+		}
+		defer stream.Close()
+		// die Datei wird neu geschrieben, damit eine abgeschnittene letzte
+		// Zeile des abgebrochenen Runs nicht stehen bleibt
+		for i := range configsToProcess {
+			if c, ok := resumed[i]; ok {
+				if err := stream.Add(c); err != nil {
+					log.Fatal(err)
 				}
-				return
 			}
+		}
+	}
 
-			threshold := cfg.Threshold
-			if s.Threshold != nil {
-				threshold = *s.Threshold
+	wp := pool.New(*ef.concurrency)
+	results := make([]report.CaseResult, len(configsToProcess))
+	runners := map[*env]*runner.Runner{}
+	for _, e := range envs {
+		r := e.runner(stores[e])
+		r.EmitNew, r.Update = *emitNew, *updateBase
+		if *cacheFile != "" {
+			salt, err := e.cacheSalt()
+			if err != nil {
+				fmt.Println("warning: cache disabled:", err)
+			} else if r.Cache, err = cache.Load(filepath.Join(e.baseDir, *cacheFile)); err != nil {
+				fmt.Println("warning: cache disabled:", err)
 			}
+			r.CacheSalt = salt
+		}
+		runners[e] = r
+	}
 
-			df, ph, err := diff.CompareFiles(baselinePath, buf, diffPath, float64(threshold), 10)
-			if err != nil {
-				results[i] = report.CaseResult{
-					Name:     s.Name,
-					URL:      s.URL,
-					Status:   "error",
-					Error:    err.Error(),
-					OutPath:  diffPath,
-					Baseline: baselinePath,
+	// runCtx endet wie rootCtx mit einem Signal, zusätzlich mit -failFast beim ersten Fehler
+	runCtx, stopRun := context.WithCancel(rootCtx)
+	defer stopRun()
+	var failedFast atomic.Bool
+	// mit -maxDuration endet runCtx spätestens zur Deadline; neue Cases starten nur,
+	// solange bis dahin noch ein ganzer -timeout Platz hat
+	startable := func() bool { return runCtx.Err() == nil }
+	if *maxDuration > 0 {
+		deadline := started.Add(*maxDuration)
+		var cancelDeadline context.CancelFunc
+		runCtx, cancelDeadline = context.WithDeadline(runCtx, deadline)
+		defer cancelDeadline()
+		margin := e.timeout()
+		startable = func() bool { return runCtx.Err() == nil && time.Until(deadline) > margin }
+	}
+
+	pending := order[:0:0]
+	for _, i := range order {
+		if c, ok := resumed[i]; ok {
+			results[i] = c
+			continue
+		}
+		pending = append(pending, i)
+	}
+	finishCase := func(i int, res report.CaseResult) {
+		s := configsToProcess[i]
+		results[i] = res
+		if err := stream.Add(res); err != nil {
+			fmt.Println("warning: could not stream result:", err)
+		}
+
+		snapshotNumber := fmt.Sprintf("[%d]", i+1)
+		if res.Error != "" {
+			fmt.Printf("%s %s - %s: %s\n", snapshotNumber, s.Name, res.Status, res.Error)
+		} else {
+			fmt.Printf("%s %s - %s\n", snapshotNumber, s.Name, res.Status)
+		}
+		for _, w := range res.Warnings {
+			fmt.Printf("%s %s - warning: %s\n", snapshotNumber, s.Name, w)
+		}
+		if *failFast && failsRun(res.Status, noBaselineStatus) && !failedFast.Swap(true) {
+			fmt.Printf("-failFast: %s %s, cancelling the remaining cases\n", s.Name, res.Status)
+			stopRun()
+		}
+	}
+	if len(workers) > 0 {
+		fmt.Printf("running %d cases on %d workers\n", len(pending), len(workers))
+		var mu sync.Mutex
+		wp.Go(func() error {
+			return e.distribute(runCtx, workers, *workerToken, *workerBatch, configsToProcess, pending, *updateBase, *emitNew, func(i int, res report.CaseResult) {
+				// lokal erledigt das runCase
+				e.events.Publish(events.Event{Type: events.CaseFinished, Case: &res})
+				if err := e.plugins.CaseFinished(res); err != nil {
+					fmt.Println("warning: plugin:", err)
 				}
-				return
-			}
+				mu.Lock()
+				defer mu.Unlock()
+				finishCase(i, res)
+			})
+		})
+		pending = nil
+	}
+	for _, group := range viewportGroups(pending, configsToProcess, envOf) {
+		if !startable() {
+			break
+		}
 
-			status := "pass"
-			if os.IsNotExist(err) {
-				status = "no-baseline"
-			} else if !df.Pass {
-				status = "fail"
+		wp.Go(func() error {
+			var tab *snapshot.Tab
+			if len(group) > 1 {
+				tab = &snapshot.Tab{}
+				defer tab.Close()
 			}
-
-			results[i] = report.CaseResult{
-				Name:       s.Name,
-				URL:        s.URL,
-				Status:     status,
-				Error:      "",
-				OutPath:    diffPath,
-				Baseline:   baselinePath,
-				PixelDiff:  df,
-				PercepDiff: ph,
+			for _, i := range group {
+				if !startable() {
+					return nil
+				}
+				s := configsToProcess[i]
+				res, ok := runners[envOf[s]].Case(runCtx, s, missing[i], tab)
+				if !ok {
+					return nil
+				}
+				finishCase(i, res)
 			}
-
-			snapshotNumber := fmt.Sprintf("[%d]", i+1)
-			fmt.Printf("%s %s - %s\n", snapshotNumber, s.Name, status)
+			return nil
 		})
 	}
 
-	wp.Wait()
+	// Panics in einem Case werden schon im Runner zu Fehlern; was hier
+	// ankommt, ist ein Fehler von qsnap selbst
+	poolErr := wp.Wait()
+	if poolErr != nil {
+		log.Println("internal error:", poolErr)
+	}
+
+	interrupted := rootCtx.Err() != nil
+	if interrupted {
+		fmt.Println("interrupted, writing partial report")
+	} else if failedFast.Load() {
+		fmt.Println("stopped at the first failure (-failFast), writing partial report")
+	}
+	// nicht abgebrochen und nicht fertig geworden: die Zeit war um
+	outOfTime := !interrupted && !failedFast.Load() && *maxDuration > 0
+	skippedDeadline := 0
+	for i, s := range configsToProcess {
+		if results[i].Status == "" {
+			results[i] = compare.NewResult(envOf[s].layout, s)
+			results[i].Status = "skipped"
+			if outOfTime {
+				results[i].Status = "skipped-deadline"
+				skippedDeadline++
+			}
+		}
+	}
+	if skippedDeadline > 0 {
+		fmt.Printf("-maxDuration %s: %d cases were not run in time and are reported as skipped-deadline\n", *maxDuration, skippedDeadline)
+	}
 
+	finished := time.Now()
 	rep := report.Report{
-		GeneratedAt: time.Now().Format(time.RFC3339),
-		Total:       len(results),
-		Passed:      report.CountStatus(results, "pass"),
-		Failed:      report.CountStatus(results, "fail"),
-		NoBaseline:  report.CountStatus(results, "no-baseline"),
-		Errored:     report.CountStatus(results, "error"),
+		RunID:       report.NewRunID(started),
+		GeneratedAt: finished.Format(time.RFC3339),
 		Cases:       results,
+
+		SkippedConfigs: skipped,
+		Analytics:      report.Analyze(results, *topN),
+		Timing:         report.NewTiming(started, finished, results),
+	}
+	rep.Recount()
+	fmt.Printf("finished in %s (capture %s, diff %s cumulative)\n", rep.Timing.Wall, rep.Timing.Capture, rep.Timing.Diff)
+	if n := len(rep.BaselineChanges); n > 0 {
+		fmt.Printf("%d baselines written, review them before committing:\n", n)
+		for _, ch := range rep.BaselineChanges {
+			fmt.Printf("  %s %s: %s\n", ch.Status, ch.Label, ch.Baseline)
+		}
 	}
 
-	reportPath := filepath.Join(baseDir, "report.json")
-	err = report.Write(reportPath, rep)
-	if err != nil {
-		log.Fatal(err)
+	mdOpts := report.MarkdownOptions{NoBaselineAs: noBaselineStatus, Root: baseDir, ImageBase: *imageBase, ImageRoot: e.layout.Root}
+	for _, format := range formats {
+		var reportPath string
+		switch format {
+		case "json":
+			reportPath = filepath.Join(baseDir, "report.json")
+			err = report.Write(reportPath, rep)
+		case "junit":
+			reportPath = filepath.Join(baseDir, "report.xml")
+			err = report.WriteJUnit(reportPath, rep, noBaselineStatus)
+		case "markdown":
+			reportPath = filepath.Join(baseDir, "report.md")
+			err = report.WriteMarkdown(reportPath, rep, mdOpts)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("wrote report to", reportPath)
+	}
+	if *historyDir != "" {
+		// abgebrochene Runs würden die Trends verfälschen
+		if interrupted || failedFast.Load() {
+			fmt.Println("-historyDir: run was interrupted, not archiving it")
+		} else if path, err := report.Archive(inputRelative(baseDir, *historyDir), rep); err != nil {
+			fmt.Println("warning: could not archive report:", err)
+		} else {
+			log.Println("archived report to", path)
+		}
+	}
+	if *annotations == "on" || (*annotations == "auto" && os.Getenv("GITHUB_ACTIONS") == "true") {
+		workspace := os.Getenv("GITHUB_WORKSPACE")
+		if workspace == "" {
+			workspace, _ = os.Getwd()
+		}
+		report.GitHubAnnotations(os.Stdout, rep, noBaselineStatus, workspace)
+	}
+	if *stepSummary {
+		if path := os.Getenv("GITHUB_STEP_SUMMARY"); path == "" {
+			fmt.Println("-stepSummary: GITHUB_STEP_SUMMARY is not set, skipping")
+		} else if err := report.AppendMarkdown(path, rep, mdOpts); err != nil {
+			log.Fatal(err)
+		}
+	}
+	e.events.Publish(events.Event{Type: events.RunFinished, Report: &rep})
+	if err := e.plugins.RunFinished(rep); err != nil {
+		fmt.Println("warning:", err)
+	}
+	if *prComment != "" {
+		if err := report.WriteBaselineComment(*prComment, rep); err != nil {
+			log.Fatal(err)
+		}
+		log.Println("wrote baseline changes to", *prComment)
+	}
+	if *badge != "" {
+		if err := report.WriteBadge(*badge, rep, noBaselineStatus); err != nil {
+			log.Fatal(err)
+		}
+		log.Println("wrote badge to", *badge)
+	}
+	if n := e.cfg.Notify; n != nil && !interrupted {
+		code := report.ExitCode(rep, noBaselineStatus)
+		if poolErr != nil {
+			code = report.ExitErrors
+		}
+		if notify.Should(n, code) {
+			sendCtx, cancel := context.WithTimeout(rootCtx, 10*time.Second)
+			if err := notify.Send(sendCtx, n, rep, noBaselineStatus); err != nil {
+				fmt.Println("warning: notify:", err)
+			}
+			cancel()
+		}
+	}
+
+	if *reviewAfter && !interrupted && slices.Contains(formats, "json") {
+		if !isTerminal(os.Stdin) {
+			fmt.Println("-review needs an interactive terminal, skipping")
+		} else if err := reviewReport(rootCtx, func(c *report.CaseResult) storage.Backend { return stores[envOfSource(envs, c.Source)] }, &rep, filepath.Join(baseDir, "report.json")); err != nil {
+			log.Println("review:", err)
+		}
+	}
+
+	for _, r := range runners {
+		if r.Cache == nil {
+			continue
+		}
+		if err := r.Cache.Save(); err != nil {
+			fmt.Println("warning: could not write cache:", err)
+		}
+	}
+
+	if interrupted {
+		return report.ExitInterrupted
+	}
+	if poolErr != nil {
+		return report.ExitErrors
+	}
+	return report.ExitCode(rep, noBaselineStatus)
+}
+
+// failsRun reports whether a case with status makes the run fail, like
+// report.ExitCode counts it.
+func failsRun(status, noBaselineAs string) bool {
+	switch status {
+	case "fail", "unstable", "error", "image-too-large":
+		return true
+	case "no-baseline":
+		return noBaselineAs == "fail" || noBaselineAs == "error"
+	}
+	return false
+}
+
+// inputRelative resolves a path flag relative to -input; absolute paths
+// stay as they are.
+func inputRelative(baseDir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(baseDir, path)
+}
+
+// failedFirstOrder moves the cases that failed or errored in prev to the
+// front of order, keeping the order within both parts, and returns how
+// many moved.
+func failedFirstOrder(order []int, prev map[int]report.CaseResult) int {
+	failed := func(i int) bool {
+		switch prev[i].Status {
+		case "fail", "unstable", "error", "image-too-large":
+			return true
+		}
+		return false
+	}
+	n := 0
+	for _, i := range order {
+		if failed(i) {
+			n++
+		}
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		switch fa, fb := failed(a), failed(b); {
+		case fa == fb:
+			return 0
+		case fa:
+			return -1
+		}
+		return 1
+	})
+	return n
+}
+
+// matchResumed maps the results of an interrupted run back to the cases of
+// this run. Results of cases that are no longer selected are dropped.
+func matchResumed(configs []*config.OsnapConfig, envOf map[*config.OsnapConfig]*env, prev []report.CaseResult) map[int]report.CaseResult {
+	key := func(c report.CaseResult) string { return c.Source + "\x00" + c.Browser + "\x00" + c.Label() }
+	byKey := map[string]report.CaseResult{}
+	for _, c := range prev {
+		if c.Status != "" && c.Status != "skipped" && c.Status != "skipped-deadline" {
+			byKey[key(c)] = c
+		}
+	}
+	out := map[int]report.CaseResult{}
+	for i, s := range configs {
+		if c, ok := byKey[key(compare.NewResult(envOf[s].layout, s))]; ok {
+			out[i] = c
+		}
 	}
-	log.Println("wrote report to", reportPath)
+	return out
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/maxischmaxi/qsnap/internal/report"
+)
+
+// runMergeReports combines the report.json files of several shards and exits
+// like a single run over all cases would.
+func runMergeReports(args []string) int {
+	fset := flag.NewFlagSet("merge-reports", flag.ExitOnError)
+	var (
+		out         = fset.String("out", "report.json", "path of the merged JSON report")
+		reportFmt   = fset.String("reportFormat", "json", "comma-separated report formats to write: json, junit, markdown (written next to -out as .xml and .md)")
+		stepSummary = fset.Bool("stepSummary", false, "append a Markdown summary to $GITHUB_STEP_SUMMARY")
+		imageBase   = fset.String("summaryImageBase", "", "URL where CI publishes __image-snapshots__; image links in Markdown summaries point below it")
+		topN        = fset.Int("analyticsTop", 5, "number of near-misses and worst failures to list in the report analytics")
+		noBaseline  = fset.String("noBaselineAs", "fail", "how cases without a baseline affect the exit code: pass, fail or error")
+		historyDir  = fset.String("historyDir", "", "also archive the merged report in this directory, for qsnap trends")
+	)
+	fset.Usage = func() {
+		fmt.Fprintln(fset.Output(), "usage: qsnap merge-reports [flags] shard1/report.json shard2/report.json ...")
+		fset.PrintDefaults()
+	}
+	_ = fset.Parse(args)
+
+	if fset.NArg() == 0 {
+		fset.Usage()
+		return report.ExitErrors
+	}
+
+	noBaselineStatus, err := report.ParseNoBaselinePolicy(*noBaseline)
+	if err != nil {
+		log.Fatal(err)
+	}
+	formats, err := report.ParseFormats(*reportFmt)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var parts []report.Report
+	for _, p := range fset.Args() {
+		r, err := report.Read(p)
+		if err != nil {
+			log.Fatal(err)
+		}
+		parts = append(parts, r)
+	}
+
+	merged := report.Merge(parts, *topN)
+	// Bildpfade der Shards relativ zum Arbeitsverzeichnis verlinken
+	cwd, _ := os.Getwd()
+	mdOpts := report.MarkdownOptions{NoBaselineAs: noBaselineStatus, Root: cwd, ImageBase: *imageBase}
+	for _, format := range formats {
+		path := *out
+		switch format {
+		case "json":
+			err = report.Write(path, merged)
+		case "junit":
+			path = strings.TrimSuffix(path, filepath.Ext(path)) + ".xml"
+			err = report.WriteJUnit(path, merged, noBaselineStatus)
+		case "markdown":
+			path = strings.TrimSuffix(path, filepath.Ext(path)) + ".md"
+			err = report.WriteMarkdown(path, merged, mdOpts)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("wrote report to", path)
+	}
+
+	if *historyDir != "" {
+		if path, err := report.Archive(*historyDir, merged); err != nil {
+			fmt.Println("warning: could not archive report:", err)
+		} else {
+			log.Println("archived report to", path)
+		}
+	}
+
+	if *stepSummary {
+		if path := os.Getenv("GITHUB_STEP_SUMMARY"); path == "" {
+			fmt.Println("-stepSummary: GITHUB_STEP_SUMMARY is not set, skipping")
+		} else if err := report.AppendMarkdown(path, merged, mdOpts); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	fmt.Printf("merged %d reports: %d cases, %d passed, %d failed, %d no baseline, %d errors\n",
+		len(parts), merged.Total, merged.Passed, merged.Failed, merged.NoBaseline, merged.Errored)
+	return report.ExitCode(merged, noBaselineStatus)
+}
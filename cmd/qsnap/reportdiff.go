@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/maxischmaxi/qsnap/internal/report"
+)
+
+// runReportDiff prints the cases whose status changed between two reports,
+// e.g. last night's and tonight's, and fails if any newly failed.
+func runReportDiff(args []string) int {
+	fset := flag.NewFlagSet("report-diff", flag.ExitOnError)
+	var (
+		failOn = fset.String("failOn", "new", "when to exit with 1: new (a case newly fails or errors), any (any status changed) or none")
+		out    = fset.String("out", "", "also write the changes as JSON to this file")
+	)
+	fset.Usage = func() {
+		fmt.Fprintln(fset.Output(), "usage: qsnap report-diff [flags] old.json new.json")
+		fset.PrintDefaults()
+	}
+	_ = fset.Parse(args)
+	if fset.NArg() != 2 {
+		fset.Usage()
+		return report.ExitErrors
+	}
+	switch *failOn {
+	case "new", "any", "none":
+	default:
+		log.Fatalf("invalid -failOn %q: expected new, any or none", *failOn)
+	}
+
+	var reps [2]report.Report
+	for i, p := range fset.Args() {
+		r, err := report.Read(p)
+		if err != nil {
+			log.Fatal(err)
+		}
+		reps[i] = r
+	}
+	d, err := report.DiffReports(reps[0], reps[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *out != "" {
+		b, err := json.MarshalIndent(d, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(*out, b, 0o644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for _, section := range []struct {
+		title   string
+		changes []report.StatusChange
+	}{
+		{"newly failed", d.NewlyFailed},
+		{"newly passed", d.NewlyPassed},
+		{"changed", d.Changed},
+		{"added", d.Added},
+		{"removed", d.Removed},
+	} {
+		if len(section.changes) == 0 {
+			continue
+		}
+		fmt.Printf("%s (%d):\n", section.title, len(section.changes))
+		for _, c := range section.changes {
+			switch {
+			case c.Old == "":
+				fmt.Printf("  %s: %s\n", c.Label, c.New)
+			case c.New == "":
+				fmt.Printf("  %s: was %s\n", c.Label, c.Old)
+			default:
+				fmt.Printf("  %s: %s -> %s\n", c.Label, c.Old, c.New)
+			}
+		}
+	}
+	changed := len(d.NewlyFailed) + len(d.NewlyPassed) + len(d.Changed) + len(d.Added) + len(d.Removed)
+	fmt.Printf("%d newly failed, %d newly passed, %d other changes, %d added, %d removed\n",
+		len(d.NewlyFailed), len(d.NewlyPassed), len(d.Changed), len(d.Added), len(d.Removed))
+
+	switch {
+	case *failOn == "new" && len(d.NewlyFailed) > 0, *failOn == "any" && changed > 0:
+		return report.ExitFailures
+	}
+	return report.ExitOK
+}
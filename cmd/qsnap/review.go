@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/maxischmaxi/qsnap/internal/config"
+	"github.com/maxischmaxi/qsnap/internal/report"
+	"github.com/maxischmaxi/qsnap/internal/review"
+	"github.com/maxischmaxi/qsnap/internal/storage"
+	"github.com/maxischmaxi/qsnap/internal/tools"
+)
+
+// runReview opens the interactive review screen for the last report.
+func runReview(args []string) int {
+	fset := flag.NewFlagSet("review", flag.ExitOnError)
+	var (
+		input      = fset.String("input", ".", "the storybook directory the report belongs to")
+		baseConfig = fset.String("baseConfig", "osnap.config.yaml", "path to the base osnap config file")
+		reportFile = fset.String("report", "report.json", "path to the report to review (relative to -input)")
+	)
+	_ = fset.Parse(args)
+
+	baseDir, err := tools.ExpandPath(*input)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg, err := config.NewOsnapBaseConfig(filepath.Join(baseDir, *baseConfig))
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, err := storage.New(cfg.BaselineStorage)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	reportPath := filepath.Join(baseDir, *reportFile)
+	rep, err := report.Read(reportPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	storeFor := func(*report.CaseResult) storage.Backend { return store }
+	if err := reviewReport(context.Background(), storeFor, &rep, reportPath); err != nil {
+		log.Fatal(err)
+	}
+	return report.ExitOK
+}
+
+// reviewReport runs the review screen and writes the decisions back to
+// reportPath. storeFor returns the remote storage an approved case goes to.
+func reviewReport(ctx context.Context, storeFor func(*report.CaseResult) storage.Backend, rep *report.Report, reportPath string) error {
+	if len(review.Pending(*rep)) == 0 {
+		fmt.Println("nothing to review")
+		return nil
+	}
+	changed, err := review.Run(rep, func(c *report.CaseResult) error {
+		return approveCase(ctx, storeFor(c), c)
+	})
+	if err != nil || !changed {
+		return err
+	}
+
+	approved, rejected := 0, 0
+	for _, c := range rep.Cases {
+		switch c.Review {
+		case "approved":
+			approved++
+		case "rejected":
+			rejected++
+		}
+	}
+	fmt.Printf("%d approved, %d rejected\n", approved, rejected)
+	return report.Write(reportPath, *rep)
+}
+
+func isTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
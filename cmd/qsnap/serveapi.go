@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/maxischmaxi/qsnap/internal/compare"
+	"github.com/maxischmaxi/qsnap/internal/config"
+	"github.com/maxischmaxi/qsnap/internal/events"
+	"github.com/maxischmaxi/qsnap/internal/pool"
+	"github.com/maxischmaxi/qsnap/internal/report"
+	"github.com/maxischmaxi/qsnap/internal/storage"
+	"github.com/maxischmaxi/qsnap/internal/tools"
+)
+
+// maxAPIRuns ist die Anzahl abgeschlossener Runs, deren Reports im Speicher bleiben
+const maxAPIRuns = 20
+
+// runServeAPI keeps Storybook and the browser pool alive like watch mode and
+// lets other programs start runs, follow them and approve baselines over HTTP.
+func runServeAPI(args []string) int {
+	fset := flag.NewFlagSet("serve-api", flag.ExitOnError)
+	ef := registerEnvFlags(fset)
+	var (
+		addr  = fset.String("addr", "127.0.0.1:7358", "address to serve the API on")
+		token = fset.String("token", "", "require this bearer token in the Authorization header (default: $QSNAP_API_TOKEN)")
+	)
+	_ = fset.Parse(args)
+	if *token == "" {
+		*token = os.Getenv("QSNAP_API_TOKEN")
+	}
+	if *token == "" && !loopback(*addr) {
+		// ohne Token könnte jeder im Netz Runs starten und Baselines freigeben
+		log.Fatalf("qsnap serve-api -addr %s accepts requests from other machines, set -token or $QSNAP_API_TOKEN", *addr)
+	}
+
+	rootCtx, stop := signalContext()
+	defer stop()
+
+	e, err := ef.load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer e.close()
+
+	store, err := storage.New(e.cfg.BaselineStorage)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := e.start(rootCtx); err != nil {
+		log.Fatal(err)
+	}
+
+	api := &apiServer{ctx: rootCtx, env: e, store: store, runs: map[string]*apiRun{}}
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := &http.Server{Handler: api.handler(*token), ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-rootCtx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}()
+	fmt.Println("serving the API on http://" + ln.Addr().String() + " - press Ctrl+C to stop")
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	// ein laufender Run bricht mit rootCtx ab
+	api.wg.Wait()
+	return report.ExitOK
+}
+
+type apiServer struct {
+	ctx   context.Context
+	env   *env
+	store storage.Backend
+
+	mu     sync.Mutex
+	runs   map[string]*apiRun
+	order  []string // Run-IDs, ältester zuerst
+	active *apiRun
+	wg     sync.WaitGroup
+}
+
+// apiRunRequest is the body of POST /runs; every field is optional.
+type apiRunRequest struct {
+	Filter string `json:"filter"`
+	Limit  int    `json:"limit"`
+	// UpdateBaselines ist "", "missing" oder "all" wie -updateBaselines
+	UpdateBaselines string `json:"updateBaselines"`
+}
+
+type apiRun struct {
+	ID      string        `json:"id"`
+	State   string        `json:"state"` // running | cancelling | finished | cancelled
+	Request apiRunRequest `json:"request"`
+	Total   int           `json:"total"`
+	Done    int           `json:"done"`
+	// Counts sind die Status der bisher fertigen Cases
+	Counts     map[string]int `json:"counts"`
+	StartedAt  time.Time      `json:"startedAt"`
+	FinishedAt time.Time      `json:"finishedAt,omitzero"`
+	ExitCode   *int           `json:"exitCode,omitempty"`
+	Error      string         `json:"error,omitempty"`
+
+	cancel context.CancelFunc
+	report *report.Report
+}
+
+func (a *apiServer) handler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /runs", a.startRun)
+	mux.HandleFunc("GET /runs", a.listRuns)
+	mux.HandleFunc("GET /runs/{id}", a.getRun)
+	mux.HandleFunc("DELETE /runs/{id}", a.cancelRun)
+	mux.HandleFunc("GET /runs/{id}/report", a.getReport)
+	mux.HandleFunc("POST /runs/{id}/approve", a.approve)
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// ohne Token schützt nur die Bindung an localhost, dann auch gegen
+		// DNS-Rebinding den Host prüfen
+		if events.CrossSite(r) || token == "" && !events.LocalRequest(r) {
+			apiError(w, http.StatusForbidden, "requests from other sites are not allowed")
+			return
+		}
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			apiError(w, http.StatusUnauthorized, "missing or wrong bearer token")
+			return
+		}
+		// Formulare anderer Seiten dürfen ohne Preflight nur text/plain & Co. schicken
+		if r.ContentLength != 0 {
+			if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+				apiError(w, http.StatusUnsupportedMediaType, "request body must be application/json")
+				return
+			}
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func apiJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func apiError(w http.ResponseWriter, code int, msg string) {
+	apiJSON(w, code, map[string]string{"error": msg})
+}
+
+// lookup kopiert den Run unter a.mu, damit er ohne Lock serialisiert werden kann
+func (a *apiServer) lookup(id string) (apiRun, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	run, ok := a.runs[id]
+	if !ok {
+		return apiRun{}, false
+	}
+	cp := *run
+	cp.Counts = make(map[string]int, len(run.Counts))
+	for k, v := range run.Counts {
+		cp.Counts[k] = v
+	}
+	return cp, true
+}
+
+// startRun startet einen Run im Hintergrund; es läuft immer nur einer, weil
+// sich alle Runs den Browser-Pool teilen
+func (a *apiServer) startRun(w http.ResponseWriter, r *http.Request) {
+	var req apiRunRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apiError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+			return
+		}
+	}
+	switch req.UpdateBaselines {
+	case "", "missing", "all":
+	default:
+		apiError(w, http.StatusBadRequest, fmt.Sprintf("invalid updateBaselines %q: expected missing or all", req.UpdateBaselines))
+		return
+	}
+
+	discovered, err := a.env.cfg.FindAndParseConfigs(a.env.baseDir)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	configs, err := selectConfigs(discovered.Configs, req.Filter, req.Limit)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	a.mu.Lock()
+	if a.active != nil {
+		id := a.active.ID
+		a.mu.Unlock()
+		apiError(w, http.StatusConflict, "run "+id+" is still running")
+		return
+	}
+	ctx, cancel := context.WithCancel(a.ctx)
+	started := time.Now()
+	run := &apiRun{ID: report.NewRunID(started), State: "running", Request: req, Total: len(configs), Counts: map[string]int{}, StartedAt: started, cancel: cancel}
+	a.runs[run.ID] = run
+	a.order = append(a.order, run.ID)
+	a.active = run
+	a.prune()
+	a.wg.Add(1)
+	a.mu.Unlock()
+
+	go func() {
+		defer a.wg.Done()
+		defer cancel()
+		a.execute(ctx, run, configs)
+	}()
+	cp, _ := a.lookup(run.ID)
+	apiJSON(w, http.StatusAccepted, cp)
+}
+
+// prune vergisst die ältesten abgeschlossenen Runs; a.mu ist gehalten
+func (a *apiServer) prune() {
+	for len(a.order) > maxAPIRuns {
+		id := a.order[0]
+		if a.runs[id] == a.active {
+			return
+		}
+		delete(a.runs, id)
+		a.order = a.order[1:]
+	}
+}
+
+func (a *apiServer) execute(ctx context.Context, run *apiRun, configs []*config.OsnapConfig) {
+	e := a.env
+	fmt.Printf("run %s: %d cases\n", run.ID, len(configs))
+	if a.store != nil {
+		if _, err := compare.PullBaselines(ctx, a.store, e.layout, configs); err != nil {
+			a.finish(run, nil, err)
+			return
+		}
+	}
+
+	r := e.runner(a.store)
+	// ohne Capture könnten neue Cases danach nicht freigegeben werden
+	r.Update, r.EmitNew = run.Request.UpdateBaselines, true
+	e.events.Publish(events.Event{Type: events.RunStarted, Total: len(configs)})
+	results := make([]report.CaseResult, len(configs))
+	wp := pool.New(*e.flags.concurrency)
+	for i, s := range configs {
+		wp.Go(func() error {
+			res, ok := r.Case(ctx, s, !s.Skip.Enabled && !tools.FileExists(e.layout.Baseline(s)), nil)
+			if !ok {
+				return nil
+			}
+			results[i] = res
+			a.mu.Lock()
+			run.Done++
+			run.Counts[res.Status]++
+			a.mu.Unlock()
+			return nil
+		})
+	}
+	poolErr := wp.Wait()
+
+	for i, s := range configs {
+		if results[i].Status == "" {
+			results[i] = compare.NewResult(e.layout, s)
+			results[i].Status = "skipped"
+		}
+	}
+	finished := time.Now()
+	rep := report.Report{
+		RunID:       run.ID,
+		GeneratedAt: finished.Format(time.RFC3339),
+		Cases:       results,
+		Analytics:   report.Analyze(results, 5),
+		Timing:      report.NewTiming(run.StartedAt, finished, results),
+	}
+	rep.Recount()
+	e.events.Publish(events.Event{Type: events.RunFinished, Report: &rep})
+	a.finish(run, &rep, poolErr)
+}
+
+func (a *apiServer) finish(run *apiRun, rep *report.Report, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if run.State == "cancelling" || a.ctx.Err() != nil {
+		run.State = "cancelled"
+	} else {
+		run.State = "finished"
+	}
+	run.FinishedAt = time.Now()
+	run.report = rep
+	code := report.ExitErrors
+	if err != nil {
+		run.Error = err.Error()
+	} else if rep != nil {
+		code = report.ExitCode(*rep, "fail")
+	}
+	run.ExitCode = &code
+	a.active = nil
+	fmt.Printf("run %s: %s with exit code %d\n", run.ID, run.State, code)
+}
+
+func (a *apiServer) listRuns(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	ids := slices.Clone(a.order)
+	a.mu.Unlock()
+	runs := []apiRun{}
+	for _, id := range ids {
+		if run, ok := a.lookup(id); ok {
+			runs = append(runs, run)
+		}
+	}
+	apiJSON(w, http.StatusOK, runs)
+}
+
+func (a *apiServer) getRun(w http.ResponseWriter, r *http.Request) {
+	run, ok := a.lookup(r.PathValue("id"))
+	if !ok {
+		apiError(w, http.StatusNotFound, "no such run")
+		return
+	}
+	apiJSON(w, http.StatusOK, run)
+}
+
+func (a *apiServer) cancelRun(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	run, ok := a.runs[r.PathValue("id")]
+	if ok && run.State == "running" {
+		// finish macht daraus cancelled
+		run.State = "cancelling"
+		run.cancel()
+	}
+	a.mu.Unlock()
+	if !ok {
+		apiError(w, http.StatusNotFound, "no such run")
+		return
+	}
+	cp, _ := a.lookup(run.ID)
+	apiJSON(w, http.StatusAccepted, cp)
+}
+
+func (a *apiServer) getReport(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	run, ok := a.runs[r.PathValue("id")]
+	var rep report.Report
+	if ok && run.report != nil {
+		// approve ändert die Cases, also eine Kopie ausliefern
+		rep = *run.report
+		rep.Cases = slices.Clone(rep.Cases)
+	}
+	a.mu.Unlock()
+	switch {
+	case !ok:
+		apiError(w, http.StatusNotFound, "no such run")
+	case rep.Cases == nil:
+		apiError(w, http.StatusConflict, "the run has no report yet")
+	default:
+		rep.SchemaVersion = report.SchemaVersion
+		apiJSON(w, http.StatusOK, rep)
+	}
+}
+
+// approve schreibt die Captures der fehlgeschlagenen und neuen Cases eines
+// Runs als Baselines, wie qsnap approve; mit "cases" nur die genannten Labels
+func (a *apiServer) approve(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Cases []string `json:"cases"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apiError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+			return
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	run, ok := a.runs[r.PathValue("id")]
+	if !ok {
+		apiError(w, http.StatusNotFound, "no such run")
+		return
+	}
+	if run.report == nil || a.active != nil {
+		apiError(w, http.StatusConflict, "baselines can only be approved when no run is in progress")
+		return
+	}
+
+	// erst alles prüfen, damit ein Fehler keine halbe Freigabe hinterlässt
+	var pick []int
+	for i, c := range run.report.Cases {
+		if len(req.Cases) > 0 && !slices.Contains(req.Cases, c.Label()) {
+			continue
+		}
+		if c.Status != "fail" && c.Status != "unstable" && c.Status != "no-baseline" {
+			if len(req.Cases) > 0 {
+				apiError(w, http.StatusConflict, fmt.Sprintf("%s: status %s cannot be approved", c.Label(), c.Status))
+				return
+			}
+			continue
+		}
+		if c.Actual == "" || !tools.FileExists(c.Actual) {
+			apiError(w, http.StatusConflict, c.Label()+": no capture to approve")
+			return
+		}
+		pick = append(pick, i)
+	}
+	for _, label := range req.Cases {
+		if !slices.ContainsFunc(run.report.Cases, func(c report.CaseResult) bool { return c.Label() == label }) {
+			apiError(w, http.StatusBadRequest, "no case "+label+" in this run")
+			return
+		}
+	}
+
+	approved := []string{}
+	for _, i := range pick {
+		c := run.report.Cases[i]
+		if err := approveCase(r.Context(), a.store, &run.report.Cases[i]); err != nil {
+			apiError(w, http.StatusInternalServerError, fmt.Sprintf("%s: %v", c.Label(), err))
+			return
+		}
+		run.report.Cases[i].Review = "approved"
+		approved = append(approved, c.Label())
+	}
+	apiJSON(w, http.StatusOK, map[string][]string{"approved": approved})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/maxischmaxi/qsnap/internal/report"
+)
+
+// runTrends reads the reports archived with -historyDir and prints the pass
+// rate and diff ratios of every case over the last runs, least reliable first.
+func runTrends(args []string) int {
+	fset := flag.NewFlagSet("trends", flag.ExitOnError)
+	var (
+		dir     = fset.String("historyDir", "", "directory the runs were archived in with -historyDir")
+		runs    = fset.Int("runs", 20, "how many of the latest runs to look at (0 = all)")
+		top     = fset.Int("top", 20, "only print this many cases (0 = all)")
+		onlyBad = fset.Bool("unreliable", false, "only print cases that did not pass every run")
+		out     = fset.String("out", "", "also write the trends as JSON to this file")
+	)
+	_ = fset.Parse(args)
+	if *dir == "" {
+		log.Fatal("trends needs -historyDir")
+	}
+
+	history, err := report.History(*dir, *runs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(history) == 0 {
+		fmt.Println("no archived runs in", *dir)
+		return report.ExitOK
+	}
+	trends := report.Trends(history)
+
+	if *out != "" {
+		b, err := json.MarshalIndent(struct {
+			Runs   []string            `json:"runs"`
+			Trends []report.StoryTrend `json:"trends"`
+		}{runIDs(history), trends}, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(*out, b, 0o644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var shown []report.StoryTrend
+	for _, t := range trends {
+		if !*onlyBad || t.Passed < t.Runs {
+			shown = append(shown, t)
+		}
+	}
+	if len(shown) == 0 {
+		fmt.Println("every case passed every run")
+		return report.ExitOK
+	}
+
+	fmt.Printf("%d runs from %s to %s\n\n", len(history), history[0].RunID, history[len(history)-1].RunID)
+	w := max(len(history), len("runs"))
+	fmt.Printf("%-9s %5s %5s %9s %9s  %-*s  %s\n", "pass rate", "flaky", "flips", "avg diff", "max diff", w, "runs", "case")
+	for i, t := range shown {
+		if *top > 0 && i == *top {
+			fmt.Printf("… and %d more (-top 0 prints all)\n", len(shown)-i)
+			break
+		}
+		fmt.Printf("%8.1f%% %5d %5d %8.4f%% %8.4f%%  %-*s  %s\n", t.PassRate*100, t.Flaky, t.Flips, t.AvgRatio*100, t.MaxRatio*100, w, statusStrip(t.Statuses), t.Label)
+	}
+	return report.ExitOK
+}
+
+func runIDs(history []report.Report) []string {
+	ids := make([]string, len(history))
+	for i, r := range history {
+		ids[i] = r.RunID
+	}
+	return ids
+}
+
+// statusStrip zeigt einen Buchstaben pro Run: . bestanden, x fail, f flaky,
+// E error, Leerzeichen nicht verglichen
+func statusStrip(statuses []string) string {
+	var b strings.Builder
+	for _, s := range statuses {
+		switch s {
+		case "pass", "cached-pass", "created", "updated":
+			b.WriteByte('.')
+		case "flaky":
+			b.WriteByte('f')
+		case "fail", "unstable", "no-baseline":
+			b.WriteByte('x')
+		case "error", "image-too-large":
+			b.WriteByte('E')
+		default:
+			b.WriteByte(' ')
+		}
+	}
+	return b.String()
+}
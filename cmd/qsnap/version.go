@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/maxischmaxi/qsnap/internal/report"
+	"github.com/maxischmaxi/qsnap/internal/selfupdate"
+	"github.com/maxischmaxi/qsnap/internal/version"
+)
+
+func runVersion(args []string) int {
+	fset := flag.NewFlagSet("version", flag.ExitOnError)
+	asJSON := fset.Bool("json", false, "print the build info as JSON")
+	_ = fset.Parse(args)
+
+	info := version.Get()
+	if *asJSON {
+		b, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			log.Println(err)
+			return report.ExitErrors
+		}
+		fmt.Println(string(b))
+		return report.ExitOK
+	}
+	fmt.Println(info)
+	return report.ExitOK
+}
+
+// runSelfUpdate replaces the running binary with a release binary from
+// GitHub after verifying its SHA-256 checksum.
+func runSelfUpdate(args []string) int {
+	fset := flag.NewFlagSet("self-update", flag.ExitOnError)
+	var (
+		tag   = fset.String("version", "", "install this release tag instead of the latest, e.g. v1.4.0")
+		check = fset.Bool("check", false, "only report whether a newer release exists")
+		force = fset.Bool("force", false, "install even if the release matches the running version")
+		repo  = fset.String("repo", selfupdate.DefaultRepo, "GitHub repository to download releases from")
+	)
+	_ = fset.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	c := &selfupdate.Client{Repo: *repo, Token: os.Getenv("GITHUB_TOKEN")}
+	rel, err := c.Release(ctx, *tag)
+	if err != nil {
+		log.Println(err)
+		return report.ExitErrors
+	}
+
+	current := version.Get().Version
+	if rel.Tag == current && !*force {
+		fmt.Println("qsnap", current, "is up to date")
+		return report.ExitOK
+	}
+	if *check {
+		fmt.Printf("qsnap %s is available (running %s)\n", rel.Tag, current)
+		return report.ExitFailures
+	}
+
+	data, err := c.Download(ctx, rel)
+	if err != nil {
+		log.Println(err)
+		return report.ExitErrors
+	}
+	path, err := selfupdate.Replace(data)
+	if err != nil {
+		log.Println("could not replace binary:", err)
+		return report.ExitErrors
+	}
+	fmt.Printf("updated %s from %s to %s\n", path, current, rel.Tag)
+	return report.ExitOK
+}
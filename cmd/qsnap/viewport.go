@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/maxischmaxi/qsnap/internal/config"
+)
+
+// viewportGroups splits order into groups that run one after another in one
+// tab. With reuseViewport, the sizes of a story share a group, placed where
+// its first case is in order; every other case is a group of its own.
+func viewportGroups(order []int, configs []*config.OsnapConfig, envOf map[*config.OsnapConfig]*env) [][]int {
+	var groups [][]int
+	index := map[string]int{}
+	for _, i := range order {
+		s := configs[i]
+		if s.ReuseViewport == nil || !*s.ReuseViewport || s.Skip.Enabled {
+			groups = append(groups, []int{i})
+			continue
+		}
+		// gleiche Seite und gleiche Emulation, nur die Größe unterscheidet sich
+		key := fmt.Sprintf("%p\x00%s\x00%s\x00%s\x00%s", envOf[s], s.URL, s.Browser, s.Device, s.ColorScheme)
+		if g, ok := index[key]; ok {
+			groups[g] = append(groups[g], i)
+			continue
+		}
+		index[key] = len(groups)
+		groups = append(groups, []int{i})
+	}
+	return groups
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/maxischmaxi/qsnap/internal/config"
+	"github.com/maxischmaxi/qsnap/internal/events"
+	"github.com/maxischmaxi/qsnap/internal/pool"
+	"github.com/maxischmaxi/qsnap/internal/report"
+	"github.com/maxischmaxi/qsnap/internal/storybook"
+	"github.com/maxischmaxi/qsnap/internal/tools"
+	"github.com/maxischmaxi/qsnap/internal/watch"
+)
+
+// runWatch keeps the static server and browser pool alive and re-captures
+// the stories affected by each file change.
+func runWatch(args []string) int {
+	fset := flag.NewFlagSet("watch", flag.ExitOnError)
+	ef := registerEnvFlags(fset)
+	var (
+		source   = fset.String("source", "", "additional directory with Storybook sources to watch (relative to -input)")
+		interval = fset.Int("intervalMs", 500, "how often to poll for file changes, in milliseconds")
+		filter   = fset.String("filter", "", "only watch stories whose name or URL matches this glob (or regex with a \"re:\" prefix)")
+		rebuild  = fset.Bool("rebuild", true, "rebuild storybook when non-config files change (only when qsnap serves the build itself)")
+	)
+	_ = fset.Parse(args)
+
+	rootCtx, stop := signalContext()
+	defer stop()
+
+	e, err := ef.load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer e.close()
+
+	if err := e.start(rootCtx); err != nil {
+		log.Fatal(err)
+	}
+
+	r := e.runner(nil)
+	buildDir := filepath.Join(e.baseDir, *ef.sbBuildDir)
+	baseConfigPath := filepath.Join(e.baseDir, *ef.baseConfig)
+
+	roots := []string{e.baseDir}
+	if *source != "" {
+		roots = append(roots, filepath.Join(e.baseDir, *source))
+	}
+	poller := &watch.Poller{
+		Roots:    roots,
+		SkipDirs: append([]string{"node_modules", "__image-snapshots__", e.layout.Root, buildDir}, e.cfg.IgnorePatterns...),
+		Interval: time.Duration(*interval) * time.Millisecond,
+	}
+
+	fmt.Println("watching", strings.Join(roots, ", "), "- press Ctrl+C to stop")
+	for {
+		changed, err := poller.Next(rootCtx)
+		if errors.Is(err, context.Canceled) {
+			return report.ExitOK
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		all, configFiles, sources := classifyChanges(changed, baseConfigPath, func(p string) bool { return e.cfg.IsTestFile(e.baseDir, p) })
+		if all && slices.Contains(changed, baseConfigPath) {
+			cfg, err := config.NewOsnapBaseConfig(baseConfigPath)
+			if err != nil {
+				fmt.Println("base config:", err)
+				continue
+			}
+			e.cfg = cfg
+		}
+
+		if len(sources) > 0 && *rebuild && e.ctrl.Started() {
+			fmt.Println("rebuilding storybook")
+			if err := storybook.BuildIfNeeded(rootCtx, *ef.sbBuildCmd, buildDir, e.baseDir, true, *ef.sbBuildLog); err != nil {
+				fmt.Println(err)
+				continue
+			}
+		}
+
+		discovered, err := e.cfg.FindAndParseConfigs(e.baseDir)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		for _, fe := range discovered.Errors {
+			fmt.Println("skipping config:", fe.Error())
+		}
+		configs, err := config.Filter(discovered.Configs, *filter)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		affected := affectedStories(configs, all, configFiles, sources)
+		if len(affected) == 0 {
+			continue
+		}
+		fmt.Printf("%d files changed, re-capturing %d cases\n", len(changed), len(affected))
+
+		e.events.Publish(events.Event{Type: events.RunStarted, Total: len(affected)})
+		wp := pool.New(*ef.concurrency)
+		for _, s := range affected {
+			wp.Go(func() error {
+				baselinePath := e.layout.Baseline(s)
+				res, ok := r.Case(rootCtx, s, !tools.FileExists(baselinePath), nil)
+				if !ok {
+					return nil
+				}
+				if res.Error != "" {
+					fmt.Printf("%s - %s: %s\n", res.Label(), res.Status, res.Error)
+				} else {
+					fmt.Printf("%s - %s\n", res.Label(), res.Status)
+				}
+				return nil
+			})
+		}
+		if err := wp.Wait(); err != nil {
+			fmt.Println("error:", err)
+		}
+		e.events.Publish(events.Event{Type: events.RunFinished})
+	}
+}
+
+// classifyChanges splits changed paths into story configs and other sources.
+// A changed base config, shared fragment or osnap.dir.yaml file affects every story.
+func classifyChanges(changed []string, baseConfigPath string, isTestFile func(string) bool) (all bool, configFiles, sources []string) {
+	for _, p := range changed {
+		switch {
+		case p == baseConfigPath || filepath.Base(p) == config.SharedFileName || filepath.Base(p) == config.DirConfigFileName:
+			all = true
+		case isTestFile(p):
+			configFiles = append(configFiles, p)
+		default:
+			sources = append(sources, p)
+		}
+	}
+	return all, configFiles, sources
+}
+
+// affectedStories picks stories from changed config files and stories whose
+// config lives next to a changed source file (the component next to its
+// *.osnap.yaml is the common layout).
+func affectedStories(configs []*config.OsnapConfig, all bool, configFiles, sources []string) []*config.OsnapConfig {
+	if all {
+		return configs
+	}
+
+	dirs := map[string]bool{}
+	for _, p := range sources {
+		dirs[filepath.Dir(p)] = true
+	}
+
+	var out []*config.OsnapConfig
+	for _, c := range configs {
+		if slices.Contains(configFiles, c.Source) || dirs[filepath.Dir(c.Source)] {
+			out = append(out, c)
+		}
+	}
+	return out
+}
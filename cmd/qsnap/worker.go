@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/maxischmaxi/qsnap/internal/compare"
+	"github.com/maxischmaxi/qsnap/internal/config"
+	"github.com/maxischmaxi/qsnap/internal/pool"
+	"github.com/maxischmaxi/qsnap/internal/remote"
+	"github.com/maxischmaxi/qsnap/internal/report"
+	"github.com/maxischmaxi/qsnap/internal/snapshot"
+	"github.com/maxischmaxi/qsnap/internal/storage"
+	"github.com/maxischmaxi/qsnap/internal/tools"
+)
+
+// runWorker keeps Storybook and the browser pool alive and runs the batches
+// a coordinator (qsnap -workers) sends, streaming the results back.
+func runWorker(args []string) int {
+	fset := flag.NewFlagSet("worker", flag.ExitOnError)
+	ef := registerEnvFlags(fset)
+	var (
+		addr  = fset.String("addr", "127.0.0.1:7359", "address to accept batches on, other than loopback only with -token")
+		token = fset.String("token", "", "require this bearer token from the coordinator (default: $QSNAP_WORKER_TOKEN)")
+	)
+	_ = fset.Parse(args)
+	if *token == "" {
+		*token = os.Getenv("QSNAP_WORKER_TOKEN")
+	}
+
+	if *token == "" && !loopback(*addr) {
+		// ohne Token könnte jeder im Netz Baselines schreiben lassen
+		log.Fatalf("qsnap worker -addr %s accepts batches from other machines, set -token or $QSNAP_WORKER_TOKEN", *addr)
+	}
+
+	rootCtx, stop := signalContext()
+	defer stop()
+
+	e, err := ef.load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer e.close()
+
+	store, err := storage.New(e.cfg.BaselineStorage)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := e.start(rootCtx); err != nil {
+		log.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := &http.Server{
+		Handler: remote.Handler(*token, func(ctx context.Context, b remote.Batch, emit func(remote.Result)) error {
+			return workBatch(ctx, e, store, b, emit)
+		}),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		<-rootCtx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}()
+	fmt.Println("waiting for batches on " + ln.Addr().String() + " - press Ctrl+C to stop")
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	return report.ExitOK
+}
+
+// workBatch runs the cases of b like a local run would, the sizes of a story
+// in one tab with reuseViewport.
+func workBatch(ctx context.Context, e *env, store storage.Backend, b remote.Batch, emit func(remote.Result)) error {
+	fmt.Printf("batch %s: %d cases\n", b.ID, len(b.Cases))
+	// die Story-Configs können sich zwischen zwei Batches geändert haben
+	discovered, err := e.cfg.FindAndParseConfigs(e.baseDir)
+	if err != nil {
+		return err
+	}
+	byRef := map[remote.CaseRef]*config.OsnapConfig{}
+	for _, s := range discovered.Configs {
+		byRef[e.caseRef(s)] = s
+	}
+
+	configs := make([]*config.OsnapConfig, len(b.Cases))
+	envOf := map[*config.OsnapConfig]*env{}
+	var order []int
+	for i, ref := range b.Cases {
+		s, ok := byRef[ref]
+		if !ok {
+			emit(remote.Result{Index: i, Case: report.CaseResult{
+				Name:      ref.Label,
+				Source:    ref.Source,
+				StoryFile: ref.Source,
+				Browser:   ref.Browser,
+				Status:    "error",
+				ErrorKind: "worker",
+				Error:     "the worker has no such case, is its checkout at the same commit as the coordinator's?",
+			}})
+			continue
+		}
+		configs[i], envOf[s] = s, e
+		order = append(order, i)
+	}
+	if store != nil {
+		if _, err := compare.PullBaselines(ctx, store, e.layout, compact(configs)); err != nil {
+			return err
+		}
+	}
+
+	r := e.runner(store)
+	r.Update, r.EmitNew = b.UpdateBaselines, b.EmitNew
+	wp := pool.New(*e.flags.concurrency)
+	for _, group := range viewportGroups(order, configs, envOf) {
+		wp.Go(func() error {
+			var tab *snapshot.Tab
+			if len(group) > 1 {
+				tab = &snapshot.Tab{}
+				defer tab.Close()
+			}
+			for _, i := range group {
+				s := configs[i]
+				missing := !s.Skip.Enabled && !tools.FileExists(e.layout.Baseline(s))
+				res, ok := r.Case(ctx, s, missing, tab)
+				if !ok {
+					return nil
+				}
+				emit(remote.Result{Index: i, Case: res, Files: workerFiles(res)})
+			}
+			return nil
+		})
+	}
+	return wp.Wait()
+}
+
+// loopback reports whether addr only listens on this machine.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func compact(configs []*config.OsnapConfig) []*config.OsnapConfig {
+	var out []*config.OsnapConfig
+	for _, s := range configs {
+		if s != nil {
+			out = append(out, s)
+		}
+	}
+	return out
+}
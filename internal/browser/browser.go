@@ -3,21 +3,74 @@ package browser
 import (
 	"context"
 	"errors"
+	"fmt"
 	"math/rand"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/chromedp/chromedp"
+	"github.com/maxischmaxi/qsnap/internal/procs"
 	"github.com/maxischmaxi/qsnap/internal/tools"
 )
 
+// Available reports whether captures for the named engine run in the Chrome
+// pool. Firefox captures go through package firefox instead.
+func Available(engine string) bool {
+	return engine == "" || engine == "chrome"
+}
+
 type Instance struct {
 	AllocCancel context.CancelFunc
-	Ctx         context.Context
+	ctx         context.Context
 	cancel      context.CancelFunc
 	ID          int
+	pid         int
+	// generation zählt die Neustarts des Chrome-Prozesses
+	generation uint64
+
+	// mu schützt die Felder oben, weil Restart sie austauscht
+	mu         sync.RWMutex
+	root       context.Context
+	chromeArgs []string
+
+	// active zählt offene Tabs, served die Captures seit dem Start; beides
+	// und draining geschützt durch sched.mu
+	sched    *scheduler
+	active   int
+	served   int
+	draining bool
+}
+
+// Generation changes every time the Chrome process behind the instance is
+// relaunched, by RestartIfDead, WatchHealth or recycling.
+func (it *Instance) Generation() uint64 {
+	it.mu.RLock()
+	defer it.mu.RUnlock()
+	return it.generation
+}
+
+// Context returns the browser-wide context tabs are created from.
+func (it *Instance) Context() context.Context {
+	it.mu.RLock()
+	defer it.mu.RUnlock()
+	return it.ctx
+}
+
+func (it *Instance) close() {
+	if it.cancel != nil {
+		it.cancel()
+	}
+	if it.AllocCancel != nil {
+		it.AllocCancel()
+	}
+	if it.pid != 0 {
+		procs.Remove("chrome", it.pid)
+	}
 }
 
 type Instances []*Instance
@@ -31,12 +84,9 @@ func (is Instances) Pick() *Instance {
 
 func (is Instances) CloseAll() {
 	for _, it := range is {
-		if it.cancel != nil {
-			it.cancel()
-		}
-		if it.AllocCancel != nil {
-			it.AllocCancel()
-		}
+		it.mu.Lock()
+		it.close()
+		it.mu.Unlock()
 	}
 }
 
@@ -52,28 +102,30 @@ func LaunchPool(root context.Context, n int, chromeArgs []string) (Instances, er
 		n = 1
 	}
 	instances := make([]*Instance, 0, n)
+	sched := &scheduler{freed: make(chan struct{})}
 	for i := 0; i < n; i++ {
-		inst, err := launchOne(root, chromeArgs)
+		inst, err := launchOne(root, i, chromeArgs)
 		if err != nil {
 			// alle bereits gestarteten wieder schließen
 			for _, it := range instances {
-				if it.cancel != nil {
-					it.cancel()
-				}
-				if it.AllocCancel != nil {
-					it.AllocCancel()
-				}
+				it.close()
 			}
 			return nil, err
 		}
 		inst.ID = i
+		inst.root = root
+		inst.chromeArgs = chromeArgs
+		inst.sched = sched
 		instances = append(instances, inst)
 	}
 
 	return instances, nil
 }
 
-func launchOne(root context.Context, chromeArgs []string) (*Instance, error) {
+// defaultDisabledFeatures werden mit einem disable-features aus -chromeArgs zusammengeführt
+const defaultDisabledFeatures = "Translate,BackForwardCache"
+
+func launchOne(root context.Context, id int, chromeArgs []string) (*Instance, error) {
 	opts := chromedp.DefaultExecAllocatorOptions[:]
 	opts = append(opts,
 		chromedp.Flag("headless", true),
@@ -84,26 +136,29 @@ func launchOne(root context.Context, chromeArgs []string) (*Instance, error) {
 		chromedp.Flag("disable-background-timer-throttling", true),
 		chromedp.Flag("disable-renderer-backgrounding", true),
 		chromedp.Flag("disable-ipc-flooding-protection", true),
-		chromedp.Flag("disable-features", "Translate,BackForwardCache"),
+		chromedp.Flag("disable-features", defaultDisabledFeatures),
 		chromedp.Flag("force-color-profile", "srgb"),
 		chromedp.Flag("hide-scrollbars", true),
 		chromedp.Flag("mute-audio", true),
 	)
 
+	// später gesetzte Flags überschreiben die Defaults oben
 	for _, a := range chromeArgs {
-		if a == "" {
+		name, value, ok := ParseChromeArg(a)
+		if !ok {
 			continue
 		}
+		if v, isString := value.(string); isString {
+			value = instanceValue(name, v, id)
+			if name == "disable-features" {
+				value = defaultDisabledFeatures + "," + v
+			}
+		}
 
-		opts = append(opts, chromedp.Flag(a, true))
+		opts = append(opts, chromedp.Flag(name, value))
 	}
 
-	// Optional: eigenen Binary pflegen
-	if bin := os.Getenv("CHROME_BIN"); bin != "" {
-		if tools.FileExists(bin) {
-			opts = append(opts, chromedp.ExecPath(bin))
-		}
-	} else if p, _ := findChrome(); p != "" {
+	if p, _ := ChromePath(); p != "" {
 		opts = append(opts, chromedp.ExecPath(p))
 	}
 
@@ -116,7 +171,94 @@ func launchOne(root context.Context, chromeArgs []string) (*Instance, error) {
 		return nil, err
 	}
 
-	return &Instance{AllocCancel: allocCancel, Ctx: ctx, cancel: cancel}, nil
+	inst := &Instance{AllocCancel: allocCancel, ctx: ctx, cancel: cancel}
+	// PID-Datei, damit `qsnap cleanup` Chrome nach einem harten Abbruch findet
+	if b := chromedp.FromContext(ctx).Browser; b != nil && b.Process() != nil {
+		inst.pid = b.Process().Pid
+		_ = procs.Write("chrome", inst.pid)
+	}
+
+	return inst, nil
+}
+
+// ParseChromeArg turns "--flag", "flag=value" or "--flag=false" into a
+// chromedp flag name and value. A value of false removes the flag.
+func ParseChromeArg(arg string) (string, any, bool) {
+	arg = strings.TrimLeft(strings.TrimSpace(arg), "-")
+	if arg == "" {
+		return "", nil, false
+	}
+
+	name, value, hasValue := strings.Cut(arg, "=")
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, false
+	}
+	if !hasValue {
+		return name, true, true
+	}
+
+	value = strings.TrimSpace(value)
+	switch strings.ToLower(value) {
+	case "true":
+		return name, true, true
+	case "false":
+		return name, false, true
+	}
+	return name, value, true
+}
+
+// ParseChromeArgs splits the value of -chromeArgs. If it uses "--" flags, it
+// is split like a shell command line so values can contain commas
+// ("--disable-features=A,B --lang=de"); otherwise it is comma-separated
+// ("no-sandbox,lang=de-DE").
+func ParseChromeArgs(s string) ([]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(strings.TrimSpace(s), "--") {
+		return tools.SplitCommand(s)
+	}
+	return strings.Split(s, ","), nil
+}
+
+// instanceValue macht Werte pro Instanz eindeutig: {id} wird ersetzt, und
+// Profilverzeichnis und Debugging-Port dürfen sich mehrere Chromes nicht teilen
+func instanceValue(name, value string, id int) string {
+	if strings.Contains(value, "{id}") {
+		return strings.ReplaceAll(value, "{id}", strconv.Itoa(id))
+	}
+	switch name {
+	case "user-data-dir":
+		return filepath.Join(value, strconv.Itoa(id))
+	case "remote-debugging-port":
+		if port, err := strconv.Atoi(value); err == nil && port != 0 {
+			return strconv.Itoa(port + id)
+		}
+	}
+	return value
+}
+
+// pinned ist die Binary aus UseChrome, sie geht $CHROME_BIN vor
+var pinned string
+
+// UseChrome makes ChromePath return path, e.g. a pinned Chrome for Testing.
+func UseChrome(path string) { pinned = path }
+
+// ChromePath returns the Chrome binary qsnap launches: the one passed to
+// UseChrome, $CHROME_BIN if it exists, else the first installed Chrome,
+// Chromium or Edge. An empty path leaves the lookup to chromedp.
+func ChromePath() (string, error) {
+	if pinned != "" {
+		return pinned, nil
+	}
+	if bin := os.Getenv("CHROME_BIN"); bin != "" {
+		if !tools.FileExists(bin) {
+			return "", fmt.Errorf("CHROME_BIN %s does not exist", bin)
+		}
+		return bin, nil
+	}
+	return findChrome()
 }
 
 func findChrome() (string, error) {
@@ -0,0 +1,114 @@
+package browser
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/chromedp"
+)
+
+// Healthy sends a trivial CDP command to the browser process.
+func (it *Instance) Healthy() bool {
+	it.mu.RLock()
+	defer it.mu.RUnlock()
+	return it.healthyLocked()
+}
+
+func (it *Instance) healthyLocked() bool {
+	if it.ctx == nil || it.ctx.Err() != nil {
+		return false
+	}
+	c := chromedp.FromContext(it.ctx)
+	if c == nil || c.Browser == nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(it.ctx, 2*time.Second)
+	defer cancel()
+	_, _, _, _, _, err := browser.GetVersion().Do(cdp.WithExecutor(ctx, c.Browser))
+	return err == nil
+}
+
+// RestartIfDead relaunches the Chrome process behind the instance if it no
+// longer answers. Concurrent callers are serialized, so only the first one
+// restarts and the others see the new, healthy process. It reports whether a
+// restart happened.
+func (it *Instance) RestartIfDead() (bool, error) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	if it.healthyLocked() {
+		return false, nil
+	}
+	if err := it.relaunchLocked(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// relaunchLocked ersetzt den Chrome-Prozess, it.mu muss gehalten werden
+func (it *Instance) relaunchLocked() error {
+	if it.root == nil || it.root.Err() != nil {
+		return fmt.Errorf("browser %d: cannot restart, pool is shutting down", it.ID)
+	}
+
+	it.close()
+	fresh, err := launchOne(it.root, it.ID, it.chromeArgs)
+	if err != nil {
+		return fmt.Errorf("browser %d: restart failed: %w", it.ID, err)
+	}
+	it.AllocCancel, it.ctx, it.cancel, it.pid = fresh.AllocCancel, fresh.ctx, fresh.cancel, fresh.pid
+	it.generation++
+	return nil
+}
+
+// WatchHealth checks every instance periodically and relaunches dead ones
+// until ctx is done.
+func (is Instances) WatchHealth(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		for _, it := range is {
+			if it.Healthy() {
+				continue
+			}
+			restarted, err := it.RestartIfDead()
+			if err != nil && ctx.Err() == nil {
+				log.Println(err)
+			} else if restarted {
+				log.Printf("browser %d was unresponsive and has been restarted", it.ID)
+			}
+		}
+	}
+}
+
+// MinChromeMajor is the oldest Chrome qsnap supports. `qsnap doctor` warns
+// below it, older versions lack CDP features the capture relies on.
+const MinChromeMajor = 112
+
+// Version returns the product string of the instance's Chrome, e.g.
+// "HeadlessChrome/126.0.6478.126", and its major version.
+func (it *Instance) Version(ctx context.Context) (string, int, error) {
+	c := chromedp.FromContext(it.Context())
+	if c == nil || c.Browser == nil {
+		return "", 0, fmt.Errorf("browser %d is not running", it.ID)
+	}
+	_, product, _, _, _, err := browser.GetVersion().Do(cdp.WithExecutor(ctx, c.Browser))
+	if err != nil {
+		return "", 0, err
+	}
+	_, v, _ := strings.Cut(product, "/")
+	major, _ := strconv.Atoi(strings.Split(v, ".")[0])
+	return product, major, nil
+}
@@ -0,0 +1,123 @@
+package browser
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// scheduler verteilt Tabs auf die Instanzen eines Pools; active und limit
+// werden von allen Instanzen geteilt über mu geschützt
+type scheduler struct {
+	mu    sync.Mutex
+	limit int           // max. Tabs pro Instanz, 0 = unbegrenzt
+	freed chan struct{} // wird bei jedem Release geschlossen und ersetzt
+
+	recycleAfter int // Captures bis zum Neustart einer Instanz, 0 = nie
+}
+
+// SetTabLimit caps the number of concurrent tabs per instance (0 = no cap).
+// Too many tabs in one Chrome make CDP calls time out sporadically.
+func (is Instances) SetTabLimit(n int) {
+	if len(is) == 0 {
+		return
+	}
+	s := is[0].sched
+	s.mu.Lock()
+	s.limit = max(n, 0)
+	s.mu.Unlock()
+}
+
+// SetRecycleAfter restarts an instance once it has served n captures (0 =
+// never), to keep Chrome's memory from growing over long runs. The instance
+// gets no new tabs from then on and is restarted when its last tab closes.
+func (is Instances) SetRecycleAfter(n int) {
+	if len(is) == 0 {
+		return
+	}
+	s := is[0].sched
+	s.mu.Lock()
+	s.recycleAfter = max(n, 0)
+	s.mu.Unlock()
+}
+
+// Captured counts a capture toward SetRecycleAfter.
+func (it *Instance) Captured() {
+	s := it.sched
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it.served++
+	if s.recycleAfter > 0 && it.served >= s.recycleAfter {
+		it.draining = true
+	}
+}
+
+// Acquire returns the instance with the fewest open tabs that is below the
+// tab limit, waiting until one frees up. release must be called once the tab
+// is closed.
+func (is Instances) Acquire(ctx context.Context) (*Instance, func(), error) {
+	s := is[0].sched
+	for {
+		s.mu.Lock()
+		var best *Instance
+		for _, it := range is {
+			if it.draining || s.limit > 0 && it.active >= s.limit {
+				continue
+			}
+			if best == nil || it.active < best.active {
+				best = it
+			}
+		}
+		if best != nil {
+			best.active++
+			s.mu.Unlock()
+			var once sync.Once
+			return best, func() { once.Do(func() { s.release(best) }) }, nil
+		}
+		wait := s.freed
+		s.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+}
+
+func (s *scheduler) release(it *Instance) {
+	s.mu.Lock()
+	it.active--
+	// draining bekommt keine neuen Tabs, nur der letzte Release kommt hier durch
+	recycle := it.draining && it.active == 0
+	s.notifyLocked()
+	s.mu.Unlock()
+	if recycle {
+		go s.recycle(it)
+	}
+}
+
+func (s *scheduler) notifyLocked() {
+	close(s.freed)
+	s.freed = make(chan struct{})
+}
+
+// recycle startet eine leergelaufene Instanz neu und gibt sie wieder frei;
+// schlägt der Start fehl, übernimmt der Health-Check
+func (s *scheduler) recycle(it *Instance) {
+	it.mu.Lock()
+	err := it.relaunchLocked()
+	shuttingDown := it.root == nil || it.root.Err() != nil
+	it.mu.Unlock()
+	if err != nil && !shuttingDown {
+		log.Println(err)
+	}
+
+	s.mu.Lock()
+	it.served, it.draining = 0, false
+	s.notifyLocked()
+	s.mu.Unlock()
+}
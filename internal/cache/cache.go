@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/maxischmaxi/qsnap/internal/tools"
+)
+
+// Cache remembers the input hash of every case that passed, so unchanged
+// cases can be skipped in the next run.
+type Cache struct {
+	path string
+
+	mu      sync.Mutex
+	Entries map[string]string `json:"entries"` // case label -> input hash
+}
+
+// Load reads the cache file; a missing file is an empty cache.
+func Load(path string) (*Cache, error) {
+	c := &Cache{path: path, Entries: map[string]string{}}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, err
+	}
+	if c.Entries == nil {
+		c.Entries = map[string]string{}
+	}
+	return c, nil
+}
+
+func (c *Cache) Hit(label, key string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Entries[label] == key
+}
+
+func (c *Cache) Put(label, key string) {
+	c.mu.Lock()
+	c.Entries[label] = key
+	c.mu.Unlock()
+}
+
+func (c *Cache) Delete(label string) {
+	c.mu.Lock()
+	delete(c.Entries, label)
+	c.mu.Unlock()
+}
+
+func (c *Cache) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return tools.WriteFile(c.path, b)
+}
+
+// Key hashes all inputs of a case. Parts are length-prefixed so that
+// ("ab", "c") and ("a", "bc") differ.
+func Key(parts ...[]byte) string {
+	h := sha256.New()
+	for _, p := range parts {
+		var n [8]byte
+		for i, l := 0, uint64(len(p)); i < 8; i, l = i+1, l>>8 {
+			n[i] = byte(l)
+		}
+		h.Write(n[:])
+		h.Write(p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
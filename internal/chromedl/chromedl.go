@@ -0,0 +1,305 @@
+// Package chromedl downloads pinned Chrome for Testing builds into a cache
+// directory, so every machine captures with the same Chrome.
+package chromedl
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/maxischmaxi/qsnap/internal/tools"
+)
+
+// DefaultIndex is the Chrome for Testing JSON API.
+const DefaultIndex = "https://googlechromelabs.github.io/chrome-for-testing"
+
+// Channels are the names Install accepts besides versions and milestones.
+var Channels = []string{"stable", "beta", "dev", "canary"}
+
+var (
+	fullVersion = regexp.MustCompile(`^\d+\.\d+\.\d+\.\d+$`)
+	milestone   = regexp.MustCompile(`^\d+$`)
+)
+
+// Valid reports whether v is a full version ("126.0.6478.126"), a milestone
+// ("126") or a channel ("stable").
+func Valid(v string) bool {
+	v = strings.ToLower(v)
+	return fullVersion.MatchString(v) || milestone.MatchString(v) || slices.Contains(Channels, v)
+}
+
+// DefaultCacheDir is <user cache dir>/qsnap/chrome, or $QSNAP_CHROME_CACHE.
+func DefaultCacheDir() string {
+	if dir := os.Getenv("QSNAP_CHROME_CACHE"); dir != "" {
+		return dir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "qsnap", "chrome")
+}
+
+// Platform is the Chrome for Testing platform of this machine, e.g. linux64.
+func Platform() (string, error) {
+	switch runtime.GOOS + "/" + runtime.GOARCH {
+	case "linux/amd64":
+		return "linux64", nil
+	case "darwin/arm64":
+		return "mac-arm64", nil
+	case "darwin/amd64":
+		return "mac-x64", nil
+	case "windows/amd64", "windows/arm64":
+		return "win64", nil
+	case "windows/386":
+		return "win32", nil
+	}
+	return "", fmt.Errorf("chrome for testing has no builds for %s/%s", runtime.GOOS, runtime.GOARCH)
+}
+
+// binary ist der Pfad der Chrome-Binary innerhalb des entpackten Zips
+func binary(platform string) string {
+	switch platform {
+	case "mac-arm64", "mac-x64":
+		return filepath.Join("chrome-"+platform, "Google Chrome for Testing.app", "Contents", "MacOS", "Google Chrome for Testing")
+	case "win32", "win64":
+		return filepath.Join("chrome-"+platform, "chrome.exe")
+	}
+	return filepath.Join("chrome-"+platform, "chrome")
+}
+
+type Client struct {
+	HTTP *http.Client
+	// Index defaults to DefaultIndex.
+	Index string
+	// CacheDir defaults to DefaultCacheDir.
+	CacheDir string
+	// Progress, if set, is told about downloads.
+	Progress func(format string, args ...any)
+}
+
+type download struct {
+	Platform string `json:"platform"`
+	URL      string `json:"url"`
+}
+
+type build struct {
+	Version   string `json:"version"`
+	Downloads struct {
+		Chrome []download `json:"chrome"`
+	} `json:"downloads"`
+}
+
+// Install returns the Chrome binary for version, downloading and unpacking
+// it into the cache first if needed. A full version that is already cached
+// needs no network; milestones and channels are resolved online.
+func (c *Client) Install(ctx context.Context, version string) (string, error) {
+	if !Valid(version) {
+		return "", fmt.Errorf("chrome version %q: expected a version like 126.0.6478.126, a milestone like 126 or one of %s", version, strings.Join(Channels, ", "))
+	}
+	platform, err := Platform()
+	if err != nil {
+		return "", err
+	}
+	cache := c.CacheDir
+	if cache == "" {
+		cache = DefaultCacheDir()
+	}
+	if fullVersion.MatchString(version) {
+		if bin := filepath.Join(cache, version, platform, binary(platform)); tools.FileExists(bin) {
+			return bin, nil
+		}
+	}
+
+	b, err := c.resolve(ctx, strings.ToLower(version))
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(cache, b.Version, platform)
+	bin := filepath.Join(dir, binary(platform))
+	if tools.FileExists(bin) {
+		return bin, nil
+	}
+	var url string
+	for _, d := range b.Downloads.Chrome {
+		if d.Platform == platform {
+			url = d.URL
+		}
+	}
+	if url == "" {
+		return "", fmt.Errorf("chrome %s has no download for %s", b.Version, platform)
+	}
+
+	if c.Progress != nil {
+		c.Progress("downloading chrome %s for %s", b.Version, platform)
+	}
+	data, err := c.fetch(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return "", err
+	}
+	// erst in ein Temp-Verzeichnis entpacken, damit ein Abbruch keinen halben Chrome im Cache lässt
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".download-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	if err := unzip(data, tmp); err != nil {
+		return "", fmt.Errorf("unpack chrome %s: %w", b.Version, err)
+	}
+	if !tools.FileExists(filepath.Join(tmp, binary(platform))) {
+		return "", fmt.Errorf("chrome %s: the download has no %s", b.Version, binary(platform))
+	}
+	if err := os.Rename(tmp, dir); err != nil && !tools.FileExists(bin) {
+		// ein paralleler Run kann schneller gewesen sein
+		return "", err
+	}
+	return bin, nil
+}
+
+func (c *Client) resolve(ctx context.Context, version string) (*build, error) {
+	switch {
+	case fullVersion.MatchString(version):
+		var idx struct {
+			Versions []build `json:"versions"`
+		}
+		if err := c.getJSON(ctx, "known-good-versions-with-downloads.json", &idx); err != nil {
+			return nil, err
+		}
+		for i := range idx.Versions {
+			if idx.Versions[i].Version == version {
+				return &idx.Versions[i], nil
+			}
+		}
+		return nil, fmt.Errorf("chrome %s is not a chrome for testing version", version)
+	case milestone.MatchString(version):
+		var idx struct {
+			Milestones map[string]build `json:"milestones"`
+		}
+		if err := c.getJSON(ctx, "latest-versions-per-milestone-with-downloads.json", &idx); err != nil {
+			return nil, err
+		}
+		b, ok := idx.Milestones[version]
+		if !ok {
+			return nil, fmt.Errorf("chrome for testing has no milestone %s", version)
+		}
+		return &b, nil
+	}
+	var idx struct {
+		Channels map[string]build `json:"channels"`
+	}
+	if err := c.getJSON(ctx, "last-known-good-versions-with-downloads.json", &idx); err != nil {
+		return nil, err
+	}
+	// die API schreibt die Channels groß: Stable, Beta, Dev, Canary
+	b, ok := idx.Channels[strings.ToUpper(version[:1])+version[1:]]
+	if !ok {
+		return nil, fmt.Errorf("chrome for testing has no channel %s", version)
+	}
+	return &b, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, name string, out any) error {
+	index := c.Index
+	if index == "" {
+		index = DefaultIndex
+	}
+	data, err := c.fetch(ctx, strings.TrimRight(index, "/")+"/"+name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+func (c *Client) fetch(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	hc := c.HTTP
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// unzip entpackt data nach dir, mit Dateirechten und den Symlinks der
+// macOS-Frameworks; Pfade außerhalb von dir werden abgelehnt
+func unzip(data []byte, dir string) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		path := filepath.Join(dir, filepath.FromSlash(f.Name))
+		if !strings.HasPrefix(path, filepath.Clean(dir)+string(filepath.Separator)) {
+			return fmt.Errorf("%s: path outside the archive", f.Name)
+		}
+		mode := f.Mode()
+		if mode.IsDir() {
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		if mode&os.ModeSymlink != 0 {
+			target, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+			if filepath.IsAbs(string(target)) || !strings.HasPrefix(filepath.Join(filepath.Dir(path), string(target)), filepath.Clean(dir)+string(filepath.Separator)) {
+				return errors.New(f.Name + ": symlink outside the archive")
+			}
+			if err := os.Symlink(string(target), path); err != nil {
+				return err
+			}
+			continue
+		}
+		out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0o200)
+		if err != nil {
+			rc.Close()
+			return err
+		}
+		_, err = io.Copy(out, rc)
+		rc.Close()
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
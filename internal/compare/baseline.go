@@ -0,0 +1,67 @@
+package compare
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/maxischmaxi/qsnap/internal/config"
+	"github.com/maxischmaxi/qsnap/internal/report"
+	"github.com/maxischmaxi/qsnap/internal/storage"
+	"github.com/maxischmaxi/qsnap/internal/tools"
+)
+
+// WriteBaseline stores data as the baseline of c, locally and in remote
+// storage if configured. With branch baselines it goes to the branch and c
+// uses it from then on.
+func WriteBaseline(ctx context.Context, store storage.Backend, c *report.CaseResult, data []byte) error {
+	if c.BranchBaseline != "" {
+		c.Baseline, c.BranchBaseline = c.BranchBaseline, ""
+	}
+	if err := tools.WriteFile(c.Baseline, data); err != nil {
+		return err
+	}
+
+	if store != nil {
+		key := c.BaselineKey
+		if key == "" {
+			key = store.Key(filepath.Base(c.Baseline))
+		}
+		if err := store.Upload(ctx, c.Baseline, key); err != nil {
+			return err
+		}
+		c.BaselineKey = key
+	}
+	return nil
+}
+
+// PullBaselines downloads the baselines of cases from store. With a branch,
+// the branch baseline is tried first and the shared one only if it has none.
+// Cases without a remote baseline are skipped; it returns how many were
+// downloaded.
+func PullBaselines(ctx context.Context, store storage.Backend, layout config.Layout, cases []*config.OsnapConfig) (int, error) {
+	pulled := 0
+	for _, s := range cases {
+		if branch := layout.BranchBaseline(s); branch != "" {
+			err := store.Download(ctx, store.Key(layout.RemoteName(s)), branch)
+			if err == nil {
+				pulled++
+				continue
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				return pulled, err
+			}
+		}
+		_, baselinePath := layout.CasePaths(s)
+		err := store.Download(ctx, store.Key(filepath.ToSlash(layout.Filename(s))), baselinePath)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return pulled, err
+		}
+		pulled++
+	}
+	return pulled, nil
+}
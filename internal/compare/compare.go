@@ -0,0 +1,154 @@
+package compare
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/maxischmaxi/qsnap/internal/config"
+	"github.com/maxischmaxi/qsnap/internal/diff"
+	"github.com/maxischmaxi/qsnap/internal/report"
+	"github.com/maxischmaxi/qsnap/internal/rule"
+)
+
+// NewResult returns the result of s with everything but the outcome set.
+func NewResult(layout config.Layout, s *config.OsnapConfig) report.CaseResult {
+	diffPath, _ := layout.CasePaths(s)
+	res := report.CaseResult{
+		Name:        s.Name,
+		URL:         s.URL,
+		Source:      s.Source,
+		StoryFile:   s.StoryFile,
+		Width:       s.Width,
+		Height:      s.Height,
+		Browser:     s.Browser,
+		ColorScheme: s.ColorScheme,
+		Device:      s.Device,
+		OutPath:     diffPath,
+		Baseline:    layout.Baseline(s),
+	}
+	if b := layout.BranchBaseline(s); b != res.Baseline {
+		res.BranchBaseline = b
+	}
+	return res
+}
+
+// Skipped is the result of a case with `skip` set.
+func Skipped(layout config.Layout, s *config.OsnapConfig) report.CaseResult {
+	res := NewResult(layout, s)
+	res.Status = "skipped-config"
+	res.SkipReason = s.Skip.Reason
+	return res
+}
+
+// Case compares the capture buf with the baseline of res and sets its
+// threshold, diff results, DiffTime and status: pass, fail, no-baseline,
+// image-too-large or error. A failWhen rule of s decides instead of the
+// pixel threshold.
+func Case(cfg *config.OsnapBaseConfig, s *config.OsnapConfig, res *report.CaseResult, buf []byte) {
+	threshold := cfg.Threshold
+	if s.Threshold != nil {
+		threshold = *s.Threshold
+	}
+
+	res.Threshold = threshold.Fraction()
+	res.MaxDiffPixels = s.MaxDiffPixels
+	diffStart := time.Now()
+	df, ph, ss, err := diff.CompareFiles(res.Baseline, buf, res.OutPath, diff.Options{
+		PixelThreshold: res.Threshold,
+		MaxDiffPixels:  s.MaxDiffPixels,
+		PHashThreshold: hashThreshold(s),
+		Hash:           s.HashAlgorithm,
+		ComputeHash:    strings.Contains(s.FailWhen, "hamming"),
+		Highlight:      cfg.DiffColor(),
+		Gutter:         cfg.Gutter(s),
+		MaxDimension:   cfg.MaxImageDimension,
+		KeepDiff:       s.FailWhen != "",
+		SSIM:           ssimMin(s),
+		// der Score ist teuer, also nur rechnen, wenn eine Regel ihn braucht
+		ComputeSSIM: strings.Contains(s.FailWhen, "ssim"),
+	})
+	res.DiffTime = time.Since(diffStart)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		res.Status = "no-baseline"
+		return
+	case errors.Is(err, diff.ErrImageTooLarge):
+		res.Status = "image-too-large"
+		res.Error = err.Error()
+		return
+	case err != nil:
+		res.Status = "error"
+		res.ErrorKind = "compare"
+		res.Error = err.Error()
+		return
+	}
+
+	res.Status = "pass"
+	res.PixelDiff = &df
+	res.PercepDiff = ph
+	res.SSIMDiff = ss
+	if !df.Pass {
+		res.Status = "fail"
+	}
+	if ssimMin(s) > 0 {
+		res.Status = "pass"
+		if !ss.Pass {
+			res.Status = "fail"
+		}
+		res.ComparedBy = "ssim"
+		res.CompareMessage = fmt.Sprintf("ssim %.4f, minimum %.4f", ss.Score, ssimMin(s))
+	}
+	if s.FailWhen != "" {
+		applyRule(res, s.FailWhen, df, ph, ss)
+	}
+}
+
+func hashThreshold(s *config.OsnapConfig) int {
+	if s.HashThreshold == nil {
+		return config.DefaultHashThreshold
+	}
+	return *s.HashThreshold
+}
+
+func ssimMin(s *config.OsnapConfig) float64 {
+	if s.SSIM == nil {
+		return 0
+	}
+	return *s.SSIM
+}
+
+// applyRule lets the story's failWhen expression decide instead of the pixel
+// threshold. An expression that can't be evaluated makes the case an error.
+func applyRule(res *report.CaseResult, expr string, df diff.PixelResult, ph *diff.PHashResult, ss *diff.SSIMResult) {
+	score := 1.0
+	if ss != nil {
+		score = ss.Score
+	}
+	hamming := 0
+	if ph != nil {
+		hamming = ph.HammingDistance
+	}
+	fails, err := rule.Eval(expr, rule.Metrics{
+		Ratio:     df.RatioDiff,
+		Threshold: res.Threshold,
+		Hamming:   hamming,
+		Regions:   df.Regions,
+		Duration:  res.CaptureTime + res.DiffTime,
+		SSIM:      score,
+	})
+	if err != nil {
+		res.Status = "error"
+		res.ErrorKind = "compare"
+		res.Error = err.Error()
+		return
+	}
+	res.Status = "pass"
+	if fails {
+		res.Status = "fail"
+	}
+	res.ComparedBy = "failWhen"
+	res.CompareMessage = expr
+}
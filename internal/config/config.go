@@ -3,12 +3,16 @@ package config
 import (
 	"errors"
 	"fmt"
+	"image/color"
 	"io"
+	"net/http"
 	"os"
 	"path/filepath"
 	"slices"
 	"strings"
 
+	"github.com/maxischmaxi/qsnap/internal/devices"
+	"github.com/maxischmaxi/qsnap/internal/rule"
 	"github.com/maxischmaxi/qsnap/internal/tools"
 	"gopkg.in/yaml.v3"
 )
@@ -17,6 +21,27 @@ type Size struct {
 	Name   string `yaml:"name" json:"name"`
 	Width  int    `yaml:"width" json:"width"`
 	Height int    `yaml:"height" json:"height"`
+	// Device emuliert ein Gerät (z.B. "iPhone 14"): Viewport, Pixel Ratio, User Agent und Touch.
+	// Width/Height sind dann optional und überschreiben die Werte des Geräts.
+	Device string `yaml:"device,omitempty" json:"device,omitempty"`
+}
+
+// resolve fills width and height from the device preset if not set.
+func (s Size) resolve() (Size, error) {
+	if s.Device == "" {
+		return s, nil
+	}
+	d, ok := devices.Lookup(s.Device)
+	if !ok {
+		return s, fmt.Errorf("unknown device %q", s.Device)
+	}
+	if s.Width == 0 {
+		s.Width = int(d.Width)
+	}
+	if s.Height == 0 {
+		s.Height = int(d.Height)
+	}
+	return s, nil
 }
 
 type Sizes struct {
@@ -42,7 +67,7 @@ func (s *Sizes) UnmarshalYAML(unmarshal func(any) error) error {
 
 	// 3) Versuche einzelnes Size-Objekt
 	var one Size
-	if err := unmarshal(&one); err == nil && (one.Width != 0 || one.Height != 0) {
+	if err := unmarshal(&one); err == nil && (one.Width != 0 || one.Height != 0 || one.Device != "") {
 		s.One = &one
 		return nil
 	}
@@ -52,6 +77,10 @@ func (s *Sizes) UnmarshalYAML(unmarshal func(any) error) error {
 
 func (s Sizes) AsStrings() []string { return s.Strings }
 
+func (s Sizes) empty() bool {
+	return len(s.Strings) == 0 && len(s.Structs) == 0 && s.One == nil
+}
+
 func (s Sizes) AsSizes() []Size {
 	if s.One != nil {
 		return []Size{*s.One}
@@ -59,38 +88,411 @@ func (s Sizes) AsSizes() []Size {
 	return s.Structs
 }
 
+type Rect struct {
+	X      int `yaml:"x" json:"x"`
+	Y      int `yaml:"y" json:"y"`
+	Width  int `yaml:"width" json:"width"`
+	Height int `yaml:"height" json:"height"`
+}
+
+// IgnoreRegion is either a CSS selector or a pixel rect (page coordinates)
+// that gets blacked out before the screenshot is taken.
+type IgnoreRegion struct {
+	Selector string `yaml:"selector,omitempty" json:"selector,omitempty"`
+	Rect     `yaml:",inline"`
+}
+
+func (r *IgnoreRegion) UnmarshalYAML(unmarshal func(any) error) error {
+	// 1) Kurzform: nur ein Selektor als String
+	var sel string
+	if err := unmarshal(&sel); err == nil {
+		r.Selector = sel
+		return nil
+	}
+
+	type plain IgnoreRegion
+	var p plain
+	if err := unmarshal(&p); err != nil {
+		return err
+	}
+	if p.Selector == "" && (p.Width <= 0 || p.Height <= 0) {
+		return fmt.Errorf("ignore entry needs a selector or a positive width and height")
+	}
+	*r = IgnoreRegion(p)
+	return nil
+}
+
 type DiffPixelColor struct {
 	R int `yaml:"r" json:"r"`
 	G int `yaml:"g" json:"g"`
 	B int `yaml:"b" json:"b"`
 }
 
+const DefaultBrowser = "chrome"
+
+const DefaultMainBranch = "main"
+
+// DefaultStabilizeIntervalMs is the pause between the captures of stabilize.
+const DefaultStabilizeIntervalMs = 200
+
+// DefaultHashThreshold is the allowed Hamming distance of the perceptual hash.
+const DefaultHashThreshold = 10
+
+// HashAlgorithms are the perceptual hashes a story can choose with hashAlgorithm.
+var HashAlgorithms = []string{"phash", "dhash", "ahash"}
+
+// Browsers are the engines a story can ask for. Whether one is actually
+// available is up to the browser package.
+var Browsers = []string{"chrome", "firefox", "webkit"}
+
+// DiffColor returns the configured highlight color for differing pixels,
+// magenta if diffPixelColor is not set.
+func (cfg *OsnapBaseConfig) DiffColor() color.RGBA {
+	if c := cfg.DiffPixelColor; c != nil {
+		return color.RGBA{uint8(c.R), uint8(c.G), uint8(c.B), 255}
+	}
+	return color.RGBA{255, 0, 255, 255}
+}
+
+// DefaultWaitSelectors are the root elements of Storybook 7+ and 6.
+var DefaultWaitSelectors = []string{"#storybook-root", "#root"}
+
+// DefaultMaxImageDimension is Chrome's maximum texture size; larger captures
+// are mostly runaway full-page screenshots of infinite-scroll stories.
+const DefaultMaxImageDimension = 16384
+
+// DefaultScrollbarGutter is the width of a classic Chrome scrollbar.
+const DefaultScrollbarGutter = 15
+
+// Gutter returns the scrollbar gutter to exclude for s. Element screenshots
+// have no scrollbars, so nothing is excluded for them.
+func (cfg *OsnapBaseConfig) Gutter(s *OsnapConfig) int {
+	if s.Selector != "" {
+		return 0
+	}
+	if cfg.ScrollbarGutter != nil {
+		return *cfg.ScrollbarGutter
+	}
+	return DefaultScrollbarGutter
+}
+
 type OsnapBaseConfig struct {
-	BaseURL           string         `yaml:"baseUrl" json:"baseUrl"`
-	FullScreen        bool           `yaml:"fullScreen" json:"fullScreen"`
-	Threshold         int            `yaml:"threshold" json:"threshold"`
-	Retry             int            `yaml:"retry" json:"retry"`
-	SnapshotDirectory string         `yaml:"snapshotDirectory" json:"snapshotDirectory"`
-	TestPattern       string         `yaml:"testPattern" json:"testPattern"`
-	IgnorePatterns    []string       `yaml:"ignorePatterns" json:"ignorePatterns"`
-	DefaultSizes      []Size         `yaml:"defaultSizes" json:"defaultSizes"`
-	DiffPixelColor    DiffPixelColor `yaml:"diffPixelColor" json:"diffPixelColor"`
+	BaseURL    string `yaml:"baseUrl" json:"baseUrl"`
+	FullScreen bool   `yaml:"fullScreen" json:"fullScreen"`
+	// Stitch nimmt fullScreen abschnittsweise beim Scrollen auf und setzt die
+	// Bilder zusammen, statt einen Screenshot über die ganze Höhe zu machen
+	Stitch bool `yaml:"stitch,omitempty" json:"stitch,omitempty"`
+	// FreezeFixed macht fixed/sticky Elemente beim Stitchen zu normalen, damit
+	// sie nur einmal im Bild sind
+	FreezeFixed bool `yaml:"freezeFixed,omitempty" json:"freezeFixed,omitempty"`
+	// Threshold ist der erlaubte Anteil abweichender Pixel in Prozent, z.B. 0.15
+	Threshold Percent `yaml:"threshold" json:"threshold"`
+	// MaxDiffPixels erlaubt stattdessen eine feste Anzahl abweichender Pixel (nil = threshold gilt)
+	MaxDiffPixels     *int   `yaml:"maxDiffPixels,omitempty" json:"maxDiffPixels,omitempty"`
+	Retry             int    `yaml:"retry" json:"retry"`
+	SnapshotDirectory string `yaml:"snapshotDirectory" json:"snapshotDirectory"`
+	// SnapshotDirectoryFromCwd löst snapshotDirectory wie früher relativ zum Arbeitsverzeichnis auf
+	SnapshotDirectoryFromCwd bool `yaml:"snapshotDirectoryFromCwd,omitempty" json:"snapshotDirectoryFromCwd,omitempty"`
+	// OutputDir ersetzt <input>/../__image-snapshots__, relativ zur Basis-Config
+	OutputDir string `yaml:"outputDir,omitempty" json:"outputDir,omitempty"`
+	// BranchBaselines gibt jedem Branch außer MainBranch eigene Baselines, die
+	// auf die des Hauptbranches zurückfallen
+	BranchBaselines bool `yaml:"branchBaselines,omitempty" json:"branchBaselines,omitempty"`
+	// MainBranch ist der Branch mit den geteilten Baselines, Default main
+	MainBranch string `yaml:"mainBranch,omitempty" json:"mainBranch,omitempty"`
+	// FilenameTemplate benennt die Bilder eines Cases, z.B. "{name}/{size}/{scheme}.png"
+	FilenameTemplate string          `yaml:"filenameTemplate,omitempty" json:"filenameTemplate,omitempty"`
+	TestPattern      string          `yaml:"testPattern" json:"testPattern"`
+	IgnorePatterns   []string        `yaml:"ignorePatterns" json:"ignorePatterns"`
+	DefaultSizes     []Size          `yaml:"defaultSizes" json:"defaultSizes"`
+	DiffPixelColor   *DiffPixelColor `yaml:"diffPixelColor" json:"diffPixelColor"`
+	// BaselineStorage ist optional, z.B. "s3://bucket/prefix" oder "gs://bucket/prefix"
+	BaselineStorage string `yaml:"baselineStorage,omitempty" json:"baselineStorage,omitempty"`
+	// Browser ist die Engine für Stories ohne eigenes `browser`, Default chrome
+	Browser string `yaml:"browser,omitempty" json:"browser,omitempty"`
+	// Plugins sind Kommandozeilen, die per JSON über stdin/stdout angebunden werden
+	Plugins []string `yaml:"plugins,omitempty" json:"plugins,omitempty"`
+	// NetworkIdleMs wartet vor dem Screenshot, bis so lange kein Request offen war (0 = aus)
+	NetworkIdleMs int `yaml:"networkIdleMs,omitempty" json:"networkIdleMs,omitempty"`
+	// SettleMs ist eine zusätzliche Pause nach document.fonts.ready vor dem Screenshot
+	SettleMs int `yaml:"settleMs,omitempty" json:"settleMs,omitempty"`
+	// Stabilize nimmt bis zu so viele Screenshots auf, bis zwei aufeinanderfolgende
+	// gleich sind (0 = aus); ohne Ruhe wird ein fail zu unstable
+	Stabilize int `yaml:"stabilize,omitempty" json:"stabilize,omitempty"`
+	// StabilizeIntervalMs ist die Pause zwischen diesen Screenshots, Default 200
+	StabilizeIntervalMs int `yaml:"stabilizeIntervalMs,omitempty" json:"stabilizeIntervalMs,omitempty"`
+	// Seed wird als Storybook-Global und window.__QSNAP_SEED__ an die Stories gegeben
+	Seed *int `yaml:"seed,omitempty" json:"seed,omitempty"`
+	// ScrollbarGutter Pixel am rechten/unteren Rand werden nicht verglichen, Default 15, 0 = aus
+	ScrollbarGutter *int `yaml:"scrollbarGutter,omitempty" json:"scrollbarGutter,omitempty"`
+	// MaxImageDimension begrenzt Viewport und Screenshot pro Seite, Default 16384
+	MaxImageDimension int `yaml:"maxImageDimension,omitempty" json:"maxImageDimension,omitempty"`
+	// FailWhen ist ein CEL-Ausdruck über ratio, threshold, hamming, regions und duration,
+	// der statt des Thresholds über fail entscheidet, z.B. "ratio > 0.01 || regions > 3"
+	FailWhen string `yaml:"failWhen,omitempty" json:"failWhen,omitempty"`
+	// SSIM ist der Mindest-Score (0-1) der strukturellen Ähnlichkeit; gesetzt
+	// entscheidet er statt des Pixel-Thresholds, 0 = aus
+	SSIM float64 `yaml:"ssim,omitempty" json:"ssim,omitempty"`
+	// HashAlgorithm ist phash (Default), dhash oder ahash; HashThreshold die
+	// erlaubte Hamming-Distanz, Default 10. Gerechnet wird nur, wenn der
+	// Pixel-Vergleich fehlschlägt oder failWhen hamming braucht
+	HashAlgorithm string `yaml:"hashAlgorithm,omitempty" json:"hashAlgorithm,omitempty"`
+	HashThreshold *int   `yaml:"hashThreshold,omitempty" json:"hashThreshold,omitempty"`
+	// Notify schickt nach dem Run eine Zusammenfassung an einen Webhook
+	Notify *Notify `yaml:"notify,omitempty" json:"notify,omitempty"`
+	// ChromeArgs werden vor -chromeArgs an jede Chrome-Instanz übergeben, z.B. "--lang=de-DE"
+	ChromeArgs []string `yaml:"chromeArgs,omitempty" json:"chromeArgs,omitempty"`
+	// ChromeVersion pinnt einen Chrome-for-Testing-Build, der heruntergeladen wird (leer = installierter Chrome)
+	ChromeVersion string `yaml:"chromeVersion,omitempty" json:"chromeVersion,omitempty"`
+	// WaitSelectors: vor dem Screenshot muss einer davon da sein, Default DefaultWaitSelectors
+	WaitSelectors []string `yaml:"waitSelectors,omitempty" json:"waitSelectors,omitempty"`
+	// WaitFor ist "ready" (im DOM) oder "visible" (sichtbar gerendert), Default ready
+	WaitFor string `yaml:"waitFor,omitempty" json:"waitFor,omitempty"`
+	// WaitForPlay wartet vor dem Screenshot, bis die play-Funktion der Story fertig ist
+	WaitForPlay bool `yaml:"waitForPlay,omitempty" json:"waitForPlay,omitempty"`
+	// Timezone (IANA, z.B. "Europe/Berlin"), Locale (BCP 47, z.B. "de-DE")
+	// und Geolocation werden in jedem Tab emuliert
+	Timezone    string       `yaml:"timezone,omitempty" json:"timezone,omitempty"`
+	Locale      string       `yaml:"locale,omitempty" json:"locale,omitempty"`
+	Geolocation *Geolocation `yaml:"geolocation,omitempty" json:"geolocation,omitempty"`
+	// Headers und BasicAuth gehen mit jedem Request an den Storybook- bzw.
+	// baseUrl-Host, ${VAR} wird aus der Umgebung ersetzt
+	Headers   map[string]string `yaml:"headers,omitempty" json:"-"`
+	BasicAuth *BasicAuth        `yaml:"basicAuth,omitempty" json:"-"`
+	// BlockHosts lässt Requests an diese Hosts und ihre Subdomains scheitern,
+	// z.B. Analytics oder Font-CDNs
+	BlockHosts []string `yaml:"blockHosts,omitempty" json:"blockHosts,omitempty"`
+	// AllowHosts blockt alle anderen Hosts außer dem der Story
+	AllowHosts []string `yaml:"allowHosts,omitempty" json:"allowHosts,omitempty"`
+	// Mocks beantworten passende Requests mit Fixtures statt aus dem Netz
+	Mocks []Mock `yaml:"mocks,omitempty" json:"mocks,omitempty"`
+	// Cookies und LocalStorage werden vor dem Laden der Story gesetzt, z.B.
+	// für Feature-Flags oder ein weggeklicktes Consent-Banner
+	Cookies      []Cookie          `yaml:"cookies,omitempty" json:"-"`
+	LocalStorage map[string]string `yaml:"localStorage,omitempty" json:"-"`
+	// ReuseViewport nimmt alle Größen einer Story in einem Tab auf und ändert
+	// dazwischen nur den Viewport
+	ReuseViewport bool `yaml:"reuseViewport,omitempty" json:"reuseViewport,omitempty"`
+
+	fragments  map[string]*Fragment
+	dirConfigs map[string]*DirConfig // Verzeichnis -> osnap.dir.yaml
+}
+
+// BasicAuth is sent as an Authorization header, not in answer to a 401, so
+// it also works with auth proxies that redirect to a login page.
+type BasicAuth struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// Geolocation is reported by navigator.geolocation; the permission is
+// granted for the story's origin. Accuracy is in meters, default 1.
+type Geolocation struct {
+	Latitude  float64 `yaml:"latitude" json:"latitude"`
+	Longitude float64 `yaml:"longitude" json:"longitude"`
+	Accuracy  float64 `yaml:"accuracy,omitempty" json:"accuracy,omitempty"`
+}
+
+func (g *Geolocation) check() error {
+	if g == nil {
+		return nil
+	}
+	if g.Latitude < -90 || g.Latitude > 90 || g.Longitude < -180 || g.Longitude > 180 {
+		return fmt.Errorf("geolocation: latitude must be between -90 and 90, longitude between -180 and 180")
+	}
+	if g.Accuracy < 0 {
+		return fmt.Errorf("geolocation: accuracy must be non-negative")
+	}
+	return nil
+}
+
+// checkHash: die Hashes haben 64 Bit, mehr Distanz gibt es nicht
+func checkHash(algorithm string, threshold *int) error {
+	if !slices.Contains(HashAlgorithms, algorithm) {
+		return fmt.Errorf("hashAlgorithm %q: must be one of %s", algorithm, strings.Join(HashAlgorithms, ", "))
+	}
+	if threshold != nil && (*threshold < 0 || *threshold > 64) {
+		return fmt.Errorf("hashThreshold must be between 0 and 64")
+	}
+	return nil
+}
+
+// Cookie is set in the tab before the story loads. Domain defaults to the
+// story's host, Path to "/".
+type Cookie struct {
+	Name     string `yaml:"name"`
+	Value    string `yaml:"value"`
+	Domain   string `yaml:"domain,omitempty"`
+	Path     string `yaml:"path,omitempty"`
+	Secure   bool   `yaml:"secure,omitempty"`
+	HTTPOnly bool   `yaml:"httpOnly,omitempty"`
+	// SameSite ist Strict, Lax oder None
+	SameSite string `yaml:"sameSite,omitempty"`
+}
+
+// mergeCookies replaces base cookies with story cookies of the same name and
+// domain and expands ${VAR} in the values.
+func mergeCookies(base, story []Cookie) ([]Cookie, error) {
+	var out []Cookie
+	for _, c := range append(slices.Clone(base), story...) {
+		if c.Name == "" {
+			return nil, fmt.Errorf("cookies: name must be specified")
+		}
+		switch c.SameSite {
+		case "", "Strict", "Lax", "None":
+		default:
+			return nil, fmt.Errorf("cookie %s: sameSite must be Strict, Lax or None", c.Name)
+		}
+		c.Value = os.ExpandEnv(c.Value)
+		out = slices.DeleteFunc(out, func(o Cookie) bool { return o.Name == c.Name && o.Domain == c.Domain })
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// expandHeaders merges story over base and expands ${VAR} in the values.
+func expandHeaders(base, story map[string]string) map[string]string {
+	canonical := func(m map[string]string) map[string]string {
+		out := make(map[string]string, len(m))
+		for k, v := range m {
+			out[http.CanonicalHeaderKey(k)] = v
+		}
+		return out
+	}
+	return expandValues(canonical(base), canonical(story))
+}
+
+// expandValues merges story over base and expands ${VAR} in the values.
+func expandValues(base, story map[string]string) map[string]string {
+	if len(base) == 0 && len(story) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(base)+len(story))
+	for _, m := range []map[string]string{base, story} {
+		for k, v := range m {
+			out[k] = os.ExpandEnv(v)
+		}
+	}
+	return out
+}
+
+// checkHosts rejects URLs and ports in blockHosts and allowHosts, they are
+// matched against host names only.
+func checkHosts(block, allow []string) error {
+	for _, l := range []struct {
+		key   string
+		hosts []string
+	}{{"blockHosts", block}, {"allowHosts", allow}} {
+		for _, h := range l.hosts {
+			if h == "" || strings.ContainsAny(h, "/: ") {
+				return fmt.Errorf("%s: %q is not a host name, expected e.g. example.com or *.example.com", l.key, h)
+			}
+		}
+	}
+	return nil
+}
+
+// Notify configures the run summary sent to a Slack-compatible webhook.
+type Notify struct {
+	Webhook string `yaml:"webhook" json:"webhook"`
+	// When ist "failure" (Default, nur bei Exit-Code != 0) oder "always"
+	When string `yaml:"when,omitempty" json:"when,omitempty"`
+	// ReportURL wird verlinkt, z.B. "${CI_JOB_URL}/artifacts/browse"
+	ReportURL string `yaml:"reportUrl,omitempty" json:"reportUrl,omitempty"`
 }
 
 type Action struct {
+	// At beschränkt die Action auf Sizes mit diesen Namen
 	At       *[]string `yaml:"@,omitempty" json:"@,omitempty"`
-	Action   string    `yaml:"action" json:"action"` // wait, click
-	Timeout  *int      `yaml:"timeout" json:"timeout"`
+	Action   string    `yaml:"action" json:"action"`   // wait, click
+	Timeout  *int      `yaml:"timeout" json:"timeout"` // ms
 	Selector *string   `yaml:"selector" json:"selector"`
 }
 
+func checkActions(actions []*Action) error {
+	for i, a := range actions {
+		hasSel := a.Selector != nil && *a.Selector != ""
+		switch {
+		case a.Action != "click" && a.Action != "wait":
+			return fmt.Errorf("action %d: unknown action %q, expected click or wait", i+1, a.Action)
+		case a.Action == "click" && !hasSel:
+			return fmt.Errorf("action %d: click needs a selector", i+1)
+		case a.Action == "wait" && !hasSel && a.Timeout == nil:
+			return fmt.Errorf("action %d: wait needs a selector or a timeout", i+1)
+		case a.Timeout != nil && *a.Timeout < 0:
+			return fmt.Errorf("action %d: timeout must be non-negative", i+1)
+		}
+	}
+	return nil
+}
+
 type OsnapConfig struct {
 	Name      string    `yaml:"name" json:"name"`
 	URL       string    `yaml:"url" json:"url"`
 	Sizes     Sizes     `yaml:"sizes" json:"sizes"`
 	Actions   []*Action `yaml:"actions" json:"actions"`
-	Threshold *int      `yaml:"threshold,omitempty" json:"threshold,omitempty"`
-	Retry     int       `yaml:"retry" json:"retry"`
+	Threshold *Percent  `yaml:"threshold,omitempty" json:"threshold,omitempty"`
+	// MaxDiffPixels überschreibt maxDiffPixels der Basis-Config; ein eigenes threshold schaltet es ab
+	MaxDiffPixels *int `yaml:"maxDiffPixels,omitempty" json:"maxDiffPixels,omitempty"`
+	// Retry überschreibt retry aus der Basis-Config (0 schaltet es ab)
+	Retry    *int     `yaml:"retry,omitempty" json:"retry,omitempty"`
+	Selector string   `yaml:"selector,omitempty" json:"selector,omitempty"`
+	Use      []string `yaml:"use,omitempty" json:"use,omitempty"`
+	Browser  string   `yaml:"browser,omitempty" json:"browser,omitempty"`
+	// FullScreen überschreibt fullScreen aus der Basis-Config
+	FullScreen *bool `yaml:"fullScreen,omitempty" json:"fullScreen,omitempty"`
+	// Stitch und FreezeFixed überschreiben die Werte aus der Basis-Config
+	Stitch      *bool          `yaml:"stitch,omitempty" json:"stitch,omitempty"`
+	FreezeFixed *bool          `yaml:"freezeFixed,omitempty" json:"freezeFixed,omitempty"`
+	Ignore      []IgnoreRegion `yaml:"ignore,omitempty" json:"ignore,omitempty"`
+	// Animations lässt CSS-Animationen und Transitions für diese Story aktiv
+	Animations bool `yaml:"animations,omitempty" json:"animations,omitempty"`
+	// ColorSchemes erzeugt pro Eintrag (light, dark) einen eigenen Case
+	ColorSchemes []string `yaml:"colorSchemes,omitempty" json:"colorSchemes,omitempty"`
+	ColorScheme  string   `yaml:"-" json:"colorScheme,omitempty"`
+	Device       string   `yaml:"-" json:"device,omitempty"`
+	// NetworkIdleMs überschreibt networkIdleMs aus der Basis-Config
+	NetworkIdleMs *int `yaml:"networkIdleMs,omitempty" json:"networkIdleMs,omitempty"`
+	SettleMs      *int `yaml:"settleMs,omitempty" json:"settleMs,omitempty"`
+	// Stabilize überschreibt stabilize aus der Basis-Config (0 schaltet es ab)
+	Stabilize           *int `yaml:"stabilize,omitempty" json:"stabilize,omitempty"`
+	StabilizeIntervalMs *int `yaml:"stabilizeIntervalMs,omitempty" json:"stabilizeIntervalMs,omitempty"`
+	// FailWhen überschreibt failWhen aus der Basis-Config; "false" schaltet die
+	// Regel ab, dann entscheidet wieder der Threshold
+	FailWhen string `yaml:"failWhen,omitempty" json:"failWhen,omitempty"`
+	// SSIM überschreibt ssim aus der Basis-Config (0 schaltet es ab)
+	SSIM *float64 `yaml:"ssim,omitempty" json:"ssim,omitempty"`
+	// HashAlgorithm und HashThreshold überschreiben die Werte aus der Basis-Config
+	HashAlgorithm string `yaml:"hashAlgorithm,omitempty" json:"hashAlgorithm,omitempty"`
+	HashThreshold *int   `yaml:"hashThreshold,omitempty" json:"hashThreshold,omitempty"`
+	// WaitSelectors und WaitFor überschreiben die Werte aus der Basis-Config
+	WaitSelectors []string `yaml:"waitSelectors,omitempty" json:"waitSelectors,omitempty"`
+	WaitFor       string   `yaml:"waitFor,omitempty" json:"waitFor,omitempty"`
+	WaitForPlay   *bool    `yaml:"waitForPlay,omitempty" json:"waitForPlay,omitempty"`
+	ReuseViewport *bool    `yaml:"reuseViewport,omitempty" json:"reuseViewport,omitempty"`
+	// Timezone, Locale und Geolocation überschreiben die Werte aus der Basis-Config
+	Timezone    string       `yaml:"timezone,omitempty" json:"timezone,omitempty"`
+	Locale      string       `yaml:"locale,omitempty" json:"locale,omitempty"`
+	Geolocation *Geolocation `yaml:"geolocation,omitempty" json:"geolocation,omitempty"`
+	// Headers ergänzen bzw. überschreiben die der Basis-Config
+	Headers   map[string]string `yaml:"headers,omitempty" json:"-"`
+	BasicAuth *BasicAuth        `yaml:"basicAuth,omitempty" json:"-"`
+	// BlockHosts und AllowHosts kommen zu denen der Basis-Config hinzu
+	BlockHosts []string `yaml:"blockHosts,omitempty" json:"blockHosts,omitempty"`
+	AllowHosts []string `yaml:"allowHosts,omitempty" json:"allowHosts,omitempty"`
+	// Mocks der Story werden vor denen der Basis-Config geprüft
+	Mocks []Mock `yaml:"mocks,omitempty" json:"mocks,omitempty"`
+	// Cookies und LocalStorage ergänzen bzw. überschreiben die der Basis-Config
+	Cookies      []Cookie          `yaml:"cookies,omitempty" json:"-"`
+	LocalStorage map[string]string `yaml:"localStorage,omitempty" json:"-"`
+	// Skip nimmt die Story aus dem Run, z.B. `skip: "flaky, see #123"`
+	Skip Skip `yaml:"skip,omitempty" json:"skip,omitempty"`
+	// Only: sobald eine Story es setzt, laufen nur noch diese Stories
+	Only bool `yaml:"only,omitempty" json:"only,omitempty"`
+	// Source ist die .osnap.yaml-Datei, aus der die Story stammt
+	Source string `yaml:"-" json:"-"`
+	// StoryFile ist Source relativ zum Input-Verzeichnis, mit Vorwärts-Slashes
+	StoryFile string `yaml:"-" json:"-"`
 	Width     int
 	Height    int
 }
@@ -127,40 +529,160 @@ func NewOsnapBaseConfig(baseConfigPath string) (*OsnapBaseConfig, error) {
 		return nil, err
 	}
 
-	config.SnapshotDirectory, err = tools.ExpandPath(config.SnapshotDirectory)
+	if config.SnapshotDirectory == "" {
+		return nil, fmt.Errorf("snapshotDirectory must be specified")
+	}
+
+	snapDir := config.SnapshotDirectory
+	if !config.SnapshotDirectoryFromCwd && !filepath.IsAbs(snapDir) && !strings.HasPrefix(snapDir, "~") {
+		snapDir = filepath.Join(filepath.Dir(path), snapDir)
+	}
+
+	config.SnapshotDirectory, err = tools.ExpandPath(snapDir)
 	if err != nil {
 		return nil, err
 	}
 
+	if config.OutputDir != "" {
+		out := config.OutputDir
+		if !filepath.IsAbs(out) && !strings.HasPrefix(out, "~") {
+			out = filepath.Join(filepath.Dir(path), out)
+		}
+		if config.OutputDir, err = tools.ExpandPath(out); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkHosts(config.BlockHosts, config.AllowHosts); err != nil {
+		return nil, err
+	}
+	if config.Mocks, err = resolveMocks(config.Mocks, filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	if a := config.BasicAuth; a != nil && a.Username == "" {
+		return nil, fmt.Errorf("basicAuth.username must be specified")
+	}
+	if n := config.Notify; n != nil {
+		if n.Webhook == "" {
+			return nil, fmt.Errorf("notify.webhook must be specified")
+		}
+		switch n.When {
+		case "":
+			n.When = "failure"
+		case "failure", "always":
+		default:
+			return nil, fmt.Errorf("notify.when must be failure or always")
+		}
+	}
+	if config.MainBranch == "" {
+		config.MainBranch = DefaultMainBranch
+	}
+	if config.FilenameTemplate != "" {
+		if err := CheckTemplate(config.FilenameTemplate); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.MaxImageDimension < 0 {
+		return nil, fmt.Errorf("maxImageDimension must be non-negative")
+	}
+	if config.MaxImageDimension == 0 {
+		config.MaxImageDimension = DefaultMaxImageDimension
+	}
+
 	if len(config.DefaultSizes) == 0 {
 		return nil, fmt.Errorf("at least one default size must be specified")
 	}
 
 	for i, s := range config.DefaultSizes {
+		s, err := s.resolve()
+		if err != nil {
+			return nil, fmt.Errorf("invalid default size at index %d: %w", i, err)
+		}
+		config.DefaultSizes[i] = s
 		if s.Width <= 0 || s.Height <= 0 {
 			return nil, fmt.Errorf("invalid default size at index %d: width and height must be positive integers", i)
 		}
+		if s.Width > config.MaxImageDimension || s.Height > config.MaxImageDimension {
+			return nil, fmt.Errorf("invalid default size at index %d: width and height must not exceed maxImageDimension (%d)", i, config.MaxImageDimension)
+		}
 	}
 
-	if config.Threshold < 0 || config.Threshold > 100 {
-		return nil, fmt.Errorf("threshold must be between 0 and 100")
+	if err := checkThreshold(&config.Threshold, config.MaxDiffPixels); err != nil {
+		return nil, err
 	}
 
 	if config.Retry < 0 {
 		return nil, fmt.Errorf("retry must be non-negative")
 	}
 
+	if config.NetworkIdleMs < 0 {
+		return nil, fmt.Errorf("networkIdleMs must be non-negative")
+	}
+
+	if g := config.ScrollbarGutter; g != nil && *g < 0 {
+		return nil, fmt.Errorf("scrollbarGutter must be non-negative")
+	}
+
+	if config.SettleMs < 0 {
+		return nil, fmt.Errorf("settleMs must be non-negative")
+	}
+	if err := config.Geolocation.check(); err != nil {
+		return nil, err
+	}
+
+	if config.Stabilize < 0 || config.StabilizeIntervalMs < 0 {
+		return nil, fmt.Errorf("stabilize and stabilizeIntervalMs must be non-negative")
+	}
+	if config.StabilizeIntervalMs == 0 {
+		config.StabilizeIntervalMs = DefaultStabilizeIntervalMs
+	}
+
+	if config.FailWhen != "" {
+		if _, err := rule.Compile(config.FailWhen); err != nil {
+			return nil, err
+		}
+	}
+	if config.SSIM < 0 || config.SSIM > 1 {
+		return nil, fmt.Errorf("ssim must be between 0 and 1")
+	}
+	if config.HashAlgorithm == "" {
+		config.HashAlgorithm = "phash"
+	}
+	if err := checkHash(config.HashAlgorithm, config.HashThreshold); err != nil {
+		return nil, err
+	}
+	if config.HashThreshold == nil {
+		t := DefaultHashThreshold
+		config.HashThreshold = &t
+	}
+
+	if len(config.WaitSelectors) == 0 {
+		config.WaitSelectors = DefaultWaitSelectors
+	}
+	if config.WaitFor == "" {
+		config.WaitFor = "ready"
+	}
+	if config.WaitFor != "ready" && config.WaitFor != "visible" {
+		return nil, fmt.Errorf("waitFor must be ready or visible")
+	}
+
 	if config.TestPattern == "" {
 		return nil, fmt.Errorf("testPattern must be specified")
 	}
+	if _, err := newTestMatcher(config.TestPattern); err != nil {
+		return nil, err
+	}
 
-	if config.SnapshotDirectory == "" {
-		return nil, fmt.Errorf("snapshotDirectory must be specified")
+	if config.Browser == "" {
+		config.Browser = DefaultBrowser
+	}
+	if !slices.Contains(Browsers, config.Browser) {
+		return nil, fmt.Errorf("browser must be one of %s", strings.Join(Browsers, ", "))
 	}
 
-	if config.DiffPixelColor.R < 0 || config.DiffPixelColor.R > 255 ||
-		config.DiffPixelColor.G < 0 || config.DiffPixelColor.G > 255 ||
-		config.DiffPixelColor.B < 0 || config.DiffPixelColor.B > 255 {
+	if c := config.DiffPixelColor; c != nil && (c.R < 0 || c.R > 255 ||
+		c.G < 0 || c.G > 255 ||
+		c.B < 0 || c.B > 255) {
 		return nil, fmt.Errorf("diffPixelColor values must be between 0 and 255")
 	}
 
@@ -197,60 +719,235 @@ func (cfg *OsnapBaseConfig) NewOsnapConfig(configPath string) ([]*OsnapConfig, e
 	var res []*OsnapConfig
 
 	for _, c := range configs {
+		c.Source = configPath
+		if err := cfg.applyFragments(c); err != nil {
+			return nil, err
+		}
+		if err := checkActions(c.Actions); err != nil {
+			return nil, fmt.Errorf("story %q: %w", c.Name, err)
+		}
+		cfg.applyDirConfigs(c)
+
+		if c.Browser == "" {
+			c.Browser = cfg.Browser
+		}
+		if c.FullScreen == nil {
+			c.FullScreen = &cfg.FullScreen
+		}
+		if c.Stitch == nil {
+			c.Stitch = &cfg.Stitch
+		}
+		if c.FreezeFixed == nil {
+			c.FreezeFixed = &cfg.FreezeFixed
+		}
+		if c.NetworkIdleMs == nil {
+			c.NetworkIdleMs = &cfg.NetworkIdleMs
+		} else if *c.NetworkIdleMs < 0 {
+			return nil, fmt.Errorf("story %q: networkIdleMs must be non-negative", c.Name)
+		}
+		if c.Retry == nil {
+			c.Retry = &cfg.Retry
+		} else if *c.Retry < 0 {
+			return nil, fmt.Errorf("story %q: retry must be non-negative", c.Name)
+		}
+		if c.Stabilize == nil {
+			c.Stabilize = &cfg.Stabilize
+		} else if *c.Stabilize < 0 {
+			return nil, fmt.Errorf("story %q: stabilize must be non-negative", c.Name)
+		}
+		if c.StabilizeIntervalMs == nil {
+			c.StabilizeIntervalMs = &cfg.StabilizeIntervalMs
+		} else if *c.StabilizeIntervalMs < 0 {
+			return nil, fmt.Errorf("story %q: stabilizeIntervalMs must be non-negative", c.Name)
+		}
+		if c.SettleMs == nil {
+			c.SettleMs = &cfg.SettleMs
+		} else if *c.SettleMs < 0 {
+			return nil, fmt.Errorf("story %q: settleMs must be non-negative", c.Name)
+		}
+		switch {
+		case c.FailWhen == "":
+			c.FailWhen = cfg.FailWhen
+		case strings.TrimSpace(c.FailWhen) == "false":
+			// Regel aus, es gilt wieder der Threshold
+			c.FailWhen = ""
+		default:
+			if _, err := rule.Compile(c.FailWhen); err != nil {
+				return nil, fmt.Errorf("story %q: %w", c.Name, err)
+			}
+		}
+		if c.SSIM == nil {
+			c.SSIM = &cfg.SSIM
+		} else if *c.SSIM < 0 || *c.SSIM > 1 {
+			return nil, fmt.Errorf("story %q: ssim must be between 0 and 1", c.Name)
+		}
+		if err := checkThreshold(c.Threshold, c.MaxDiffPixels); err != nil {
+			return nil, fmt.Errorf("story %q: %w", c.Name, err)
+		}
+		// ein eigener Prozentwert der Story schlägt die Pixelzahl der Basis
+		if c.MaxDiffPixels == nil && c.Threshold == nil {
+			c.MaxDiffPixels = cfg.MaxDiffPixels
+		}
+		if c.HashAlgorithm == "" {
+			c.HashAlgorithm = cfg.HashAlgorithm
+		}
+		if err := checkHash(c.HashAlgorithm, c.HashThreshold); err != nil {
+			return nil, fmt.Errorf("story %q: %w", c.Name, err)
+		}
+		if c.HashThreshold == nil {
+			c.HashThreshold = cfg.HashThreshold
+		}
+		if len(c.WaitSelectors) == 0 {
+			c.WaitSelectors = cfg.WaitSelectors
+		}
+		if c.WaitForPlay == nil {
+			c.WaitForPlay = &cfg.WaitForPlay
+		}
+		if c.ReuseViewport == nil {
+			c.ReuseViewport = &cfg.ReuseViewport
+		}
+		c.Headers = expandHeaders(cfg.Headers, c.Headers)
+		c.LocalStorage = expandValues(cfg.LocalStorage, c.LocalStorage)
+		if c.Cookies, err = mergeCookies(cfg.Cookies, c.Cookies); err != nil {
+			return nil, fmt.Errorf("story %q: %w", c.Name, err)
+		}
+		if c.Timezone == "" {
+			c.Timezone = cfg.Timezone
+		}
+		if c.Locale == "" {
+			c.Locale = cfg.Locale
+		}
+		if c.Geolocation == nil {
+			c.Geolocation = cfg.Geolocation
+		} else if err := c.Geolocation.check(); err != nil {
+			return nil, fmt.Errorf("story %q: %w", c.Name, err)
+		}
+		if c.BasicAuth == nil {
+			c.BasicAuth = cfg.BasicAuth
+		}
+		if a := c.BasicAuth; a != nil {
+			c.BasicAuth = &BasicAuth{Username: os.ExpandEnv(a.Username), Password: os.ExpandEnv(a.Password)}
+		}
+		if err := checkHosts(c.BlockHosts, c.AllowHosts); err != nil {
+			return nil, fmt.Errorf("story %q: %w", c.Name, err)
+		}
+		mocks, err := resolveMocks(c.Mocks, filepath.Dir(configPath))
+		if err != nil {
+			return nil, fmt.Errorf("story %q: %w", c.Name, err)
+		}
+		c.Mocks = append(mocks, cfg.Mocks...)
+		c.BlockHosts = append(slices.Clone(cfg.BlockHosts), c.BlockHosts...)
+		c.AllowHosts = append(slices.Clone(cfg.AllowHosts), c.AllowHosts...)
+		if c.WaitFor == "" {
+			c.WaitFor = cfg.WaitFor
+		} else if c.WaitFor != "ready" && c.WaitFor != "visible" {
+			return nil, fmt.Errorf("story %q: waitFor must be ready or visible", c.Name)
+		}
+		if !slices.Contains(Browsers, c.Browser) {
+			return nil, fmt.Errorf("story %q: browser must be one of %s", c.Name, strings.Join(Browsers, ", "))
+		}
+		for _, cs := range c.ColorSchemes {
+			if cs != "light" && cs != "dark" {
+				return nil, fmt.Errorf("story %q: colorSchemes entries must be light or dark, got %q", c.Name, cs)
+			}
+		}
+
 		if ss := c.Sizes.AsStrings(); len(ss) > 0 {
 			for _, s := range ss {
 				for _, ds := range cfg.DefaultSizes {
 					if s == ds.Name {
-						newC := *c
-						newC.Width = ds.Width
-						newC.Height = ds.Height
-
-						res = append(res, &newC)
+						res = append(res, c.withSize(ds))
 						break
 					}
 				}
 			}
 		} else if sz := c.Sizes.AsSizes(); len(sz) > 0 {
 			for _, s := range sz {
-				newC := *c
-				newC.Width = s.Width
-				newC.Height = s.Height
-
-				res = append(res, &newC)
+				s, err := s.resolve()
+				if err != nil {
+					return nil, fmt.Errorf("story %q: %w", c.Name, err)
+				}
+				if s.Width > cfg.MaxImageDimension || s.Height > cfg.MaxImageDimension {
+					return nil, fmt.Errorf("story %q: size %dx%d exceeds maxImageDimension (%d)", c.Name, s.Width, s.Height, cfg.MaxImageDimension)
+				}
+				res = append(res, c.withSize(s))
 			}
-		} else if c.Sizes.One != nil {
-			s := c.Sizes.One
-			newC := *c
-			newC.Width = s.Width
-			newC.Height = s.Height
-
-			res = append(res, &newC)
 		} else {
 			for _, s := range cfg.DefaultSizes {
-				newC := *c
-				newC.Width = s.Width
-				newC.Height = s.Height
-
-				res = append(res, &newC)
+				res = append(res, c.withSize(s))
 			}
 		}
 	}
 
-	return res, nil
+	return expandColorSchemes(res), nil
 }
 
-func (cfg *OsnapBaseConfig) FindAndParseConfigs(root string) ([]*OsnapConfig, error) {
-	var results []*OsnapConfig
-	var aggErr error
+func (c *OsnapConfig) withSize(s Size) *OsnapConfig {
+	newC := *c
+	newC.Width = s.Width
+	newC.Height = s.Height
+	newC.Device = s.Device
+	newC.Actions = nil
+	for _, a := range c.Actions {
+		if a.At == nil || slices.Contains(*a.At, s.Name) {
+			newC.Actions = append(newC.Actions, a)
+		}
+	}
+	return &newC
+}
 
-	path, err := tools.ExpandPath(root)
+func expandColorSchemes(configs []*OsnapConfig) []*OsnapConfig {
+	var res []*OsnapConfig
+	for _, c := range configs {
+		if len(c.ColorSchemes) == 0 {
+			res = append(res, c)
+			continue
+		}
+		for _, cs := range c.ColorSchemes {
+			newC := *c
+			newC.ColorScheme = cs
+			res = append(res, &newC)
+		}
+	}
+	return res
+}
+
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e FileError) Error() string { return fmt.Sprintf("%s: %v", e.Path, e.Err) }
+
+func (e FileError) Unwrap() error { return e.Err }
+
+// DiscoveryResult holds all stories that could be parsed plus one entry per
+// file or directory that had to be skipped.
+type DiscoveryResult struct {
+	Configs []*OsnapConfig
+	Errors  []FileError
+}
+
+func (cfg *OsnapBaseConfig) FindAndParseConfigs(root string) (*DiscoveryResult, error) {
+	res := &DiscoveryResult{}
+	var storyFiles, sharedFiles, dirFiles []string
+
+	rootPath, err := tools.ExpandPath(root)
 	if err != nil {
 		return nil, err
 	}
 
-	err = filepath.WalkDir(path, func(path string, d os.DirEntry, wErr error) error {
+	matcher, err := newTestMatcher(cfg.TestPattern)
+	if err != nil {
+		return nil, err
+	}
+
+	err = filepath.WalkDir(rootPath, func(path string, d os.DirEntry, wErr error) error {
 		if wErr != nil {
-			aggErr = errors.Join(aggErr, fmt.Errorf("error accessing path %q: %v", path, wErr))
+			res.Errors = append(res.Errors, FileError{Path: path, Err: wErr})
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
@@ -268,23 +965,62 @@ func (cfg *OsnapBaseConfig) FindAndParseConfigs(root string) ([]*OsnapConfig, er
 			return nil
 		}
 
-		if !strings.HasSuffix(d.Name(), ".osnap.yaml") {
+		if d.Name() == SharedFileName {
+			sharedFiles = append(sharedFiles, path)
 			return nil
 		}
-
-		configs, err := cfg.NewOsnapConfig(path)
-		if err != nil {
-			aggErr = errors.Join(aggErr, fmt.Errorf("error parsing config %q: %v", path, err))
+		if d.Name() == DirConfigFileName {
+			dirFiles = append(dirFiles, path)
 			return nil
 		}
 
-		for i := range configs {
-			results = append(results, configs[i])
+		rel, err := filepath.Rel(rootPath, path)
+		if err != nil || !matcher.Match(filepath.ToSlash(rel)) {
+			return nil
 		}
 
+		storyFiles = append(storyFiles, path)
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Fragmente zuerst laden, damit jede Story-Datei sie unabhängig von der Reihenfolge findet
+	cfg.fragments = map[string]*Fragment{}
+	for _, p := range sharedFiles {
+		if err := loadFragments(p, cfg.fragments); err != nil {
+			res.Errors = append(res.Errors, FileError{Path: p, Err: err})
+		}
+	}
 
-	aggErr = errors.Join(aggErr, err)
-	return results, aggErr
+	cfg.dirConfigs = map[string]*DirConfig{}
+	for _, p := range dirFiles {
+		dc, err := loadDirConfig(p)
+		if err != nil {
+			res.Errors = append(res.Errors, FileError{Path: p, Err: err})
+			continue
+		}
+		cfg.dirConfigs[filepath.Dir(p)] = dc
+	}
+
+	for _, p := range storyFiles {
+		configs, err := cfg.NewOsnapConfig(p)
+		if err != nil {
+			res.Errors = append(res.Errors, FileError{Path: p, Err: err})
+			continue
+		}
+
+		if rel, err := filepath.Rel(rootPath, p); err == nil {
+			for _, c := range configs {
+				c.StoryFile = filepath.ToSlash(rel)
+			}
+		}
+		res.Configs = append(res.Configs, configs...)
+	}
+	if err := cfg.Layout(root).checkCollisions(res.Configs); err != nil {
+		return nil, err
+	}
+
+	return res, nil
 }
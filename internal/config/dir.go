@@ -0,0 +1,72 @@
+package config
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/maxischmaxi/qsnap/internal/tools"
+	"gopkg.in/yaml.v3"
+)
+
+const DirConfigFileName = "osnap.dir.yaml"
+
+// DirConfig overrides settings for all stories in its directory and below.
+// The nearest file wins for threshold and sizes; ignore regions of all
+// levels are added up. Settings in the story itself always win.
+type DirConfig struct {
+	Threshold *Percent       `yaml:"threshold,omitempty" json:"threshold,omitempty"`
+	Sizes     Sizes          `yaml:"sizes,omitempty" json:"sizes,omitempty"`
+	Ignore    []IgnoreRegion `yaml:"ignore,omitempty" json:"ignore,omitempty"`
+}
+
+func loadDirConfig(path string) (*DirConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+
+	dc := &DirConfig{}
+	if err := dec.Decode(dc); err != nil {
+		if errors.Is(err, io.EOF) {
+			return dc, nil
+		}
+		return nil, err
+	}
+	if err := tools.EnsureEOF(dec); err != nil {
+		return nil, err
+	}
+	if err := checkThreshold(dc.Threshold, nil); err != nil {
+		return nil, err
+	}
+	return dc, nil
+}
+
+// applyDirConfigs merges the osnap.dir.yaml files above c.Source into c.
+func (cfg *OsnapBaseConfig) applyDirConfigs(c *OsnapConfig) {
+	if len(cfg.dirConfigs) == 0 || c.Source == "" {
+		return
+	}
+	dir := filepath.Dir(c.Source)
+	for {
+		if dc, ok := cfg.dirConfigs[dir]; ok {
+			if c.Threshold == nil && dc.Threshold != nil {
+				c.Threshold = dc.Threshold
+			}
+			if c.Sizes.empty() {
+				c.Sizes = dc.Sizes
+			}
+			c.Ignore = append(c.Ignore, dc.Ignore...)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return
+		}
+		dir = parent
+	}
+}
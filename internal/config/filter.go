@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Filter keeps the stories whose name or URL matches pattern. The pattern is a
+// glob (path.Match syntax) unless it starts with "re:", then it is a regular
+// expression. An empty pattern keeps everything.
+func Filter(configs []*OsnapConfig, pattern string) ([]*OsnapConfig, error) {
+	if pattern == "" {
+		return configs, nil
+	}
+
+	var match func(string) bool
+	if expr, ok := strings.CutPrefix(pattern, "re:"); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter %q: %w", pattern, err)
+		}
+		match = re.MatchString
+	} else {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid filter %q: %w", pattern, err)
+		}
+		match = func(s string) bool {
+			ok, _ := path.Match(pattern, s)
+			return ok
+		}
+	}
+
+	var out []*OsnapConfig
+	for _, c := range configs {
+		if match(c.Name) || match(c.URL) {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Mock answers the tab's requests to matching URLs with a fixture instead of
+// the network, so stories that fetch live APIs render the same data in every
+// run.
+type Mock struct {
+	// URL ist ein Glob über die ganze URL, * passt auf beliebige Zeichen,
+	// z.B. "*/api/users*"
+	URL    string `yaml:"url" json:"url"`
+	Method string `yaml:"method,omitempty" json:"method,omitempty"`
+	// Status ist der HTTP-Status, Default 200
+	Status  int               `yaml:"status,omitempty" json:"status,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+	// Body ist ein String oder ein YAML-Objekt, das als JSON geschickt wird
+	Body any `yaml:"body,omitempty" json:"body,omitempty"`
+	// File ist eine Fixture-Datei relativ zur Config, in der der Mock steht
+	File string `yaml:"file,omitempty" json:"file,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// Match reports whether the mock answers a request.
+func (m *Mock) Match(method, url string) bool {
+	if m.Method != "" && !strings.EqualFold(m.Method, method) {
+		return false
+	}
+	return m.MatchURL(url)
+}
+
+// MatchURL matches url against the glob, ignoring the method.
+func (m *Mock) MatchURL(url string) bool {
+	return m.re != nil && m.re.MatchString(url)
+}
+
+// Response returns the body and headers to answer with. File is read on
+// every call, so fixtures can be edited during watch mode.
+func (m *Mock) Response() (int, map[string]string, []byte, error) {
+	var body []byte
+	contentType := ""
+	switch b := m.Body.(type) {
+	case nil:
+		if m.File != "" {
+			var err error
+			if body, err = os.ReadFile(m.File); err != nil {
+				return 0, nil, nil, fmt.Errorf("mock %s: %w", m.URL, err)
+			}
+			contentType = mime.TypeByExtension(filepath.Ext(m.File))
+		}
+	case string:
+		body = []byte(b)
+	default:
+		var err error
+		if body, err = json.Marshal(b); err != nil {
+			return 0, nil, nil, fmt.Errorf("mock %s: body: %w", m.URL, err)
+		}
+		contentType = "application/json"
+	}
+
+	headers := map[string]string{}
+	if contentType != "" {
+		headers["Content-Type"] = contentType
+	}
+	for k, v := range m.Headers {
+		headers[http.CanonicalHeaderKey(k)] = v
+	}
+	status := m.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return status, headers, body, nil
+}
+
+// resolveMocks validates mocks, compiles their URL globs and resolves File
+// relative to dir.
+func resolveMocks(mocks []Mock, dir string) ([]Mock, error) {
+	out := make([]Mock, 0, len(mocks))
+	for _, m := range mocks {
+		if m.URL == "" {
+			return nil, fmt.Errorf("mocks: url must be specified")
+		}
+		if m.Body != nil && m.File != "" {
+			return nil, fmt.Errorf("mock %s: set either body or file", m.URL)
+		}
+		if m.Status != 0 && (m.Status < 100 || m.Status > 599) {
+			return nil, fmt.Errorf("mock %s: invalid status %d", m.URL, m.Status)
+		}
+		if m.File != "" {
+			if !filepath.IsAbs(m.File) {
+				m.File = filepath.Join(dir, m.File)
+			}
+			if _, err := os.Stat(m.File); err != nil {
+				return nil, fmt.Errorf("mock %s: %w", m.URL, err)
+			}
+		}
+		m.re = globURL(m.URL)
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+func globURL(pattern string) *regexp.Regexp {
+	parts := strings.Split(pattern, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
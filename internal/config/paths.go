@@ -0,0 +1,196 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/maxischmaxi/qsnap/internal/tools"
+)
+
+// CaseFilename namespaces non-default engines in a subdirectory so chrome
+// baselines keep their existing location.
+func CaseFilename(s *OsnapConfig) string {
+	name := s.Name
+	if s.ColorScheme != "" {
+		name += "_" + s.ColorScheme
+	}
+	if s.Device != "" {
+		name += "_" + deviceSlug(s.Device)
+	}
+	filename := fmt.Sprintf("%s_%dx%d.png", name, s.Width, s.Height)
+	if s.Browser != "" && s.Browser != DefaultBrowser {
+		filename = filepath.Join(s.Browser, filename)
+	}
+	return filename
+}
+
+// deviceSlug macht aus "iPhone 14 landscape" "iPhone-14-landscape"
+func deviceSlug(name string) string {
+	return strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), "-")
+}
+
+// SnapshotsDir is the default snapshot root: __image-snapshots__ next to
+// the Storybook directory baseDir.
+func SnapshotsDir(baseDir string) string {
+	return filepath.Join(baseDir, "..", "__image-snapshots__")
+}
+
+// Layout decides where the images of a case are stored: below Root in
+// __base_images__, __diff__ and __actual__, named by Template.
+type Layout struct {
+	Root string
+	// Template ist z.B. "{name}/{size}/{scheme}.png"; leer = CaseFilename
+	Template string
+	// Branch ist der Git-Branch mit eigenen Baselines, leer = nur die geteilten
+	Branch string
+}
+
+// Layout returns the layout of cfg for the Storybook directory baseDir.
+func (cfg *OsnapBaseConfig) Layout(baseDir string) Layout {
+	l := Layout{Root: cfg.OutputDir, Template: cfg.FilenameTemplate}
+	if l.Root == "" {
+		l.Root = SnapshotsDir(baseDir)
+	}
+	return l
+}
+
+// TemplateFields are the placeholders of filenameTemplate.
+var TemplateFields = []string{"name", "width", "height", "size", "scheme", "device", "browser"}
+
+var templateField = regexp.MustCompile(`\{([a-z]+)\}`)
+
+// CheckTemplate validates a filenameTemplate: known placeholders, {name}
+// somewhere, and a relative path that stays below the snapshot root.
+func CheckTemplate(tmpl string) error {
+	for _, m := range templateField.FindAllStringSubmatch(tmpl, -1) {
+		if !slices.Contains(TemplateFields, m[1]) {
+			return fmt.Errorf("filenameTemplate: unknown placeholder {%s}, expected one of %s", m[1], strings.Join(TemplateFields, ", "))
+		}
+	}
+	if !strings.Contains(tmpl, "{name}") {
+		return fmt.Errorf("filenameTemplate must contain {name}")
+	}
+	if filepath.IsAbs(tmpl) || slices.Contains(strings.Split(filepath.ToSlash(tmpl), "/"), "..") {
+		return fmt.Errorf("filenameTemplate must be a relative path without ..")
+	}
+	return nil
+}
+
+// checkCollisions fails if two cases get the same Filename and would
+// overwrite each other's baselines, e.g. with a filenameTemplate that leaves
+// out {device} or {browser}.
+func (l Layout) checkCollisions(configs []*OsnapConfig) error {
+	seen := map[string]*OsnapConfig{}
+	for _, s := range configs {
+		name := l.Filename(s)
+		prev, ok := seen[name]
+		if !ok {
+			seen[name] = s
+			continue
+		}
+		return fmt.Errorf("%s and %s both map to the baseline %s, add the placeholders that tell them apart to filenameTemplate or rename a story",
+			caseDesc(prev), caseDesc(s), filepath.ToSlash(name))
+	}
+	return nil
+}
+
+func caseDesc(s *OsnapConfig) string {
+	var parts []string
+	for _, p := range []string{s.Browser, s.ColorScheme, s.Device, fmt.Sprintf("%dx%d", s.Width, s.Height)} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return fmt.Sprintf("%q (%s, %s)", s.Name, strings.Join(parts, " "), s.StoryFile)
+}
+
+// Filename is the path of the case below each image directory. Empty
+// scheme and device render as "default".
+func (l Layout) Filename(s *OsnapConfig) string {
+	if l.Template == "" {
+		return CaseFilename(s)
+	}
+	or := func(v string) string {
+		if v == "" {
+			return "default"
+		}
+		return v
+	}
+	values := map[string]string{
+		"name":    s.Name,
+		"width":   fmt.Sprint(s.Width),
+		"height":  fmt.Sprint(s.Height),
+		"size":    fmt.Sprintf("%dx%d", s.Width, s.Height),
+		"scheme":  or(s.ColorScheme),
+		"device":  or(deviceSlug(s.Device)),
+		"browser": or(s.Browser),
+	}
+	name := templateField.ReplaceAllStringFunc(l.Template, func(m string) string {
+		return values[m[1:len(m)-1]]
+	})
+	if filepath.Ext(name) != ".png" {
+		name += ".png"
+	}
+	return filepath.FromSlash(name)
+}
+
+// ActualPath is where the capture of a failed or new case is kept.
+func (l Layout) ActualPath(s *OsnapConfig) string {
+	return filepath.Join(l.Root, "__actual__", l.Filename(s))
+}
+
+// DebugDir is where the debug bundle of an errored case is written.
+func (l Layout) DebugDir(s *OsnapConfig) string {
+	return filepath.Join(l.Root, "__debug__", strings.TrimSuffix(l.Filename(s), ".png"))
+}
+
+// CasePaths returns the diff image and baseline paths of a case.
+func (l Layout) CasePaths(s *OsnapConfig) (diffPath, baselinePath string) {
+	filename := l.Filename(s)
+	diffPath = filepath.Join(l.Root, "__diff__", filename)
+	baselinePath = filepath.Join(l.Root, "__base_images__", filename)
+	return diffPath, baselinePath
+}
+
+// BranchBaseline is where the baseline of a case is kept for Layout.Branch,
+// or "" without a branch. Branch baselines live outside __base_images__ so
+// qsnap clean leaves them alone.
+func (l Layout) BranchBaseline(s *OsnapConfig) string {
+	if l.Branch == "" {
+		return ""
+	}
+	return filepath.Join(l.Root, "__branches__", BranchSlug(l.Branch), l.Filename(s))
+}
+
+// Baseline is the baseline a case is compared against: the branch baseline
+// if it exists, otherwise the shared one.
+func (l Layout) Baseline(s *OsnapConfig) string {
+	if p := l.BranchBaseline(s); p != "" && tools.FileExists(p) {
+		return p
+	}
+	_, baseline := l.CasePaths(s)
+	return baseline
+}
+
+// RemoteName is the name of the case's baseline in remote storage that new
+// baselines are written to; with a branch it is below branches/<branch>/.
+func (l Layout) RemoteName(s *OsnapConfig) string {
+	name := filepath.ToSlash(l.Filename(s))
+	if l.Branch == "" {
+		return name
+	}
+	return "branches/" + BranchSlug(l.Branch) + "/" + name
+}
+
+var branchUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// BranchSlug macht aus "feature/Login Form" "feature-Login-Form"
+func BranchSlug(branch string) string {
+	return strings.Trim(branchUnsafe.ReplaceAllString(branch, "-"), "-.")
+}
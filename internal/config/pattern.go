@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// testMatcher matches story files against testPattern. Supported are
+// path.Match globs per segment, "**" for any number of directories and
+// {a,b} alternatives. A pattern without "/" matches the file name anywhere.
+type testMatcher struct {
+	patterns [][]string
+	baseOnly bool
+}
+
+func newTestMatcher(pattern string) (*testMatcher, error) {
+	pattern = strings.TrimPrefix(path.Clean(strings.ReplaceAll(pattern, "\\", "/")), "./")
+	m := &testMatcher{baseOnly: !strings.Contains(pattern, "/")}
+
+	for _, p := range expandBraces(pattern) {
+		segs := strings.Split(p, "/")
+		for _, s := range segs {
+			if _, err := path.Match(s, ""); err != nil {
+				return nil, fmt.Errorf("invalid testPattern %q: %w", pattern, err)
+			}
+		}
+		m.patterns = append(m.patterns, segs)
+	}
+	return m, nil
+}
+
+// Match reports whether rel (slash-separated, relative to the input root) matches.
+func (m *testMatcher) Match(rel string) bool {
+	if m.baseOnly {
+		rel = path.Base(rel)
+	}
+	segs := strings.Split(rel, "/")
+	for _, p := range m.patterns {
+		if matchSegments(p, segs) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pattern, segs []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// ** passt auf null oder mehr Verzeichnisse
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(pattern[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segs[0]); !ok {
+			return false
+		}
+		pattern, segs = pattern[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+// expandBraces turns "a.{yaml,json}" into ["a.yaml", "a.json"]. Nested braces
+// are expanded from the outside in.
+func expandBraces(p string) []string {
+	start := strings.IndexByte(p, '{')
+	if start < 0 {
+		return []string{p}
+	}
+	depth, end := 0, -1
+	var alts []string
+	last := start + 1
+	for i := start; i < len(p) && end < 0; i++ {
+		switch p[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				alts = append(alts, p[last:i])
+				end = i
+			}
+		case ',':
+			if depth == 1 {
+				alts = append(alts, p[last:i])
+				last = i + 1
+			}
+		}
+	}
+	if end < 0 {
+		return []string{p}
+	}
+
+	var out []string
+	for _, a := range alts {
+		for _, rest := range expandBraces(p[end+1:]) {
+			out = append(out, expandBraces(p[:start]+a+rest)...)
+		}
+	}
+	return out
+}
+
+// IsTestFile reports whether path (below root) is a story config according to testPattern.
+func (cfg *OsnapBaseConfig) IsTestFile(root, file string) bool {
+	m, err := newTestMatcher(cfg.TestPattern)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, file)
+	if err != nil {
+		return false
+	}
+	return m.Match(filepath.ToSlash(rel))
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Percent is a share in percent, written as a number (`0.15`) or with a
+// percent sign (`0.15%`).
+type Percent float64
+
+func (p *Percent) UnmarshalYAML(unmarshal func(any) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return fmt.Errorf("expected a percentage like 0.15 or 0.15%%")
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "%")), 64)
+	if err != nil {
+		return fmt.Errorf("%q is not a percentage like 0.15 or 0.15%%", s)
+	}
+	*p = Percent(v)
+	return nil
+}
+
+// Fraction returns p as a share between 0 and 1.
+func (p Percent) Fraction() float64 { return float64(p) / 100 }
+
+// checkThreshold: threshold ist ein Prozentwert, maxDiffPixels eine Anzahl
+func checkThreshold(threshold *Percent, maxDiffPixels *int) error {
+	if threshold != nil && (*threshold < 0 || *threshold > 100) {
+		return fmt.Errorf("threshold must be between 0 and 100 (percent)")
+	}
+	if maxDiffPixels != nil && *maxDiffPixels < 0 {
+		return fmt.Errorf("maxDiffPixels must be non-negative")
+	}
+	return nil
+}
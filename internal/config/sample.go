@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+)
+
+// Sample picks a random subset of stories, either a percentage ("10%") or a
+// fixed number ("25").
+type Sample struct {
+	Percent float64
+	Count   int
+}
+
+func ParseSample(s string) (*Sample, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil || v <= 0 || v > 100 {
+			return nil, fmt.Errorf("invalid sample %q: percentage must be in (0, 100]", s)
+		}
+		return &Sample{Percent: v}, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid sample %q: expected a percentage like 10%% or a positive count", s)
+	}
+	return &Sample{Count: n}, nil
+}
+
+// Apply keeps all cases (sizes, color schemes, ...) of a random subset of
+// stories. The same seed and story list always give the same subset, so CI
+// shards using one seed agree on it. Discovery order is kept.
+func (sa *Sample) Apply(configs []*OsnapConfig, seed uint64) []*OsnapConfig {
+	if sa == nil {
+		return configs
+	}
+
+	var stories []string
+	seen := map[string]bool{}
+	for _, c := range configs {
+		key := storyKey(c)
+		if !seen[key] {
+			seen[key] = true
+			stories = append(stories, key)
+		}
+	}
+
+	n := sa.Count
+	if sa.Percent > 0 {
+		n = int(math.Ceil(float64(len(stories)) * sa.Percent / 100))
+	}
+	if n >= len(stories) {
+		return configs
+	}
+
+	r := rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15))
+	r.Shuffle(len(stories), func(i, j int) { stories[i], stories[j] = stories[j], stories[i] })
+	picked := map[string]bool{}
+	for _, k := range stories[:n] {
+		picked[k] = true
+	}
+
+	var out []*OsnapConfig
+	for _, c := range configs {
+		if picked[storyKey(c)] {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func storyKey(c *OsnapConfig) string {
+	return c.Source + "\x00" + c.Name
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// Shard is the 1-based index of this machine out of Total.
+type Shard struct {
+	Index int
+	Total int
+}
+
+// ParseShard parses "2/5". An empty string means no sharding.
+func ParseShard(s string) (*Shard, error) {
+	if s == "" {
+		return nil, nil
+	}
+	i, t, ok := strings.Cut(s, "/")
+	index, err1 := strconv.Atoi(i)
+	total, err2 := strconv.Atoi(t)
+	if !ok || err1 != nil || err2 != nil || total < 1 || index < 1 || index > total {
+		return nil, fmt.Errorf("invalid shard %q: expected <index>/<total> with 1 <= index <= total", s)
+	}
+	return &Shard{Index: index, Total: total}, nil
+}
+
+// Apply keeps the cases that belong to this shard. The assignment hashes the
+// case identity, so it does not depend on discovery order or on which other
+// stories exist.
+func (sh *Shard) Apply(configs []*OsnapConfig) []*OsnapConfig {
+	if sh == nil || sh.Total == 1 {
+		return configs
+	}
+	var out []*OsnapConfig
+	for _, c := range configs {
+		h := fnv.New32a()
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00%dx%d", c.Name, c.Browser, c.ColorScheme, c.Width, c.Height)
+		if c.Device != "" {
+			fmt.Fprintf(h, "\x00%s", c.Device)
+		}
+		if int(h.Sum32()%uint32(sh.Total)) == sh.Index-1 {
+			out = append(out, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/maxischmaxi/qsnap/internal/tools"
+	"gopkg.in/yaml.v3"
+)
+
+const SharedFileName = "_shared.osnap.yaml"
+
+// Fragment is a named, reusable piece of story config from a _shared.osnap.yaml
+// file. Stories pull fragments in with `use: [name, ...]`.
+type Fragment struct {
+	Actions []*Action      `yaml:"actions,omitempty" json:"actions,omitempty"`
+	Sizes   Sizes          `yaml:"sizes,omitempty" json:"sizes,omitempty"`
+	Ignore  []IgnoreRegion `yaml:"ignore,omitempty" json:"ignore,omitempty"`
+}
+
+func loadFragments(path string, into map[string]*Fragment) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+
+	fragments := map[string]*Fragment{}
+	if err := dec.Decode(&fragments); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	}
+
+	if err := tools.EnsureEOF(dec); err != nil {
+		return err
+	}
+
+	for name, fr := range fragments {
+		if _, ok := into[name]; ok {
+			return fmt.Errorf("shared fragment %q is defined more than once", name)
+		}
+		into[name] = fr
+	}
+	return nil
+}
+
+// applyFragments merges the fragments listed in c.Use into c. Fragment actions
+// run before the story's own actions, ignore regions are added, and fragment
+// sizes are only used if the story has none.
+func (cfg *OsnapBaseConfig) applyFragments(c *OsnapConfig) error {
+	var actions []*Action
+	for _, name := range c.Use {
+		fr, ok := cfg.fragments[name]
+		if !ok {
+			return fmt.Errorf("story %q uses unknown shared fragment %q", c.Name, name)
+		}
+
+		actions = append(actions, fr.Actions...)
+		c.Ignore = append(c.Ignore, fr.Ignore...)
+		if c.Sizes.empty() {
+			c.Sizes = fr.Sizes
+		}
+	}
+	c.Actions = append(actions, c.Actions...)
+	return nil
+}
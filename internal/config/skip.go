@@ -0,0 +1,38 @@
+package config
+
+import "fmt"
+
+// Skip is set by `skip: true` or `skip: "reason"` on a story. Skipped cases
+// are reported without being captured.
+type Skip struct {
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+func (s *Skip) UnmarshalYAML(unmarshal func(any) error) error {
+	var b bool
+	if err := unmarshal(&b); err == nil {
+		*s = Skip{Enabled: b}
+		return nil
+	}
+	var reason string
+	if err := unmarshal(&reason); err != nil {
+		return fmt.Errorf("skip must be true, false or a reason")
+	}
+	*s = Skip{Enabled: true, Reason: reason}
+	return nil
+}
+
+// Only keeps the stories marked with `only: true`, if there are any.
+func Only(configs []*OsnapConfig) ([]*OsnapConfig, bool) {
+	var out []*OsnapConfig
+	for _, c := range configs {
+		if c.Only {
+			out = append(out, c)
+		}
+	}
+	if len(out) == 0 {
+		return configs, false
+	}
+	return out, true
+}
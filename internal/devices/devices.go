@@ -0,0 +1,29 @@
+package devices
+
+import (
+	"strings"
+
+	"github.com/chromedp/chromedp/device"
+)
+
+// Presets, die chromedp (noch) nicht kennt
+var extra = []device.Info{
+	{Name: "Pixel 7", UserAgent: "Mozilla/5.0 (Linux; Android 13; Pixel 7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/116.0.0.0 Mobile Safari/537.36", Width: 412, Height: 915, Scale: 2.625, Mobile: true, Touch: true},
+	{Name: "Pixel 7 landscape", UserAgent: "Mozilla/5.0 (Linux; Android 13; Pixel 7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/116.0.0.0 Mobile Safari/537.36", Width: 915, Height: 412, Scale: 2.625, Landscape: true, Mobile: true, Touch: true},
+}
+
+// Lookup finds a device preset by name, case-insensitively, e.g. "iPhone 14"
+// or "Pixel 7". The names are the ones of Chrome DevTools' device list.
+func Lookup(name string) (device.Info, bool) {
+	for _, d := range extra {
+		if strings.EqualFold(d.Name, name) {
+			return d, true
+		}
+	}
+	for d := device.BlackberryPlayBook; d <= device.MotoG4landscape; d++ {
+		if info := d.Device(); strings.EqualFold(info.Name, name) {
+			return info, true
+		}
+	}
+	return device.Info{}, false
+}
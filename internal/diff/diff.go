@@ -3,12 +3,14 @@ package diff
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
 	"image/png"
 	"math"
 	"os"
+	"sort"
 
 	"github.com/corona10/goimagehash"
 	"github.com/nfnt/resize"
@@ -17,21 +19,65 @@ import (
 type PixelResult struct {
 	Pass          bool    `json:"pass"`
 	RatioDiff     float64 `json:"ratioDiff"`     // fraction of differing pixels
-	DiffImagePath string  `json:"diffImagePath"` // "" if not generated
+	DiffPixels    int     `json:"diffPixels"`    // number of differing pixels
+	Pixels        int     `json:"pixels"`        // compared pixels, without the gutter
+	Regions       int     `json:"regions"`       // separate changed areas, see findRegions
+	LargestRegion int     `json:"largestRegion"` // differing pixels in the largest region
+	// Boxes are the bounding rectangles of the largest regions, largest
+	// first, at most MaxBoxes
+	Boxes         []Box  `json:"boxes,omitempty"`
+	DiffImagePath string `json:"diffImagePath"` // "" if not generated
 }
 
+// Box is the bounding rectangle of a changed region, in pixels of the
+// baseline.
+type Box struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	Pixels int `json:"pixels"` // differing pixels inside the region
+}
+
+// MaxBoxes limits PixelResult.Boxes; Regions still counts all of them.
+const MaxBoxes = 20
+
 type PHashResult struct {
-	Pass            bool `json:"pass"`
-	HammingDistance int  `json:"hammingDistance"`
+	Pass            bool   `json:"pass"`
+	Algorithm       string `json:"algorithm"` // phash, dhash or ahash
+	HammingDistance int    `json:"hammingDistance"`
+}
+
+// ErrImageTooLarge is returned before decoding an image whose width or
+// height exceeds Options.MaxDimension.
+var ErrImageTooLarge = errors.New("image too large")
+
+// CheckSize reads only the PNG header and fails with ErrImageTooLarge if a
+// side exceeds maxDim (0 = unlimited).
+func CheckSize(buf []byte, maxDim int) error {
+	cfg, err := png.DecodeConfig(bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	return checkDims(cfg.Width, cfg.Height, maxDim)
 }
 
-func openPNG(path string) (image.Image, error) {
-	f, err := os.Open(path)
+func checkDims(w, h, maxDim int) error {
+	if maxDim > 0 && (w > maxDim || h > maxDim) {
+		return fmt.Errorf("%w: %dx%d exceeds %dpx", ErrImageTooLarge, w, h, maxDim)
+	}
+	return nil
+}
+
+func openPNG(path string, maxDim int) (image.Image, error) {
+	b, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	img, err := png.Decode(f)
+	if err := CheckSize(b, maxDim); err != nil {
+		return nil, fmt.Errorf("baseline: %w", err)
+	}
+	img, err := png.Decode(bytes.NewReader(b))
 	if err != nil {
 		return nil, err
 	}
@@ -47,7 +93,31 @@ func savePNG(path string, img image.Image) error {
 	return png.Encode(f, img)
 }
 
-func pixelDiff(a, b image.Image, threshold float64) (PixelResult, image.Image, error) {
+// Options steuern CompareFiles
+type Options struct {
+	PixelThreshold float64 // erlaubter Anteil abweichender Pixel
+	// MaxDiffPixels erlaubt stattdessen so viele abweichende Pixel (nil = PixelThreshold gilt)
+	MaxDiffPixels  *int
+	PHashThreshold int // erlaubte Hamming-Distanz
+	// Hash ist phash (Default), dhash oder ahash
+	Hash string
+	// ComputeHash rechnet den Hash auch, wenn der Vergleich besteht, z.B. für failWhen
+	ComputeHash bool
+	Highlight   color.Color
+	// Gutter schließt so viele Pixel am rechten und unteren Rand aus (Scrollbars)
+	Gutter int
+	// KeepDiff schreibt das Diff-Bild bei jeder Abweichung, nicht nur bei Überschreitung des Thresholds
+	KeepDiff bool
+	// SSIM > 0 berechnet den SSIM-Score; er entscheidet dann statt PixelThreshold,
+	// ob der Vergleich besteht (Mindest-Score, z.B. 0.98)
+	SSIM float64
+	// ComputeSSIM berechnet den Score auch ohne SSIM, z.B. für failWhen
+	ComputeSSIM bool
+	// MaxDimension begrenzt Breite/Höhe beider Bilder, bevor sie dekodiert werden (0 = unbegrenzt)
+	MaxDimension int
+}
+
+func pixelDiff(a, b image.Image, threshold float64, maxPixels *int, highlight color.Color, gutter int) (PixelResult, image.Image, error) {
 	ab := a.Bounds()
 	bb := b.Bounds()
 	if ab.Dx() != bb.Dx() || ab.Dy() != bb.Dy() {
@@ -63,38 +133,145 @@ func pixelDiff(a, b image.Image, threshold float64) (PixelResult, image.Image, e
 	diffImg := image.NewRGBA(image.Rect(0, 0, w, h))
 	draw.Draw(diffImg, diffImg.Bounds(), a, ab.Min, draw.Src)
 
+	// Scrollbar-Bereich rechts/unten nicht vergleichen
+	cw, ch := w, h
+	if gutter > 0 {
+		cw, ch = max(w-gutter, 1), max(h-gutter, 1)
+	}
+
 	var diffCount int
-	for y := range h {
-		for x := range w {
+	gw := (cw + regionCell - 1) / regionCell
+	cells := make([]int, gw*((ch+regionCell-1)/regionCell))
+	for y := range ch {
+		for x := range cw {
 			ar, ag, ab2, aa := a.At(x, y).RGBA()
 			br, bg, bb2, ba := b.At(x, y).RGBA()
 
 			if ar != br || ag != bg || ab2 != bb2 || aa != ba {
 				diffCount++
-				// mark in magenta
-				diffImg.Set(x, y, color.RGBA{255, 0, 255, 255})
+				diffImg.Set(x, y, highlight)
+				cells[(y/regionCell)*gw+x/regionCell]++
 			}
 		}
 	}
-	total := w * h
+	total := cw * ch
 	ratio := float64(diffCount) / float64(total)
 
-	return PixelResult{
-		Pass:      ratio <= threshold,
-		RatioDiff: ratio,
-	}, diffImg, nil
+	boxes := findRegions(cells, gw, cw, ch)
+	pass := ratio <= threshold
+	if maxPixels != nil {
+		pass = diffCount <= *maxPixels
+	}
+	res := PixelResult{
+		Pass:       pass,
+		RatioDiff:  ratio,
+		DiffPixels: diffCount,
+		Pixels:     total,
+		Regions:    len(boxes),
+	}
+	if len(boxes) > 0 {
+		res.LargestRegion = boxes[0].Pixels
+		res.Boxes = boxes[:min(len(boxes), MaxBoxes)]
+	}
+	for _, bx := range res.Boxes {
+		outline(diffImg, bx, highlight)
+	}
+	return res, diffImg, nil
+}
+
+// regionCell ist die Kantenlänge der Zellen, in denen Änderungen gezählt
+// werden; so zählt ein geänderter Text als eine Region statt als viele Glyphen
+const regionCell = 8
+
+// findRegions fasst zusammenhängende (8-Nachbarschaft) geänderte Zellen zu
+// Regionen zusammen, die größte zuerst; cells zählt die geänderten Pixel pro
+// Zelle, maxX/maxY begrenzen die Boxen auf den verglichenen Bereich
+func findRegions(cells []int, w, maxX, maxY int) []Box {
+	if w == 0 {
+		return nil
+	}
+	h := len(cells) / w
+	seen := make([]bool, len(cells))
+	var boxes []Box
+	var stack []int
+	for i, changed := range cells {
+		if changed == 0 || seen[i] {
+			continue
+		}
+		x0, y0, x1, y1, pixels := w, h, 0, 0, 0
+		seen[i] = true
+		stack = append(stack[:0], i)
+		for len(stack) > 0 {
+			c := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			cx, cy := c%w, c/w
+			x0, y0, x1, y1 = min(x0, cx), min(y0, cy), max(x1, cx), max(y1, cy)
+			pixels += cells[c]
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					x, y := cx+dx, cy+dy
+					if x < 0 || y < 0 || x >= w || y >= h {
+						continue
+					}
+					n := y*w + x
+					if cells[n] > 0 && !seen[n] {
+						seen[n] = true
+						stack = append(stack, n)
+					}
+				}
+			}
+		}
+		px, py := x0*regionCell, y0*regionCell
+		boxes = append(boxes, Box{
+			X:      px,
+			Y:      py,
+			Width:  min((x1+1)*regionCell, maxX) - px,
+			Height: min((y1+1)*regionCell, maxY) - py,
+			Pixels: pixels,
+		})
+	}
+	sort.SliceStable(boxes, func(i, j int) bool { return boxes[i].Pixels > boxes[j].Pixels })
+	return boxes
+}
+
+// outline zeichnet einen 2px-Rahmen knapp außerhalb der Box, damit die
+// markierten Pixel selbst sichtbar bleiben
+func outline(img *image.RGBA, b Box, c color.Color) {
+	r := image.Rect(b.X-3, b.Y-3, b.X+b.Width+3, b.Y+b.Height+3).Intersect(img.Bounds())
+	inner := r.Inset(2)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if !(image.Point{x, y}).In(inner) {
+				img.Set(x, y, c)
+			}
+		}
+	}
+}
+
+var hashes = map[string]func(image.Image) (*goimagehash.ImageHash, error){
+	"phash": goimagehash.PerceptionHash,
+	"dhash": goimagehash.DifferenceHash,
+	"ahash": goimagehash.AverageHash,
 }
 
-func pHashDistance(a, b image.Image, allowed int) (PHashResult, error) {
+func hashDistance(a, b image.Image, algorithm string, allowed int) (PHashResult, error) {
+	if algorithm == "" {
+		algorithm = "phash"
+	}
+	hash, ok := hashes[algorithm]
+	if !ok {
+		return PHashResult{}, fmt.Errorf("unknown hash algorithm %q", algorithm)
+	}
+
 	// resize to a stable small size
 	aSmall := resize.Resize(256, 0, a, resize.Lanczos3)
 	bSmall := resize.Resize(256, 0, b, resize.Lanczos3)
 
-	ha, err := goimagehash.PerceptionHash(aSmall)
+	ha, err := hash(aSmall)
 	if err != nil {
 		return PHashResult{}, err
 	}
-	hb, err := goimagehash.PerceptionHash(bSmall)
+	hb, err := hash(bSmall)
 	if err != nil {
 		return PHashResult{}, err
 	}
@@ -106,37 +283,82 @@ func pHashDistance(a, b image.Image, allowed int) (PHashResult, error) {
 
 	return PHashResult{
 		Pass:            hd <= allowed,
+		Algorithm:       algorithm,
 		HammingDistance: hd,
 	}, nil
 }
 
-func CompareFiles(baselinePath string, buf []byte, diffPath string, pxThreshold float64, phThreshold int) (PixelResult, PHashResult, error) {
-	baseImg, err := openPNG(baselinePath)
+// CompareFiles compares the capture buf with the baseline and writes the
+// diff image if the comparison fails. The SSIM result is nil unless
+// opts.SSIM or opts.ComputeSSIM is set, the hash result is nil if the
+// comparison passes and opts.ComputeHash is not set.
+func CompareFiles(baselinePath string, buf []byte, diffPath string, opts Options) (PixelResult, *PHashResult, *SSIMResult, error) {
+	if err := CheckSize(buf, opts.MaxDimension); err != nil {
+		return PixelResult{}, nil, nil, err
+	}
+	baseImg, err := openPNG(baselinePath, opts.MaxDimension)
 	if err != nil {
-		return PixelResult{}, PHashResult{}, err
+		return PixelResult{}, nil, nil, err
 	}
 
 	reader := bytes.NewReader(buf)
 	img, err := png.Decode(reader)
 	if err != nil {
-		return PixelResult{}, PHashResult{}, err
+		return PixelResult{}, nil, nil, err
 	}
 
-	px, diffImg, err := pixelDiff(baseImg, img, math.Max(0, pxThreshold))
+	highlight := opts.Highlight
+	if highlight == nil {
+		highlight = color.RGBA{255, 0, 255, 255}
+	}
+	px, diffImg, err := pixelDiff(baseImg, img, math.Max(0, opts.PixelThreshold), opts.MaxDiffPixels, highlight, opts.Gutter)
 	if err != nil {
-		return PixelResult{}, PHashResult{}, err
+		return PixelResult{}, nil, nil, err
 	}
 
-	var ph PHashResult
-	ph, err = pHashDistance(baseImg, img, phThreshold)
-	if err != nil {
-		return PixelResult{}, PHashResult{}, err
+	pass := px.Pass
+	var ss *SSIMResult
+	if opts.SSIM > 0 || opts.ComputeSSIM {
+		r := ssim(baseImg, img, opts.Gutter, opts.SSIM)
+		ss = &r
+		if opts.SSIM > 0 {
+			pass = r.Pass
+		}
 	}
 
-	if !px.Pass {
+	// der Hash zählt nur als zweite Meinung bei Abweichungen
+	var ph *PHashResult
+	if !pass || opts.ComputeHash {
+		r, err := hashDistance(baseImg, img, opts.Hash, opts.PHashThreshold)
+		if err != nil {
+			return PixelResult{}, nil, nil, err
+		}
+		ph = &r
+	}
+
+	if !pass || (opts.KeepDiff && px.RatioDiff > 0) {
 		_ = savePNG(diffPath, diffImg)
 		px.DiffImagePath = diffPath
 	}
 
-	return px, ph, nil
+	return px, ph, ss, nil
+}
+
+// PixelRatio returns the fraction of differing pixels between two PNG captures,
+// ignoring gutter pixels at the right and bottom edge.
+func PixelRatio(a, b []byte, gutter int) (float64, error) {
+	imgA, err := png.Decode(bytes.NewReader(a))
+	if err != nil {
+		return 0, err
+	}
+	imgB, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		return 0, err
+	}
+
+	px, _, err := pixelDiff(imgA, imgB, 1, nil, color.Black, gutter)
+	if err != nil {
+		return 0, err
+	}
+	return px.RatioDiff, nil
 }
@@ -0,0 +1,96 @@
+package diff
+
+import (
+	"image"
+
+	"github.com/nfnt/resize"
+)
+
+type SSIMResult struct {
+	Pass  bool    `json:"pass"`
+	Score float64 `json:"score"` // mean structural similarity, 1 = identical
+}
+
+const (
+	ssimWindow = 8
+	ssimStride = 4
+	// Stabilisierungskonstanten aus Wang et al. 2004 für 8-Bit-Werte
+	ssimC1 = (0.01 * 255) * (0.01 * 255)
+	ssimC2 = (0.03 * 255) * (0.03 * 255)
+)
+
+// luma returns the grayscale values of img, cropped to w x h.
+func luma(img image.Image, w, h int) []float64 {
+	out := make([]float64, w*h)
+	o := img.Bounds().Min
+	for y := range h {
+		for x := range w {
+			r, g, b, _ := img.At(o.X+x, o.Y+y).RGBA()
+			out[y*w+x] = (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+		}
+	}
+	return out
+}
+
+// ssim is the mean SSIM of 8x8 windows (stride 4) of the luminance of a and
+// b, ignoring gutter pixels at the right and bottom edge. b is scaled to the
+// size of a like in pixelDiff.
+func ssim(a, b image.Image, gutter int, minScore float64) SSIMResult {
+	ab := a.Bounds()
+	if bb := b.Bounds(); ab.Dx() != bb.Dx() || ab.Dy() != bb.Dy() {
+		b = resize.Resize(uint(ab.Dx()), uint(ab.Dy()), b, resize.NearestNeighbor)
+	}
+	w, h := ab.Dx(), ab.Dy()
+	if gutter > 0 {
+		w, h = max(w-gutter, 1), max(h-gutter, 1)
+	}
+	la, lb := luma(a, w, h), luma(b, w, h)
+
+	// kleiner als ein Fenster: das ganze Bild ist ein Fenster
+	win := min(ssimWindow, w, h)
+	var sum float64
+	n := 0
+	for y := 0; y+win <= h; y += ssimStride {
+		for x := 0; x+win <= w; x += ssimStride {
+			sum += ssimWindowAt(la, lb, w, x, y, win)
+			n++
+		}
+	}
+	score := 1.0
+	if n > 0 {
+		score = sum / float64(n)
+	}
+	return SSIMResult{Pass: score >= minScore, Score: score}
+}
+
+func ssimWindowAt(a, b []float64, stride, x0, y0, win int) float64 {
+	var ma, mb float64
+	for y := y0; y < y0+win; y++ {
+		for x := x0; x < x0+win; x++ {
+			ma += a[y*stride+x]
+			mb += b[y*stride+x]
+		}
+	}
+	count := float64(win * win)
+	ma /= count
+	mb /= count
+
+	var va, vb, cov float64
+	for y := y0; y < y0+win; y++ {
+		for x := x0; x < x0+win; x++ {
+			da, db := a[y*stride+x]-ma, b[y*stride+x]-mb
+			va += da * da
+			vb += db * db
+			cov += da * db
+		}
+	}
+	// Stichprobenvarianz; ein 1x1-Fenster (Bild mit 1px Breite) hat keine
+	// Streuung, dort würde count-1 durch 0 teilen
+	n := max(count-1, 1)
+	va /= n
+	vb /= n
+	cov /= n
+
+	return ((2*ma*mb + ssimC1) * (2*cov + ssimC2)) /
+		((ma*ma + mb*mb + ssimC1) * (va + vb + ssimC2))
+}
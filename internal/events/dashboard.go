@@ -0,0 +1,99 @@
+package events
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// ServeDashboard serves a live web UI of the run on addr, e.g. ":8088", and
+// returns the address it listens on. The page gets the events as
+// server-sent events from /events and the images of finished cases from
+// /image, limited to the directories passed to AllowImages.
+func (h *Hub) ServeDashboard(addr string) (string, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(dashboardHTML)
+	})
+	mux.HandleFunc("GET /events", h.serveSSE)
+	mux.HandleFunc("GET /image", h.serveImage)
+	return h.serve(addr, mux)
+}
+
+// AllowImages lets the dashboard serve the PNGs below dir.
+func (h *Hub) AllowImages(dir string) {
+	if h == nil {
+		return
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	h.imageDirs = append(h.imageDirs, abs)
+	h.mu.Unlock()
+}
+
+func (h *Hub) serveSSE(w http.ResponseWriter, r *http.Request) {
+	if !LocalRequest(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+
+	done := make(chan struct{})
+	c := h.subscribe(func() {})
+	go func() {
+		select {
+		case <-r.Context().Done():
+			h.drop(c)
+		case <-done:
+		}
+	}()
+	defer close(done)
+	for b := range c.out {
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", b); err != nil {
+			h.drop(c)
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// serveImage liefert nur PNGs unterhalb der erlaubten Verzeichnisse aus,
+// der Pfad kommt unverändert aus dem Report
+func (h *Hub) serveImage(w http.ResponseWriter, r *http.Request) {
+	if !LocalRequest(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	path, err := filepath.Abs(r.URL.Query().Get("path"))
+	if err != nil || !strings.EqualFold(filepath.Ext(path), ".png") {
+		http.NotFound(w, r)
+		return
+	}
+	h.mu.Lock()
+	dirs := h.imageDirs
+	h.mu.Unlock()
+	for _, dir := range dirs {
+		if rel, err := filepath.Rel(dir, path); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			w.Header().Set("Cache-Control", "no-cache")
+			http.ServeFile(w, r, path)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
@@ -0,0 +1,238 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/maxischmaxi/qsnap/internal/report"
+)
+
+const (
+	RunStarted   = "runStarted"
+	CaseFinished = "caseFinished"
+	RunFinished  = "runFinished"
+)
+
+// Event is sent as one JSON text frame per event.
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+
+	Total  int                `json:"total,omitempty"`  // runStarted
+	Case   *report.CaseResult `json:"case,omitempty"`   // caseFinished
+	Report *report.Report     `json:"report,omitempty"` // runFinished
+}
+
+// Hub broadcasts run events to WebSocket and dashboard clients. Clients that
+// connect during a run first get all events of that run, so the editor can
+// catch up. A nil Hub drops everything.
+type Hub struct {
+	mu      sync.Mutex
+	clients map[*client]struct{}
+	history [][]byte
+	srvs    []*http.Server
+	addr    string
+	// imageDirs sind die Verzeichnisse, aus denen das Dashboard Bilder ausliefert
+	imageDirs []string
+}
+
+// client ist eine WebSocket- oder SSE-Verbindung; hangup beendet sie
+type client struct {
+	out    chan []byte
+	hangup func()
+}
+
+// New returns a hub without listeners, see Listen and ServeDashboard.
+func New() *Hub {
+	return &Hub{clients: map[*client]struct{}{}}
+}
+
+// Listen serves the hub on addr, e.g. "127.0.0.1:7357". Every path upgrades
+// to a WebSocket.
+func Listen(addr string) (*Hub, error) {
+	h := New()
+	a, err := h.serve(addr, http.HandlerFunc(h.serveWS))
+	if err != nil {
+		return nil, err
+	}
+	h.addr = a
+	return h, nil
+}
+
+func (h *Hub) serve(addr string, handler http.Handler) (string, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 5 * time.Second}
+	h.mu.Lock()
+	h.srvs = append(h.srvs, srv)
+	h.mu.Unlock()
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			_ = ln.Close()
+		}
+	}()
+	return ln.Addr().String(), nil
+}
+
+func (h *Hub) Addr() string {
+	if h == nil {
+		return ""
+	}
+	return h.addr
+}
+
+// subscribe registriert einen Client und füllt ihn mit den bisherigen Events
+func (h *Hub) subscribe(hangup func()) *client {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c := &client{out: make(chan []byte, len(h.history)+256), hangup: hangup}
+	for _, b := range h.history {
+		c.out <- b
+	}
+	h.clients[c] = struct{}{}
+	return c
+}
+
+// LocalRequest lässt nur Requests an localhost zu, von Clients ohne Origin
+// (Editor-Extensions, CLI-Tools) oder von Seiten auf localhost; sonst könnte
+// jede im Browser offene Website die Events mitlesen. Auch der Host-Header
+// muss localhost sein, gegen DNS-Rebinding einer fremden Domain auf 127.0.0.1
+func LocalRequest(r *http.Request) bool {
+	return LoopbackHost(r.Host) && !CrossSite(r)
+}
+
+// CrossSite reports whether a browser sent r from a page that is not served
+// from localhost.
+func CrossSite(r *http.Request) bool {
+	// Browser melden mit Sec-Fetch-Site auch Requests ohne Origin, z.B. <img>
+	if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || !LoopbackHost(u.Host)
+}
+
+// LoopbackHost reports whether host[:port] is localhost or a loopback IP.
+func LoopbackHost(hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (h *Hub) serveWS(w http.ResponseWriter, r *http.Request) {
+	if !LocalRequest(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	conn, _, _, err := ws.UpgradeHTTP(r, w)
+	if err != nil {
+		return
+	}
+	c := h.subscribe(func() { _ = conn.Close() })
+
+	// Lesen nur, um Close-Frames und Verbindungsabbrüche mitzubekommen
+	go func() {
+		for {
+			if _, _, err := wsutil.ReadClientData(conn); err != nil {
+				h.drop(c)
+				return
+			}
+		}
+	}()
+
+	for b := range c.out {
+		_ = conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if err := wsutil.WriteServerText(conn, b); err != nil {
+			h.drop(c)
+			return
+		}
+	}
+}
+
+func (h *Hub) drop(c *client) {
+	h.mu.Lock()
+	if _, ok := h.clients[c]; ok {
+		delete(h.clients, c)
+		close(c.out)
+	}
+	h.mu.Unlock()
+	c.hangup()
+}
+
+// Publish sends ev to all clients. Slow clients that fall behind are
+// disconnected instead of blocking the run.
+func (h *Hub) Publish(ev Event) {
+	if h == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+
+	h.mu.Lock()
+	if ev.Type == RunStarted {
+		h.history = nil
+	}
+	h.history = append(h.history, b)
+	var slow []*client
+	for c := range h.clients {
+		select {
+		case c.out <- b:
+		default:
+			slow = append(slow, c)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, c := range slow {
+		h.drop(c)
+	}
+}
+
+func (h *Hub) Close() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	srvs := h.srvs
+	clients := make([]*client, 0, len(h.clients))
+	for c := range h.clients {
+		clients = append(clients, c)
+	}
+	h.mu.Unlock()
+	// erst die Clients trennen, Shutdown wartet sonst auf offene SSE-Streams
+	for _, c := range clients {
+		h.drop(c)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, srv := range srvs {
+		_ = srv.Shutdown(ctx)
+	}
+}
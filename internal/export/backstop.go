@@ -0,0 +1,118 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/maxischmaxi/qsnap/internal/report"
+	"github.com/maxischmaxi/qsnap/internal/tools"
+)
+
+// Struktur von backstop_data/json_report/jsonReport.json bzw. html_report/config.js
+type backstopReport struct {
+	TestSuite string         `json:"testSuite"`
+	ID        string         `json:"id"`
+	Tests     []backstopTest `json:"tests"`
+}
+
+type backstopTest struct {
+	Pair   backstopPair `json:"pair"`
+	Status string       `json:"status"` // pass | fail
+}
+
+type backstopPair struct {
+	Reference             string        `json:"reference"`
+	Test                  string        `json:"test"`
+	Selector              string        `json:"selector"`
+	FileName              string        `json:"fileName"`
+	Label                 string        `json:"label"`
+	RequireSameDimensions bool          `json:"requireSameDimensions"`
+	MisMatchThreshold     float64       `json:"misMatchThreshold"`
+	URL                   string        `json:"url"`
+	ReferenceURL          string        `json:"referenceUrl"`
+	Expect                int           `json:"expect"`
+	ViewportLabel         string        `json:"viewportLabel"`
+	Diff                  *backstopDiff `json:"diff,omitempty"`
+	DiffImage             string        `json:"diffImage,omitempty"`
+	Error                 string        `json:"error,omitempty"`
+}
+
+type backstopDiff struct {
+	IsSameDimensions    bool `json:"isSameDimensions"`
+	DimensionDifference struct {
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	} `json:"dimensionDifference"`
+	RawMisMatchPercentage float64 `json:"rawMisMatchPercentage"`
+	MisMatchPercentage    string  `json:"misMatchPercentage"`
+	AnalysisTime          int64   `json:"analysisTime"`
+}
+
+// Backstop writes jsonReport.json and config.js (the data file of the
+// BackstopJS HTML report) to dir. Image paths are relative to dir, skipped
+// cases are left out and cases without baseline or with errors count as fail.
+func Backstop(r report.Report, dir string) error {
+	out := backstopReport{TestSuite: "BackstopJS", ID: "qsnap"}
+	for _, c := range r.Cases {
+		var status string
+		switch c.Status {
+		case "pass", "cached-pass", "flaky":
+			status = "pass"
+		case "fail", "unstable", "no-baseline", "error", "image-too-large":
+			status = "fail"
+		default:
+			continue
+		}
+
+		p := backstopPair{
+			Reference:             rel(dir, c.Baseline),
+			Test:                  rel(dir, c.Actual),
+			Selector:              "document",
+			FileName:              filepath.Base(c.Baseline),
+			Label:                 c.Label(),
+			RequireSameDimensions: true,
+			MisMatchThreshold:     c.Threshold * 100,
+			URL:                   c.URL,
+			ViewportLabel:         fmt.Sprintf("%dx%d", c.Width, c.Height),
+			Error:                 c.Error,
+		}
+		if px, ok := c.Pixel(); ok {
+			p.Diff = &backstopDiff{
+				IsSameDimensions:      true,
+				RawMisMatchPercentage: px.RatioDiff * 100,
+				MisMatchPercentage:    fmt.Sprintf("%.2f", px.RatioDiff*100),
+				AnalysisTime:          c.DiffTime.Milliseconds(),
+			}
+			if px.DiffImagePath != "" {
+				p.DiffImage = rel(dir, px.DiffImagePath)
+			}
+		}
+		out.Tests = append(out.Tests, backstopTest{Pair: p, Status: status})
+	}
+
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tools.WriteFile(filepath.Join(dir, "jsonReport.json"), b); err != nil {
+		return err
+	}
+	return tools.WriteFile(filepath.Join(dir, "config.js"), append(append([]byte("report("), b...), ");\n"...))
+}
+
+func rel(dir, path string) string {
+	if path == "" {
+		return ""
+	}
+	absDir, err1 := filepath.Abs(dir)
+	absPath, err2 := filepath.Abs(path)
+	if err1 != nil || err2 != nil {
+		return path
+	}
+	r, err := filepath.Rel(absDir, absPath)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(r)
+}
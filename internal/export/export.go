@@ -0,0 +1,33 @@
+package export
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/maxischmaxi/qsnap/internal/report"
+)
+
+// Exporter writes r in another tool's format into dir.
+type Exporter func(r report.Report, dir string) error
+
+var exporters = map[string]Exporter{
+	"backstop": Backstop,
+}
+
+// Formats lists the registered exporter names.
+func Formats() []string {
+	var out []string
+	for k := range exporters {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func Write(format string, r report.Report, dir string) error {
+	ex, ok := exporters[format]
+	if !ok {
+		return fmt.Errorf("unknown export format %q: expected one of %v", format, Formats())
+	}
+	return ex(r, dir)
+}
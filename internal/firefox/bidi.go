@@ -0,0 +1,154 @@
+package firefox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+// conn is a WebDriver BiDi session: commands go out as JSON with an id, and
+// the reader goroutine hands every answer to the caller waiting for its id.
+// Events are not needed for captures and are dropped.
+type conn struct {
+	nc net.Conn
+	rw io.ReadWriter
+
+	wmu sync.Mutex // Schreiben auf den Socket
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]chan message
+	err     error // gesetzt, sobald der Socket zu ist
+}
+
+type message struct {
+	Type    string          `json:"type"`
+	ID      *int            `json:"id"`
+	Result  json.RawMessage `json:"result"`
+	Error   string          `json:"error"`
+	Message string          `json:"message"`
+}
+
+// CommandError is the error answer of a BiDi command.
+type CommandError struct {
+	Method  string
+	Code    string // z.B. "no such element"
+	Message string
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Method, e.Code, e.Message)
+}
+
+func dial(ctx context.Context, url string) (*conn, error) {
+	nc, br, _, err := ws.Dial(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{nc: nc, rw: nc, pending: map[int]chan message{}}
+	if br != nil {
+		// der Handshake hat schon Frames mitgelesen
+		c.rw = struct {
+			io.Reader
+			io.Writer
+		}{br, nc}
+	}
+	go c.read()
+	return c, nil
+}
+
+func (c *conn) read() {
+	for {
+		b, err := wsutil.ReadServerText(c.rw)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		var m message
+		if json.Unmarshal(b, &m) != nil || m.ID == nil {
+			continue
+		}
+		c.mu.Lock()
+		ch := c.pending[*m.ID]
+		delete(c.pending, *m.ID)
+		c.mu.Unlock()
+		if ch != nil {
+			ch <- m
+		}
+	}
+}
+
+func (c *conn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = fmt.Errorf("firefox connection closed: %w", err)
+	}
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+// call sends a command and decodes its result into out (nil to discard).
+func (c *conn) call(ctx context.Context, method string, params, out any) error {
+	if params == nil {
+		params = struct{}{}
+	}
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan message, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	b, err := json.Marshal(map[string]any{"id": id, "method": method, "params": params})
+	if err != nil {
+		return err
+	}
+	c.wmu.Lock()
+	err = wsutil.WriteClientText(c.nc, b)
+	c.wmu.Unlock()
+	if err != nil {
+		c.fail(err)
+		return c.err
+	}
+
+	select {
+	case m, ok := <-ch:
+		if !ok {
+			return c.err
+		}
+		if m.Type == "error" {
+			return &CommandError{Method: method, Code: m.Error, Message: m.Message}
+		}
+		if out == nil {
+			return nil
+		}
+		return json.Unmarshal(m.Result, out)
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (c *conn) close() error {
+	err := c.nc.Close()
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,268 @@
+package firefox
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/maxischmaxi/qsnap/internal/snapshot"
+)
+
+// Unsupported returns the config options set in opts that Firefox captures
+// ignore, for a warning on the case.
+func Unsupported(opts snapshot.Options) []string {
+	var out []string
+	add := func(set bool, name string) {
+		if set {
+			out = append(out, name)
+		}
+	}
+	add(opts.Device != nil && (opts.Device.UserAgent != "" || opts.Device.Touch), "device user agent and touch")
+	add(opts.ColorScheme != "", "colorScheme")
+	add(opts.Timezone != "", "timezone")
+	add(opts.Locale != "", "locale")
+	add(opts.Geolocation != nil, "geolocation")
+	add(opts.WaitPlay, "waitForPlay")
+	add(opts.Stitch, "stitch")
+	add(opts.NetworkIdle > 0, "networkIdleMs")
+	add(len(opts.Headers) > 0, "headers and basicAuth")
+	add(len(opts.BlockHosts) > 0 || len(opts.AllowHosts) > 0, "blockHosts and allowHosts")
+	add(len(opts.Mocks) > 0, "mocks")
+	add(len(opts.Cookies) > 0 || len(opts.LocalStorage) > 0, "cookies and localStorage")
+	add(len(opts.Actions) > 0, "actions")
+	add(len(opts.Before) > 0 || len(opts.After) > 0, "capture hooks")
+	return out
+}
+
+// Capture loads url in a new tab and takes a screenshot like
+// snapshot.Capture, for the options Unsupported doesn't list.
+func (b *Browser) Capture(ctx context.Context, url string, opts snapshot.Options) (*snapshot.Result, error) {
+	var created struct {
+		Context string `json:"context"`
+	}
+	if err := b.conn.call(ctx, "browsingContext.create", map[string]any{"type": "tab"}, &created); err != nil {
+		return nil, err
+	}
+	p := &page{conn: b.conn, id: created.Context}
+	defer func() {
+		// auch nach Ablauf von ctx schließen, sonst sammeln sich die Tabs
+		cctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = b.conn.call(cctx, "browsingContext.close", map[string]any{"context": p.id}, nil)
+	}()
+
+	viewport := map[string]any{"context": p.id, "viewport": map[string]int{"width": opts.Width, "height": opts.Height}}
+	if opts.Device != nil && opts.Device.Scale > 0 {
+		viewport["devicePixelRatio"] = opts.Device.Scale
+	}
+	if err := b.conn.call(ctx, "browsingContext.setViewport", viewport, nil); err != nil {
+		return nil, err
+	}
+	if opts.DisableAnimations {
+		if err := p.preload(ctx, snapshot.NoMotionScript); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Seed != nil {
+		if err := p.preload(ctx, fmt.Sprintf("window.__QSNAP_SEED__ = %d", *opts.Seed)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := b.conn.call(ctx, "browsingContext.navigate", map[string]any{"context": p.id, "url": url, "wait": "complete"}, nil); err != nil {
+		return nil, err
+	}
+	if err := p.waitAny(ctx, []string{"body"}, false, 10*time.Second); err != nil {
+		return nil, err
+	}
+	if err := p.waitAny(ctx, opts.WaitSelectors, opts.WaitVisible, 10*time.Second); err != nil {
+		return nil, err
+	}
+	fctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	err := p.evaluate(fctx, `document.fonts ? document.fonts.ready.then(() => true) : true`, nil)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("waiting for document.fonts.ready: %w", err)
+	}
+	if err := sleep(ctx, 50*time.Millisecond+opts.Settle); err != nil {
+		return nil, err
+	}
+
+	res := &snapshot.Result{}
+	if len(opts.Masks) > 0 {
+		js, err := snapshot.MaskScript(opts.Masks)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.evaluate(ctx, js, &res.Masked); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.stableScreenshot(ctx, opts, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// page ist ein Tab (BiDi: browsing context) während eines Captures
+type page struct {
+	conn *conn
+	id   string
+}
+
+// preload führt js in jedem neuen Dokument des Tabs vor den Skripten der Seite aus
+func (p *page) preload(ctx context.Context, js string) error {
+	return p.conn.call(ctx, "script.addPreloadScript", map[string]any{
+		"functionDeclaration": "() => { " + js + "; }",
+		"contexts":            []string{p.id},
+	}, nil)
+}
+
+type evalResult struct {
+	Type             string          `json:"type"`
+	Result           json.RawMessage `json:"result"`
+	ExceptionDetails *struct {
+		Text string `json:"text"`
+	} `json:"exceptionDetails"`
+}
+
+func (p *page) eval(ctx context.Context, expr string) (json.RawMessage, error) {
+	var r evalResult
+	err := p.conn.call(ctx, "script.evaluate", map[string]any{
+		"expression":   expr,
+		"target":       map[string]string{"context": p.id},
+		"awaitPromise": true,
+	}, &r)
+	if err != nil {
+		return nil, err
+	}
+	if r.Type == "exception" {
+		msg := "exception"
+		if r.ExceptionDetails != nil {
+			msg = r.ExceptionDetails.Text
+		}
+		return nil, fmt.Errorf("script: %s", msg)
+	}
+	return r.Result, nil
+}
+
+// evaluate wertet expr aus und dekodiert das Ergebnis in out; der Umweg über
+// JSON.stringify erspart das Auspacken der BiDi-RemoteValues
+func (p *page) evaluate(ctx context.Context, expr string, out any) error {
+	raw, err := p.eval(ctx, "Promise.resolve("+expr+").then(v => JSON.stringify(v === undefined ? null : v))")
+	if err != nil {
+		return err
+	}
+	var v struct {
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal([]byte(v.Value), out)
+}
+
+// waitAny wartet wie im Chrome-Capture, bis einer der Selektoren da bzw. sichtbar ist
+func (p *page) waitAny(ctx context.Context, selectors []string, visible bool, timeout time.Duration) error {
+	if len(selectors) == 0 {
+		return nil
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		for _, sel := range selectors {
+			var ok bool
+			if err := p.evaluate(ctx, snapshot.FoundScript(sel, visible), &ok); err == nil && ok {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			if visible {
+				return fmt.Errorf("timeout waiting for any of %s to become visible", strings.Join(selectors, ", "))
+			}
+			return errors.New("timeout waiting for any selector")
+		}
+		if err := sleep(ctx, 50*time.Millisecond); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *page) screenshot(ctx context.Context, opts snapshot.Options) ([]byte, error) {
+	params := map[string]any{"context": p.id}
+	switch {
+	case opts.Selector != "":
+		raw, err := p.eval(ctx, fmt.Sprintf("document.querySelector(%q)", opts.Selector))
+		if err != nil {
+			return nil, err
+		}
+		var node struct {
+			Type     string `json:"type"`
+			SharedID string `json:"sharedId"`
+		}
+		if err := json.Unmarshal(raw, &node); err != nil {
+			return nil, err
+		}
+		if node.Type != "node" || node.SharedID == "" {
+			return nil, fmt.Errorf("selector %q matches no element", opts.Selector)
+		}
+		params["clip"] = map[string]any{"type": "element", "element": map[string]string{"sharedId": node.SharedID}}
+	case opts.FullPage:
+		params["origin"] = "document"
+	}
+	var shot struct {
+		Data string `json:"data"`
+	}
+	if err := p.conn.call(ctx, "browsingContext.captureScreenshot", params, &shot); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(shot.Data)
+}
+
+// stableScreenshot wie snapshot.stableScreenshot: bis zu opts.Stabilize
+// Screenshots, bis zwei hintereinander gleich sind
+func (p *page) stableScreenshot(ctx context.Context, opts snapshot.Options, res *snapshot.Result) error {
+	if opts.Stabilize < 2 {
+		buf, err := p.screenshot(ctx, opts)
+		res.PNG = buf
+		return err
+	}
+	var prev []byte
+	for i := 0; i < opts.Stabilize; i++ {
+		if i > 0 {
+			if err := sleep(ctx, opts.StabilizeInterval); err != nil {
+				return err
+			}
+		}
+		buf, err := p.screenshot(ctx, opts)
+		if err != nil {
+			return err
+		}
+		if prev != nil && bytes.Equal(prev, buf) {
+			res.PNG = buf
+			return nil
+		}
+		prev = buf
+	}
+	res.PNG = prev
+	res.Unstable = true
+	return nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
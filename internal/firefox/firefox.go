@@ -0,0 +1,171 @@
+// Package firefox captures stories in Firefox over WebDriver BiDi. It covers
+// the core of a capture (viewport, waiting, screenshot); emulation that
+// qsnap does over CDP in Chrome is not available here, see Unsupported.
+package firefox
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/maxischmaxi/qsnap/internal/procs"
+	"github.com/maxischmaxi/qsnap/internal/tools"
+)
+
+// Browser is a headless Firefox process with one BiDi session. Captures run
+// in their own tabs and can run concurrently.
+type Browser struct {
+	cmd     *exec.Cmd
+	profile string
+	conn    *conn
+}
+
+// prefs kommen in die user.js des frischen Profils: keine Updates,
+// Telemetrie oder Erststart-Seiten, die Captures stören könnten
+var prefs = map[string]any{
+	"remote.active-protocols":                    1, // nur BiDi, kein CDP
+	"app.update.disabledForTesting":              true,
+	"browser.shell.checkDefaultBrowser":          false,
+	"browser.startup.homepage_override.mstone":   "ignore",
+	"browser.sessionstore.resume_from_crash":     false,
+	"datareporting.policy.dataSubmissionEnabled": false,
+	"toolkit.telemetry.reportingpolicy.firstRun": false,
+	"dom.disable_beforeunload":                   true,
+}
+
+// Path returns the Firefox binary qsnap launches: $FIREFOX_BIN if set, else
+// the first installed Firefox.
+func Path() (string, error) {
+	if bin := os.Getenv("FIREFOX_BIN"); bin != "" {
+		if !tools.FileExists(bin) {
+			return "", fmt.Errorf("FIREFOX_BIN %s does not exist", bin)
+		}
+		return bin, nil
+	}
+	var candidates []string
+	switch runtime.GOOS {
+	case "darwin":
+		candidates = []string{"/Applications/Firefox.app/Contents/MacOS/firefox"}
+	case "linux":
+		candidates = []string{"firefox", "firefox-esr"}
+	case "windows":
+		candidates = []string{
+			filepath.Join(os.Getenv("ProgramFiles"), `Mozilla Firefox\firefox.exe`),
+			filepath.Join(os.Getenv("ProgramFiles(x86)"), `Mozilla Firefox\firefox.exe`),
+		}
+	}
+	for _, c := range candidates {
+		if p, err := exec.LookPath(c); err == nil {
+			return p, nil
+		}
+		if tools.FileExists(c) {
+			return c, nil
+		}
+	}
+	return "", errors.New("firefox not found, install it or set FIREFOX_BIN")
+}
+
+// Launch starts headless Firefox with a fresh profile and opens a BiDi
+// session. ctx only bounds the startup; Close ends the process.
+func Launch(ctx context.Context) (*Browser, error) {
+	bin, err := Path()
+	if err != nil {
+		return nil, err
+	}
+	profile, err := os.MkdirTemp("", "qsnap-firefox-")
+	if err != nil {
+		return nil, err
+	}
+	var js strings.Builder
+	for name, value := range prefs {
+		fmt.Fprintf(&js, "user_pref(%q, %#v);\n", name, value)
+	}
+	if err := os.WriteFile(filepath.Join(profile, "user.js"), []byte(js.String()), 0o644); err != nil {
+		_ = os.RemoveAll(profile)
+		return nil, err
+	}
+
+	cmd := exec.Command(bin, "--headless", "--no-remote", "--profile", profile, "--remote-debugging-port", "0")
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		_ = os.RemoveAll(profile)
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		_ = os.RemoveAll(profile)
+		return nil, fmt.Errorf("start firefox: %w", err)
+	}
+	b := &Browser{cmd: cmd, profile: profile}
+	_ = procs.Write("firefox", cmd.Process.Pid)
+
+	addr, err := listenAddr(ctx, stderr)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	if b.conn, err = dial(ctx, addr+"/session"); err != nil {
+		b.Close()
+		return nil, fmt.Errorf("connect to firefox: %w", err)
+	}
+	if err := b.conn.call(ctx, "session.new", map[string]any{"capabilities": map[string]any{}}, nil); err != nil {
+		b.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// listenAddr liest die ws://-Adresse aus der Startausgabe; der Rest von
+// stderr wird weiter gelesen, damit Firefox nicht an einer vollen Pipe hängt
+func listenAddr(ctx context.Context, stderr io.Reader) (string, error) {
+	const marker = "WebDriver BiDi listening on "
+	found := make(chan string, 1)
+	go func() {
+		sc := bufio.NewScanner(stderr)
+		sent := false
+		for sc.Scan() {
+			if _, addr, ok := strings.Cut(sc.Text(), marker); ok && !sent {
+				found <- strings.TrimSpace(addr)
+				sent = true
+			}
+		}
+		if !sent {
+			close(found)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	select {
+	case addr, ok := <-found:
+		if !ok {
+			return "", errors.New("firefox exited before WebDriver BiDi was ready")
+		}
+		return addr, nil
+	case <-ctx.Done():
+		return "", fmt.Errorf("waiting for firefox: %w", ctx.Err())
+	}
+}
+
+// Close ends the session and the Firefox process and removes its profile.
+func (b *Browser) Close() {
+	if b.conn != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		_ = b.conn.call(ctx, "session.end", nil, nil)
+		cancel()
+		_ = b.conn.close()
+	}
+	if b.cmd.Process != nil {
+		_ = b.cmd.Process.Kill()
+		_ = b.cmd.Wait()
+		procs.Remove("firefox", b.cmd.Process.Pid)
+	}
+	_ = os.RemoveAll(b.profile)
+}
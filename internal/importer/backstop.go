@@ -0,0 +1,94 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+type backstopConfig struct {
+	ID        string `json:"id"`
+	Viewports []struct {
+		Label  string `json:"label"`
+		Name   string `json:"name"`
+		Width  int    `json:"width"`
+		Height int    `json:"height"`
+	} `json:"viewports"`
+	Scenarios []struct {
+		Label     string   `json:"label"`
+		URL       string   `json:"url"`
+		Selectors []string `json:"selectors"`
+	} `json:"scenarios"`
+	Paths struct {
+		BitmapsReference string `json:"bitmaps_reference"`
+	} `json:"paths"`
+}
+
+// BackstopJS entfernt alles außer [a-z0-9_-] aus Labels und Selektoren
+var backstopUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// importBackstop reads backstop.json and maps the default reference filename
+// template {configId}_{scenarioLabel}_{selectorIndex}_{selectorLabel}_{viewportIndex}_{viewportLabel}.
+// Only whole-page ("document") and the first selector of a scenario are
+// imported, since a qsnap story captures a single element.
+func importBackstop(configPath string) (*Plan, error) {
+	if st, err := os.Stat(configPath); err == nil && st.IsDir() {
+		configPath = filepath.Join(configPath, "backstop.json")
+	}
+	b, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	var cfg backstopConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", configPath, err)
+	}
+	if len(cfg.Scenarios) == 0 || len(cfg.Viewports) == 0 {
+		return nil, errors.New("backstop config needs scenarios and viewports")
+	}
+
+	dir := filepath.Dir(configPath)
+	refDir := cfg.Paths.BitmapsReference
+	if refDir == "" {
+		refDir = filepath.Join("backstop_data", "bitmaps_reference")
+	}
+	if !filepath.IsAbs(refDir) {
+		refDir = filepath.Join(dir, refDir)
+	}
+	id := cfg.ID
+	if id == "" {
+		id = "backstop_default"
+	}
+
+	p := &Plan{}
+	for _, sc := range cfg.Scenarios {
+		selector, selectorLabel := "", "document"
+		if len(sc.Selectors) > 0 && sc.Selectors[0] != "document" {
+			selector = sc.Selectors[0]
+			selectorLabel = backstopUnsafe.ReplaceAllString(selector, "")
+		}
+		if len(sc.Selectors) > 1 {
+			p.warnf("scenario %q: only the first of %d selectors is imported", sc.Label, len(sc.Selectors))
+		}
+
+		label := backstopUnsafe.ReplaceAllString(sc.Label, "")
+		for vi, vp := range cfg.Viewports {
+			vpLabel := vp.Label
+			if vpLabel == "" {
+				vpLabel = vp.Name
+			}
+			file := filepath.Join(refDir, fmt.Sprintf("%s_%s_0_%s_%d_%s.png", id, label, selectorLabel, vi, vpLabel))
+			if _, err := os.Stat(file); err != nil {
+				p.warnf("scenario %q, viewport %q: no reference image %s", sc.Label, vpLabel, file)
+				continue
+			}
+			size := Size{Width: vp.Width, Height: vp.Height}
+			p.addStory(Story{Name: sc.Label, URL: storyPath(sc.URL), Selector: selector}, size)
+			p.Baselines = append(p.Baselines, Baseline{Src: file, Name: sc.Label, Width: size.Width, Height: size.Height})
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,99 @@
+package importer
+
+import (
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// Percy und Chromatic legen Baselines nur in ihrer Cloud ab und haben kein
+// Exportformat. Das Layout ist qsnaps eigene Konvention, in die der Nutzer
+// die Bilder (z.B. per API heruntergeladen) selbst ablegt: pro Snapshot ein
+// Verzeichnis, ein Bild pro Breite: <snapshot>/<width>.png oder
+// <snapshot>/<browser>_<width>.png
+var hostedFile = regexp.MustCompile(`^(?:([a-z]+)_)?(\d+)\.png$`)
+
+// importHosted maps Percy or Chromatic screenshots, laid out as described at
+// hostedFile, to stories of the Storybook index. A snapshot directory matches a story by id or by its
+// "Title: Story" / "Title/Story" name; the height is the image's height.
+// Without an index (Chromatic only) the directory names are taken as ids.
+func importHosted(tool, dir, indexPath string, needIndex bool) (*Plan, error) {
+	stories, err := readStorybookIndex(indexPath)
+	if err != nil && needIndex {
+		return nil, fmt.Errorf("%s snapshot names can only be mapped with Storybook's index.json (build storybook or pass -storybookIndex): %w", tool, err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Plan{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		snapDir := filepath.Join(dir, e.Name())
+		name, id, ok := matchHosted(e.Name(), stories)
+		if !ok {
+			p.warnf("%s: no story in the Storybook index matches this %s snapshot", snapDir, tool)
+			continue
+		}
+
+		files, err := os.ReadDir(snapDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			path := filepath.Join(snapDir, f.Name())
+			m := hostedFile.FindStringSubmatch(f.Name())
+			if f.IsDir() || m == nil {
+				p.warnf("%s: not a <width>.png or <browser>_<width>.png screenshot", path)
+				continue
+			}
+			if browser := m[1]; browser != "" && browser != "chrome" && browser != "chromium" {
+				p.warnf("%s: %s screenshots are not imported, qsnap captures with Chrome", path, browser)
+				continue
+			}
+			w, _ := strconv.Atoi(m[2])
+			h, err := pngHeight(path)
+			if err != nil {
+				p.warnf("%s: %v", path, err)
+				continue
+			}
+			size := Size{Width: w, Height: h}
+			p.addStory(Story{Name: name, URL: "/iframe.html?id=" + id}, size)
+			p.Baselines = append(p.Baselines, Baseline{Src: path, Name: name, Width: w, Height: h})
+		}
+	}
+	return p, nil
+}
+
+func matchHosted(dirName string, stories []indexedStory) (name, id string, ok bool) {
+	if stories == nil {
+		return dirName, dirName, true
+	}
+	for _, s := range stories {
+		if s.id == dirName || lokiSanitize(s.title, s.name) == lokiSanitize(dirName) {
+			return lokiSanitize(s.title, s.name), s.id, true
+		}
+	}
+	return "", "", false
+}
+
+// pngHeight liest nur den Header
+func pngHeight(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	cfg, err := png.DecodeConfig(f)
+	if err != nil {
+		return 0, err
+	}
+	return cfg.Height, nil
+}
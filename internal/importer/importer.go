@@ -0,0 +1,147 @@
+package importer
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// Sources are the layouts Import understands.
+var Sources = []string{"osnap", "loki", "backstop", "percy", "chromatic"}
+
+type Size struct {
+	Width  int `yaml:"width"`
+	Height int `yaml:"height"`
+}
+
+// Story is written to the generated story config file.
+type Story struct {
+	Name     string `yaml:"name"`
+	URL      string `yaml:"url"`
+	Selector string `yaml:"selector,omitempty"`
+	Sizes    []Size `yaml:"sizes"`
+}
+
+// Baseline is one reference image and the case it belongs to.
+type Baseline struct {
+	Src    string
+	Name   string
+	Width  int
+	Height int
+}
+
+type Plan struct {
+	Stories   []Story
+	Baselines []Baseline
+	// Warnings sind Dateien oder Einträge, die nicht übernommen werden konnten
+	Warnings []string
+}
+
+type Options struct {
+	// Source is the directory (osnap, loki, percy, chromatic) or
+	// backstop.json file to read.
+	Source string
+	// StorybookIndex is Storybook's index.json, needed by loki and percy to
+	// map filenames back to story ids.
+	StorybookIndex string
+	// Dir is the Storybook project; percy and chromatic look for the index
+	// below it when StorybookIndex is empty.
+	Dir string
+}
+
+func Import(from string, opts Options) (*Plan, error) {
+	switch from {
+	case "osnap":
+		return importOsnap(opts.Source)
+	case "loki":
+		return importLoki(opts.Source, opts.StorybookIndex)
+	case "backstop":
+		return importBackstop(opts.Source)
+	case "percy", "chromatic":
+		index := opts.StorybookIndex
+		if index == "" {
+			index = defaultIndex(opts.Dir)
+		}
+		return importHosted(from, opts.Source, index, from == "percy")
+	}
+	return nil, fmt.Errorf("unknown import source %q: expected one of %v", from, Sources)
+}
+
+// addStory merges sizes of stories with the same name so each story ends up
+// once in the generated config.
+func (p *Plan) addStory(s Story, size Size) {
+	for i := range p.Stories {
+		if p.Stories[i].Name == s.Name && p.Stories[i].URL == s.URL {
+			for _, sz := range p.Stories[i].Sizes {
+				if sz == size {
+					return
+				}
+			}
+			p.Stories[i].Sizes = append(p.Stories[i].Sizes, size)
+			return
+		}
+	}
+	s.Sizes = []Size{size}
+	p.Stories = append(p.Stories, s)
+}
+
+func (p *Plan) warnf(format string, args ...any) {
+	p.Warnings = append(p.Warnings, fmt.Sprintf(format, args...))
+}
+
+// osnap legt Baselines genauso ab wie qsnap: <name>_<w>x<h>.png
+var osnapName = regexp.MustCompile(`^(.+)_(\d+)x(\d+)\.png$`)
+
+func importOsnap(dir string) (*Plan, error) {
+	baseDir := filepath.Join(dir, "__base_images__")
+	if _, err := os.Stat(baseDir); err != nil {
+		baseDir = dir
+	}
+
+	p := &Plan{}
+	err := filepath.WalkDir(baseDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".png" {
+			return err
+		}
+		m := osnapName.FindStringSubmatch(d.Name())
+		if m == nil {
+			p.warnf("%s: not a <name>_<width>x<height>.png baseline", path)
+			return nil
+		}
+		w, _ := strconv.Atoi(m[2])
+		h, _ := strconv.Atoi(m[3])
+		p.Baselines = append(p.Baselines, Baseline{Src: path, Name: m[1], Width: w, Height: h})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// storyPath turns an absolute story URL into the path qsnap appends to the
+// Storybook host (or baseUrl).
+func storyPath(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return raw
+	}
+	path := u.EscapedPath()
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return path
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,154 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type lokiConfig struct {
+	Loki struct {
+		Reference      string `json:"reference"`
+		Configurations map[string]struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		} `json:"configurations"`
+	} `json:"loki"`
+}
+
+// storybookIndex is the part of index.json (Storybook 7+) or stories.json
+// (Storybook 6) that is needed to map loki filenames to story ids.
+type storybookIndex struct {
+	Entries map[string]struct {
+		ID    string `json:"id"`
+		Title string `json:"title"`
+		Kind  string `json:"kind"`
+		Name  string `json:"name"`
+		Type  string `json:"type"`
+	} `json:"entries"`
+	Stories map[string]struct {
+		ID   string `json:"id"`
+		Kind string `json:"kind"`
+		Name string `json:"name"`
+	} `json:"stories"`
+}
+
+var lokiUnsafe = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+// lokiSanitize ahmt nach, wie loki Konfiguration, Kind und Story zu einem
+// Dateinamen zusammensetzt
+func lokiSanitize(parts ...string) string {
+	return strings.Trim(lokiUnsafe.ReplaceAllString(strings.Join(parts, " "), "_"), "_")
+}
+
+func importLoki(dir, indexPath string) (*Plan, error) {
+	var cfg lokiConfig
+	b, err := os.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("package.json: %w", err)
+	}
+	if len(cfg.Loki.Configurations) == 0 {
+		return nil, errors.New("package.json has no loki.configurations")
+	}
+
+	if indexPath == "" {
+		indexPath = defaultIndex(dir)
+	}
+	stories, err := readStorybookIndex(indexPath)
+	if err != nil {
+		return nil, fmt.Errorf("loki filenames can only be mapped with Storybook's index.json (build storybook or pass -storybookIndex): %w", err)
+	}
+
+	refDir := cfg.Loki.Reference
+	if refDir == "" {
+		refDir = filepath.Join(".loki", "reference")
+	}
+	if !filepath.IsAbs(refDir) {
+		refDir = filepath.Join(dir, refDir)
+	}
+
+	files, err := filepath.Glob(filepath.Join(refDir, "*.png"))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Plan{}
+	for _, f := range files {
+		base := strings.TrimSuffix(filepath.Base(f), ".png")
+		matched := false
+		for _, name := range sortedKeys(cfg.Loki.Configurations) {
+			conf := cfg.Loki.Configurations[name]
+			for _, s := range stories {
+				if lokiSanitize(name, s.title, s.name) != base {
+					continue
+				}
+				if conf.Width <= 0 || conf.Height <= 0 {
+					p.warnf("%s: loki configuration %q has no width/height", f, name)
+					matched = true
+					break
+				}
+				storyName := lokiSanitize(s.title, s.name)
+				size := Size{Width: conf.Width, Height: conf.Height}
+				p.addStory(Story{Name: storyName, URL: "/iframe.html?id=" + s.id}, size)
+				p.Baselines = append(p.Baselines, Baseline{Src: f, Name: storyName, Width: size.Width, Height: size.Height})
+				matched = true
+				break
+			}
+			if matched {
+				break
+			}
+		}
+		if !matched {
+			p.warnf("%s: no story in the Storybook index matches this file", f)
+		}
+	}
+	return p, nil
+}
+
+// defaultIndex is storybook-static/index.json below dir, or stories.json of
+// Storybook 6.
+func defaultIndex(dir string) string {
+	path := filepath.Join(dir, "storybook-static", "index.json")
+	if _, err := os.Stat(path); err != nil {
+		return filepath.Join(dir, "storybook-static", "stories.json")
+	}
+	return path
+}
+
+type indexedStory struct{ id, title, name string }
+
+func readStorybookIndex(path string) ([]indexedStory, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var idx storybookIndex
+	if err := json.Unmarshal(b, &idx); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var out []indexedStory
+	for _, k := range sortedKeys(idx.Entries) {
+		e := idx.Entries[k]
+		if e.Type != "" && e.Type != "story" {
+			continue
+		}
+		title := e.Title
+		if title == "" {
+			title = e.Kind
+		}
+		out = append(out, indexedStory{id: e.ID, title: title, name: e.Name})
+	}
+	for _, k := range sortedKeys(idx.Stories) {
+		s := idx.Stories[k]
+		out = append(out, indexedStory{id: s.ID, title: s.Kind, name: s.Name})
+	}
+	return out, nil
+}
@@ -0,0 +1,127 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/maxischmaxi/qsnap/internal/config"
+	"github.com/maxischmaxi/qsnap/internal/report"
+)
+
+// maxCases begrenzt die Liste, Slack kürzt lange Nachrichten sonst selbst
+const maxCases = 10
+
+// Message is a Slack incoming-webhook payload; Mattermost, Rocket.Chat and
+// Discord's /slack endpoint accept the same format.
+type Message struct {
+	Text   string  `json:"text"`
+	Blocks []Block `json:"blocks,omitempty"`
+}
+
+type Block struct {
+	Type string `json:"type"`
+	Text *Text  `json:"text,omitempty"`
+}
+
+type Text struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Should reports whether n wants a notification for a run with exit code
+// code.
+func Should(n *config.Notify, code int) bool {
+	if n == nil || n.Webhook == "" {
+		return false
+	}
+	return n.When == "always" || code != report.ExitOK
+}
+
+// Build renders the summary of r: totals, the cases that need attention and
+// a link to the report.
+func Build(r report.Report, noBaselineAs, reportURL string) Message {
+	icon := ":white_check_mark:"
+	if report.ExitCode(r, noBaselineAs) != report.ExitOK {
+		icon = ":x:"
+	}
+	passed := r.Passed + r.CachedPass + r.Created + r.Updated + r.Flaky
+	summary := fmt.Sprintf("%s qsnap: %d/%d passed, %d failed, %d new, %d errors", icon, passed, r.Total, r.Failed+r.Unstable, r.NoBaseline, r.Errored+r.ImageTooLarge)
+	if r.Flaky > 0 {
+		summary += fmt.Sprintf(", %d flaky", r.Flaky)
+	}
+
+	var lines []string
+	n := 0
+	for _, c := range r.Cases {
+		switch c.Status {
+		case "fail", "unstable", "flaky", "no-baseline", "error", "image-too-large":
+		default:
+			continue
+		}
+		if n == maxCases {
+			lines = append(lines, "… and more")
+			break
+		}
+		line := fmt.Sprintf("• `%s` %s", c.Label(), c.Status)
+		if px, ok := c.Pixel(); ok && c.Status == "fail" {
+			line += fmt.Sprintf(" (%.4f%%)", px.RatioDiff*100)
+		}
+		if c.Error != "" {
+			line += ": " + c.Error
+		}
+		lines = append(lines, line)
+		n++
+	}
+
+	msg := Message{Text: summary, Blocks: []Block{{Type: "section", Text: &Text{Type: "mrkdwn", Text: "*" + summary + "*"}}}}
+	if len(lines) > 0 {
+		msg.Blocks = append(msg.Blocks, Block{Type: "section", Text: &Text{Type: "mrkdwn", Text: strings.Join(lines, "\n")}})
+	}
+	if reportURL != "" {
+		msg.Blocks = append(msg.Blocks, Block{Type: "section", Text: &Text{Type: "mrkdwn", Text: "<" + reportURL + "|Open the report>"}})
+	}
+	return msg
+}
+
+// Send posts the summary of r to n's webhook. ${VAR} in the webhook and
+// report URL is expanded, so secrets can stay out of the config.
+func Send(ctx context.Context, n *config.Notify, r report.Report, noBaselineAs string) error {
+	body, err := json.Marshal(Build(r, noBaselineAs, os.ExpandEnv(n.ReportURL)))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, os.ExpandEnv(n.Webhook), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook: %w", hideURL(err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook: POST failed: %w", hideURL(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		// die URL enthält meist das Token und bleibt deshalb aus der Meldung
+		return fmt.Errorf("webhook: %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// hideURL nimmt die Webhook-URL aus einem *url.Error, sie enthält meist das
+// Token und landet sonst im CI-Log
+func hideURL(err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return ue.Err
+	}
+	return err
+}
@@ -0,0 +1,260 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/maxischmaxi/qsnap/internal/report"
+	"github.com/maxischmaxi/qsnap/internal/tools"
+)
+
+// Protocol version sent in the init message.
+const Version = 1
+
+// Hooks a plugin can subscribe to in its init reply.
+const (
+	HookCaseFinished = "onCaseFinished"
+	HookRunFinished  = "onRunFinished"
+	HookCompare      = "compare"
+)
+
+// request is one line of JSON written to the plugin's stdin. The plugin
+// answers every request with exactly one line of JSON on stdout.
+type request struct {
+	Hook     string             `json:"hook"`
+	Version  int                `json:"version,omitempty"`
+	Case     *report.CaseResult `json:"case,omitempty"`
+	Report   *report.Report     `json:"report,omitempty"`
+	Baseline string             `json:"baseline,omitempty"`
+	Actual   string             `json:"actual,omitempty"`
+}
+
+type response struct {
+	Hooks []string `json:"hooks,omitempty"`
+	Error string   `json:"error,omitempty"`
+	// nur für compare: leerer Status = Ergebnis von qsnap übernehmen
+	Status  string `json:"status,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Verdict is a comparator plugin's decision for a case.
+type Verdict struct {
+	Plugin  string
+	Status  string // pass | fail
+	Message string
+}
+
+type Plugin struct {
+	Name  string
+	hooks []string
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	out    *bufio.Scanner
+	broken bool
+}
+
+// callTimeout limits how long qsnap waits for a single reply.
+const callTimeout = 30 * time.Second
+
+// Start launches the plugin command line in dir and performs the init handshake.
+func Start(command, dir string) (*Plugin, error) {
+	parts, err := tools.SplitCommand(command)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %w", command, err)
+	}
+	if len(parts) == 0 {
+		return nil, errors.New("plugin: empty command")
+	}
+
+	cmd := exec.Command(parts[0], parts[1:]...)
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("plugin %s: %w", command, err)
+	}
+
+	out := bufio.NewScanner(stdout)
+	out.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	p := &Plugin{Name: command, cmd: cmd, stdin: stdin, out: out}
+
+	resp, err := p.call(request{Hook: "init", Version: Version})
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+	p.hooks = resp.Hooks
+	return p, nil
+}
+
+func (p *Plugin) Wants(hook string) bool {
+	return p != nil && slices.Contains(p.hooks, hook)
+}
+
+func (p *Plugin) call(req request) (response, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var resp response
+	if p.broken {
+		return resp, fmt.Errorf("plugin %s: not running", p.Name)
+	}
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		return resp, err
+	}
+
+	// die Goroutine dekodiert in eine eigene Variable, nach einem Timeout kann
+	// sie noch laufen
+	type reply struct {
+		resp response
+		err  error
+	}
+	done := make(chan reply, 1)
+	go func() {
+		if _, err := p.stdin.Write(append(b, '\n')); err != nil {
+			done <- reply{err: err}
+			return
+		}
+		if !p.out.Scan() {
+			err := p.out.Err()
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			done <- reply{err: err}
+			return
+		}
+		var r reply
+		r.err = json.Unmarshal(p.out.Bytes(), &r.resp)
+		done <- r
+	}()
+
+	select {
+	case r := <-done:
+		resp, err = r.resp, r.err
+	case <-time.After(callTimeout):
+		err = fmt.Errorf("no reply within %s", callTimeout)
+	}
+	if err != nil {
+		// Protokoll ist nicht mehr synchron, Plugin nicht weiter benutzen
+		p.broken = true
+		_ = p.cmd.Process.Kill()
+		return resp, fmt.Errorf("plugin %s: %s: %w", p.Name, req.Hook, err)
+	}
+	if resp.Error != "" {
+		return resp, fmt.Errorf("plugin %s: %s: %s", p.Name, req.Hook, resp.Error)
+	}
+	return resp, nil
+}
+
+// Close ends stdin so the plugin can exit on its own and kills it otherwise.
+func (p *Plugin) Close() {
+	_ = p.stdin.Close()
+	done := make(chan struct{})
+	go func() {
+		_ = p.cmd.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		_ = p.cmd.Process.Kill()
+		<-done
+	}
+}
+
+// Set is the list of plugins configured for a run. A nil Set does nothing.
+type Set []*Plugin
+
+// StartAll starts every command; already started plugins are closed if one fails.
+func StartAll(commands []string, dir string) (Set, error) {
+	var s Set
+	for _, c := range commands {
+		p, err := Start(c, dir)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s = append(s, p)
+	}
+	return s, nil
+}
+
+func (s Set) Close() {
+	for _, p := range s {
+		p.Close()
+	}
+}
+
+// CaseFinished notifies plugins about a finished case. Errors are returned
+// joined so the caller can log them; they never change the case result.
+func (s Set) CaseFinished(c report.CaseResult) error {
+	var errs []error
+	for _, p := range s {
+		if p.Wants(HookCaseFinished) {
+			if _, err := p.call(request{Hook: HookCaseFinished, Case: &c}); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s Set) RunFinished(r report.Report) error {
+	var errs []error
+	for _, p := range s {
+		if p.Wants(HookRunFinished) {
+			if _, err := p.call(request{Hook: HookRunFinished, Report: &r}); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Compare asks comparator plugins for a verdict on a case that qsnap already
+// compared. The first plugin that returns a status wins; nil means keep
+// qsnap's own result.
+func (s Set) Compare(c report.CaseResult, baseline, actual string) (*Verdict, error) {
+	for _, p := range s {
+		if !p.Wants(HookCompare) {
+			continue
+		}
+		resp, err := p.call(request{Hook: HookCompare, Case: &c, Baseline: baseline, Actual: actual})
+		if err != nil {
+			return nil, err
+		}
+		switch resp.Status {
+		case "":
+			continue
+		case "pass", "fail":
+			return &Verdict{Plugin: p.Name, Status: resp.Status, Message: resp.Message}, nil
+		default:
+			return nil, fmt.Errorf("plugin %s: compare: invalid status %q", p.Name, resp.Status)
+		}
+	}
+	return nil, nil
+}
+
+// Comparing reports whether any plugin wants to see compare requests.
+func (s Set) Comparing() bool {
+	return slices.ContainsFunc(s, func(p *Plugin) bool { return p.Wants(HookCompare) })
+}
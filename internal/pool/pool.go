@@ -1,10 +1,37 @@
 package pool
 
-import "sync"
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
 
 type Pool struct {
 	wg  sync.WaitGroup
 	sem chan struct{}
+
+	mu   sync.Mutex
+	errs []error
+}
+
+// PanicError is a panic recovered from a task, with the stack where it
+// happened.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n%s", e.Value, e.Stack)
+}
+
+// Recover turns a panic of the calling function into a *PanicError in err.
+// It must be deferred directly: defer pool.Recover(&err).
+func Recover(err *error) {
+	if v := recover(); v != nil {
+		*err = &PanicError{Value: v, Stack: debug.Stack()}
+	}
 }
 
 func New(concurrency int) *Pool {
@@ -16,16 +43,31 @@ func New(concurrency int) *Pool {
 	}
 }
 
-func (p *Pool) Go(fn func()) {
+// Go runs fn once a slot is free. Errors and panics of fn are collected for
+// Wait instead of crashing the process.
+func (p *Pool) Go(fn func() error) {
 	p.wg.Add(1)
 	p.sem <- struct{}{}
 	go func() {
 		defer p.wg.Done()
 		defer func() { <-p.sem }()
-		fn()
+		if err := run(fn); err != nil {
+			p.mu.Lock()
+			p.errs = append(p.errs, err)
+			p.mu.Unlock()
+		}
 	}()
 }
 
-func (p *Pool) Wait() {
+func run(fn func() error) (err error) {
+	defer Recover(&err)
+	return fn()
+}
+
+// Wait blocks until all tasks are done and returns their errors joined.
+func (p *Pool) Wait() error {
 	p.wg.Wait()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return errors.Join(p.errs...)
 }
@@ -0,0 +1,127 @@
+package procs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// Entry describes a process started by qsnap. Owner is the PID of the qsnap
+// run that started it; once the owner is gone the process is an orphan.
+type Entry struct {
+	Kind  string `json:"kind"` // chrome | firefox | server
+	PID   int    `json:"pid"`
+	Owner int    `json:"owner"`
+
+	path string
+}
+
+func Dir() string {
+	return filepath.Join(os.TempDir(), "qsnap-pids")
+}
+
+// Write records a PID file for a process owned by the current qsnap run.
+func Write(kind string, pid int) error {
+	if err := os.MkdirAll(Dir(), 0o755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(Entry{Kind: kind, PID: pid, Owner: os.Getpid()})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(pidPath(kind, pid), b, 0o644)
+}
+
+func Remove(kind string, pid int) {
+	_ = os.Remove(pidPath(kind, pid))
+}
+
+func pidPath(kind string, pid int) string {
+	return filepath.Join(Dir(), fmt.Sprintf("%s-%d.pid", kind, pid))
+}
+
+func List() ([]Entry, error) {
+	files, err := filepath.Glob(filepath.Join(Dir(), "*.pid"))
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Entry
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(b, &e); err != nil {
+			continue
+		}
+		e.path = f
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// Cleanup kills processes whose owning qsnap run no longer exists and removes
+// their PID files. It returns the orphans it found. With dryRun nothing is
+// killed or removed.
+func Cleanup(dryRun bool) ([]Entry, error) {
+	entries, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []Entry
+	var errs error
+	for _, e := range entries {
+		if Alive(e.Owner) {
+			continue
+		}
+		orphans = append(orphans, e)
+		if dryRun {
+			continue
+		}
+
+		// die PID könnte inzwischen an einen fremden Prozess vergeben sein
+		if (e.Kind == "chrome" || e.Kind == "firefox") && e.PID != e.Owner && Alive(e.PID) && looksLikeBrowser(e.PID) {
+			if p, err := os.FindProcess(e.PID); err == nil {
+				if err := p.Kill(); err != nil {
+					errs = errors.Join(errs, fmt.Errorf("kill %d: %w", e.PID, err))
+				}
+			}
+		}
+		_ = os.Remove(e.path)
+	}
+	return orphans, errs
+}
+
+func Alive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		// FindProcess schlägt unter Windows fehl, wenn der Prozess nicht existiert
+		return true
+	}
+	return p.Signal(syscall.Signal(0)) == nil
+}
+
+// looksLikeBrowser checks the command line where /proc is available and
+// trusts the PID file elsewhere.
+func looksLikeBrowser(pid int) bool {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return true
+	}
+	cmd := strings.ToLower(string(b))
+	return strings.Contains(cmd, "chrom") || strings.Contains(cmd, "edge") || strings.Contains(cmd, "firefox")
+}
@@ -0,0 +1,148 @@
+// Package remote distributes cases from a coordinator to qsnap workers on
+// other machines. The contract is worker.proto; the types here are its
+// messages in the proto3 JSON mapping.
+//
+// The coordinator POSTs a Batch as JSON to BatchPath, and the worker answers
+// 200 with application/x-ndjson, one Result per line as soon as a case is
+// finished. A stream that ends early leaves the remaining cases to the
+// coordinator, which sends them to another worker. With a token, the request
+// carries it as "Authorization: Bearer <token>".
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/maxischmaxi/qsnap/internal/report"
+)
+
+// BatchPath is where a worker accepts batches.
+const BatchPath = "/v1/batches"
+
+// CaseRef identifies a case by its story file, relative to the input
+// directory, and its browser and label.
+type CaseRef struct {
+	// Source hat Vorwärts-Slashes, auch auf Windows
+	Source  string `json:"source"`
+	Browser string `json:"browser,omitempty"`
+	// Label ist der Name im Report, z.B. "Button_dark_1280x720"
+	Label string `json:"label"`
+}
+
+// Batch is a set of cases a worker runs for the coordinator.
+type Batch struct {
+	ID    string    `json:"id"`
+	Cases []CaseRef `json:"cases"`
+	// UpdateBaselines ist "", "missing" oder "all" wie -updateBaselines
+	UpdateBaselines string `json:"updateBaselines,omitempty"`
+	EmitNew         bool   `json:"emitNew,omitempty"`
+}
+
+// Result is one finished case of a batch. Files holds what the coordinator
+// copies into its own snapshot directory, see worker.proto.
+type Result struct {
+	// Index ist die Position des Falls in Batch.Cases
+	Index int `json:"index"`
+	// Case ist der Fall wie in report.json, Pfade beziehen sich auf den Worker
+	Case  report.CaseResult `json:"case"`
+	Files map[string][]byte `json:"files,omitempty"`
+}
+
+// Handler serves batches by calling run, which emits the result of every
+// case. With a token, requests need it as bearer token.
+func Handler(token string, run func(ctx context.Context, b Batch, emit func(Result)) error) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != BatchPath {
+			http.NotFound(w, r)
+			return
+		}
+		if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			http.Error(w, "missing or wrong bearer token", http.StatusUnauthorized)
+			return
+		}
+		var b Batch
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+			http.Error(w, "invalid batch: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch b.UpdateBaselines {
+		case "", "missing", "all":
+		default:
+			http.Error(w, fmt.Sprintf("invalid updateBaselines %q: expected missing or all", b.UpdateBaselines), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		flusher, _ := w.(http.Flusher)
+		var mu sync.Mutex
+		enc := json.NewEncoder(w)
+		emit := func(res Result) {
+			mu.Lock()
+			defer mu.Unlock()
+			if enc.Encode(res) == nil && flusher != nil {
+				flusher.Flush()
+			}
+		}
+		// ein Fehler beendet nur den Stream, der Koordinator verteilt den Rest neu
+		_ = run(r.Context(), b, emit)
+	})
+}
+
+// Run sends b to the worker at addr ("host:port" or a URL) and calls onResult
+// for every result the worker streams back. It returns an error if the
+// worker can't be reached or the stream breaks; results received until then
+// have already been passed to onResult.
+func Run(ctx context.Context, addr, token string, b Batch, onResult func(Result)) error {
+	body, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(addr, "/")+BatchPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("worker %s: %s: %s", addr, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	sc := bufio.NewScanner(resp.Body)
+	// Results tragen PNGs, eine Zeile kann viele MB groß sein
+	sc.Buffer(make([]byte, 0, 1<<20), 1<<30)
+	for sc.Scan() {
+		var res Result
+		if err := json.Unmarshal(sc.Bytes(), &res); err != nil {
+			return fmt.Errorf("worker %s: %w", addr, err)
+		}
+		if res.Index < 0 || res.Index >= len(b.Cases) {
+			return fmt.Errorf("worker %s: result for case %d of a batch of %d", addr, res.Index, len(b.Cases))
+		}
+		onResult(res)
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("worker %s: %w", addr, err)
+	}
+	return nil
+}
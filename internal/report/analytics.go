@@ -0,0 +1,90 @@
+package report
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/maxischmaxi/qsnap/internal/diff"
+)
+
+// obere Grenzen der Buckets für ratioDiff, grob logarithmisch
+var histogramBounds = []float64{0, 0.0001, 0.001, 0.01, 0.05, 0.1, 0.25, 1}
+
+type HistogramBucket struct {
+	Max   float64 `json:"max"` // inclusive upper bound of ratioDiff
+	Count int     `json:"count"`
+}
+
+type CaseMetric struct {
+	Name      string  `json:"name"`
+	Width     int     `json:"width"`
+	Height    int     `json:"height"`
+	RatioDiff float64 `json:"ratioDiff"`
+	// Threshold ist der erlaubte Anteil; mit maxDiffPixels dessen Anteil an den verglichenen Pixeln
+	Threshold float64 `json:"threshold"`
+	Margin    float64 `json:"margin"` // threshold - ratioDiff, negative for failures
+}
+
+type Analytics struct {
+	Histogram     []HistogramBucket `json:"histogram"`
+	NearMisses    []CaseMetric      `json:"nearMisses"`
+	WorstFailures []CaseMetric      `json:"worstFailures"`
+}
+
+// Analyze summarizes the pixel diff ratios of all compared cases and picks
+// the top n passing cases closest to their threshold and the n worst failures.
+func Analyze(cases []CaseResult, n int) *Analytics {
+	a := &Analytics{}
+	for _, b := range histogramBounds {
+		a.Histogram = append(a.Histogram, HistogramBucket{Max: b})
+	}
+
+	var passed, failed []CaseMetric
+	for _, c := range cases {
+		px, ok := c.Pixel()
+		if !ok {
+			continue
+		}
+
+		for i := range a.Histogram {
+			if px.RatioDiff <= a.Histogram[i].Max {
+				a.Histogram[i].Count++
+				break
+			}
+		}
+
+		limit := c.Threshold
+		if c.MaxDiffPixels != nil && px.Pixels > 0 {
+			limit = float64(*c.MaxDiffPixels) / float64(px.Pixels)
+		}
+		m := CaseMetric{
+			Name:      c.Name,
+			Width:     c.Width,
+			Height:    c.Height,
+			RatioDiff: px.RatioDiff,
+			Threshold: limit,
+			Margin:    limit - px.RatioDiff,
+		}
+		switch c.Status {
+		case "pass":
+			passed = append(passed, m)
+		case "fail":
+			failed = append(failed, m)
+		}
+	}
+
+	slices.SortStableFunc(passed, func(x, y CaseMetric) int { return cmp.Compare(x.Margin, y.Margin) })
+	slices.SortStableFunc(failed, func(x, y CaseMetric) int { return cmp.Compare(y.RatioDiff, x.RatioDiff) })
+
+	a.NearMisses = passed[:min(n, len(passed))]
+	a.WorstFailures = failed[:min(n, len(failed))]
+	return a
+}
+
+// Pixel returns the pixel diff result of a compared case.
+func (c CaseResult) Pixel() (diff.PixelResult, bool) {
+	if c.PixelDiff == nil {
+		return diff.PixelResult{}, false
+	}
+	return *c.PixelDiff, true
+}
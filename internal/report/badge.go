@@ -0,0 +1,37 @@
+package report
+
+import (
+	"fmt"
+	"os"
+)
+
+const badgeTemplate = `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="qsnap: %[3]s">
+<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[4]d" height="20" fill="%[5]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[6]d" y="14">qsnap</text><text x="%[7]d" y="14">%[3]s</text>
+</g>
+</svg>
+`
+
+// WriteBadge writes a shields.io style SVG badge with passed/total. The badge
+// is green when ExitCode would return ExitOK under noBaselineAs, red otherwise.
+func WriteBadge(path string, r Report, noBaselineAs string) error {
+	passed := r.Passed + r.CachedPass + r.Created + r.Updated + r.Flaky
+	if noBaselineAs == "pass" {
+		passed += r.NoBaseline
+	}
+	text := fmt.Sprintf("%d/%d passed", passed, r.Total)
+
+	color := "#4c1"
+	if ExitCode(r, noBaselineAs) != ExitOK {
+		color = "#e05d44"
+	}
+
+	// grobe Breite für Verdana 11px, reicht für Ziffern und ASCII
+	labelW := 6*len("qsnap") + 10
+	valueW := 7*len(text) + 10
+	svg := fmt.Sprintf(badgeTemplate, labelW+valueW, labelW, text, valueW, color, labelW/2, labelW+valueW/2)
+	return os.WriteFile(path, []byte(svg), 0o644)
+}
@@ -0,0 +1,59 @@
+package report
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// BaselineChange is a baseline that the run wrote itself (-updateBaselines).
+type BaselineChange struct {
+	Label    string `json:"label"`
+	Status   string `json:"status"` // created | updated
+	Baseline string `json:"baseline"`
+	// RatioDiff ist der Pixelanteil, um den sich eine aktualisierte Baseline geändert hat
+	RatioDiff float64 `json:"ratioDiff,omitempty"`
+}
+
+// BaselineChanges lists created and updated baselines, created first.
+func BaselineChanges(cases []CaseResult) []BaselineChange {
+	var created, updated []BaselineChange
+	for _, c := range cases {
+		switch c.Status {
+		case "created":
+			created = append(created, BaselineChange{Label: c.Label(), Status: c.Status, Baseline: c.Baseline})
+		case "updated":
+			ch := BaselineChange{Label: c.Label(), Status: c.Status, Baseline: c.Baseline}
+			if px, ok := c.Pixel(); ok {
+				ch.RatioDiff = px.RatioDiff
+			}
+			updated = append(updated, ch)
+		}
+	}
+	return append(created, updated...)
+}
+
+// BaselineComment renders the baseline changes as a Markdown block for a PR
+// comment. It is empty if the run changed no baselines.
+func BaselineComment(r Report) string {
+	if len(r.BaselineChanges) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "### qsnap: %d baselines created, %d updated\n\n", r.Created, r.Updated)
+	b.WriteString("| Status | Case | Changed pixels | Baseline |\n|---|---|---|---|\n")
+	for _, ch := range r.BaselineChanges {
+		changed := "-"
+		if ch.Status == "updated" {
+			changed = fmt.Sprintf("%.4f%%", ch.RatioDiff*100)
+		}
+		fmt.Fprintf(&b, "| %s | `%s` | %s | `%s` |\n", ch.Status, ch.Label, changed, ch.Baseline)
+	}
+	return b.String()
+}
+
+// WriteBaselineComment writes BaselineComment to path. Without changes the
+// file is written empty, so CI can skip posting on an empty file.
+func WriteBaselineComment(path string, r Report) error {
+	return os.WriteFile(path, []byte(BaselineComment(r)), 0o644)
+}
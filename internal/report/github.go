@@ -0,0 +1,109 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// GitHubAnnotations writes GitHub Actions workflow commands for r: one
+// ::group:: per story config file listing its cases, and an ::error (or
+// ::warning) annotation on the config file for every case that needs
+// attention. workspace is $GITHUB_WORKSPACE; file paths are relative to it.
+func GitHubAnnotations(w io.Writer, r Report, noBaselineAs, workspace string) {
+	var order []string
+	groups := map[string][]CaseResult{}
+	for _, c := range r.Cases {
+		file := relFile(workspace, c.Source)
+		if _, ok := groups[file]; !ok {
+			order = append(order, file)
+		}
+		groups[file] = append(groups[file], c)
+	}
+
+	for _, file := range order {
+		title := file
+		if title == "" {
+			title = "(unknown story file)"
+		}
+		fmt.Fprintf(w, "::group::%s\n", escapeData(title))
+		for _, c := range groups[file] {
+			line := fmt.Sprintf("%-15s %s", c.Status, c.Label())
+			if px, ok := c.Pixel(); ok && c.Status == "fail" {
+				line += fmt.Sprintf(" %.4f%%", px.RatioDiff*100)
+			}
+			fmt.Fprintln(w, line)
+		}
+		fmt.Fprintln(w, "::endgroup::")
+	}
+
+	for _, file := range order {
+		for _, c := range groups[file] {
+			level, msg := annotation(c, noBaselineAs)
+			if level == "" {
+				continue
+			}
+			props := "title=" + escapeProperty("qsnap: "+c.Label())
+			if file != "" {
+				props = "file=" + escapeProperty(file) + "," + props
+			}
+			fmt.Fprintf(w, "::%s %s::%s\n", level, props, escapeData(msg))
+		}
+	}
+}
+
+func annotation(c CaseResult, noBaselineAs string) (level, msg string) {
+	switch c.Status {
+	case "fail":
+		msg = "snapshot differs from baseline"
+		if px, ok := c.Pixel(); ok {
+			msg = fmt.Sprintf("snapshot differs from baseline: %.4f%% of pixels (threshold %.4f%%)", px.RatioDiff*100, c.Threshold*100)
+			if c.MaxDiffPixels != nil {
+				msg = fmt.Sprintf("snapshot differs from baseline: %d pixels (maxDiffPixels %d)", px.DiffPixels, *c.MaxDiffPixels)
+			}
+			if len(px.Boxes) > 0 {
+				bx := px.Boxes[0]
+				msg += fmt.Sprintf(" in %d regions, largest at %d,%d (%d×%d)", px.Regions, bx.X, bx.Y, bx.Width, bx.Height)
+			}
+		}
+		if c.ComparedBy != "" {
+			msg += fmt.Sprintf(", decided by %s: %s", c.ComparedBy, c.CompareMessage)
+		}
+		return "error", msg
+	case "unstable":
+		return "error", "capture did not stabilize and differs from baseline"
+	case "flaky":
+		return "warning", fmt.Sprintf("flaky: failed, then passed on attempt %d", c.Attempts)
+	case "error", "image-too-large":
+		return "error", c.Error
+	case "no-baseline":
+		if noBaselineAs == "pass" {
+			return "warning", "no baseline yet"
+		}
+		return "error", "no baseline yet"
+	}
+	if len(c.Warnings) > 0 {
+		return "warning", strings.Join(c.Warnings, "; ")
+	}
+	return "", ""
+}
+
+func relFile(workspace, path string) string {
+	if path == "" || workspace == "" {
+		return filepath.ToSlash(path)
+	}
+	if rel, err := filepath.Rel(workspace, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(path)
+}
+
+// escapeData und escapeProperty folgen dem Escaping der Workflow-Commands
+func escapeData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+func escapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
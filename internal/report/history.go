@@ -0,0 +1,186 @@
+package report
+
+import (
+	"cmp"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// NewRunID returns an ID for a run started at t. IDs sort by start time.
+func NewRunID(t time.Time) string {
+	return fmt.Sprintf("%s-%04x", t.UTC().Format("20060102T150405Z"), rand.IntN(0x10000))
+}
+
+// Archive writes r to dir as <runId>.json, setting a run ID if r has none,
+// and returns the path.
+func Archive(dir string, r Report) (string, error) {
+	if r.RunID == "" {
+		r.RunID = NewRunID(time.Now())
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, r.RunID+".json")
+	return path, Write(path, r)
+}
+
+// History reads the last n archived reports of dir, oldest first (n <= 0
+// reads all of them). Only .json files are read.
+func History(dir string, n int) ([]Report, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			names = append(names, e.Name())
+		}
+	}
+	// die Run-ID beginnt mit dem Zeitstempel
+	slices.Sort(names)
+	if n > 0 && len(names) > n {
+		names = names[len(names)-n:]
+	}
+	var runs []Report
+	for _, name := range names {
+		r, err := Read(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if r.RunID == "" {
+			r.RunID = strings.TrimSuffix(name, ".json")
+		}
+		runs = append(runs, r)
+	}
+	return runs, nil
+}
+
+// StoryTrend sums up one case over several runs.
+type StoryTrend struct {
+	Label  string `json:"label"`
+	Source string `json:"source,omitempty"`
+	// Runs zählt nur Runs, in denen der Case verglichen wurde
+	Runs     int     `json:"runs"`
+	Passed   int     `json:"passed"`
+	Failed   int     `json:"failed"`
+	Flaky    int     `json:"flaky"`
+	Errored  int     `json:"errored"`
+	PassRate float64 `json:"passRate"`
+	// Flips zählt Wechsel zwischen bestanden und nicht bestanden von Run zu Run
+	Flips     int     `json:"flips"`
+	AvgRatio  float64 `json:"avgRatio"`
+	MaxRatio  float64 `json:"maxRatio"`
+	LastRatio float64 `json:"lastRatio"`
+	// Statuses sind die Status pro Run, ältester zuerst, "" wenn der Case fehlte
+	Statuses []string `json:"statuses"`
+
+	ratios []float64 // RatioDiff pro Run, -1 ohne Pixelvergleich
+}
+
+// Trends computes a StoryTrend per case over runs (oldest first), least
+// reliable first: by pass rate, then by flips.
+func Trends(runs []Report) []StoryTrend {
+	byKey := map[string]*StoryTrend{}
+	var order []string
+	for i, r := range runs {
+		for _, c := range r.Cases {
+			key := caseKey(c)
+			t := byKey[key]
+			if t == nil {
+				t = &StoryTrend{Label: displayLabel(c), Source: c.Source, Statuses: make([]string, len(runs)), ratios: make([]float64, len(runs))}
+				byKey[key] = t
+				order = append(order, key)
+			}
+			t.Statuses[i] = c.Status
+			t.ratios[i] = -1
+			if px, ok := c.Pixel(); ok {
+				t.ratios[i] = px.RatioDiff
+			}
+		}
+	}
+
+	out := make([]StoryTrend, 0, len(order))
+	for _, key := range order {
+		t := byKey[key]
+		var ratios float64
+		samples := 0
+		prev := ""
+		for i, status := range t.Statuses {
+			outcome := trendOutcome(status)
+			if outcome == "" {
+				continue
+			}
+			t.Runs++
+			switch outcome {
+			case "pass":
+				t.Passed++
+			case "flaky":
+				t.Flaky++
+			case "fail":
+				t.Failed++
+			case "error":
+				t.Errored++
+			}
+			// flaky zählt als nicht bestanden, sonst fallen genau die Stories durch
+			passed := outcome == "pass"
+			if prev != "" && (prev == "pass") != passed {
+				t.Flips++
+			}
+			prev = outcome
+
+			if px := t.ratios[i]; px >= 0 {
+				ratios += px
+				samples++
+				t.MaxRatio = max(t.MaxRatio, px)
+				t.LastRatio = px
+			}
+		}
+		if t.Runs > 0 {
+			t.PassRate = float64(t.Passed) / float64(t.Runs)
+		}
+		if samples > 0 {
+			t.AvgRatio = ratios / float64(samples)
+		}
+		if t.Runs > 0 {
+			out = append(out, *t)
+		}
+	}
+	slices.SortStableFunc(out, func(a, b StoryTrend) int {
+		return cmp.Or(cmp.Compare(a.PassRate, b.PassRate), cmp.Compare(b.Flips, a.Flips))
+	})
+	return out
+}
+
+// caseKey identifiziert einen Case über Runs hinweg, auch aus anderen
+// Checkout-Pfaden; ältere Reports ohne storyFile fallen auf source zurück
+func caseKey(c CaseResult) string {
+	return cmp.Or(c.StoryFile, c.Source) + "\x00" + c.Browser + "\x00" + c.Label()
+}
+
+func displayLabel(c CaseResult) string {
+	if c.Browser != "" && c.Browser != "chrome" {
+		return c.Label() + " (" + c.Browser + ")"
+	}
+	return c.Label()
+}
+
+// trendOutcome fasst Status zusammen; "" heißt, der Case wurde nicht verglichen
+func trendOutcome(status string) string {
+	switch status {
+	case "pass", "cached-pass", "created", "updated":
+		return "pass"
+	case "flaky":
+		return "flaky"
+	case "fail", "unstable", "no-baseline":
+		return "fail"
+	case "error", "image-too-large":
+		return "error"
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+
+	"github.com/maxischmaxi/qsnap/internal/tools"
+)
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Errors    int         `xml:"errors,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Timestamp string      `xml:"timestamp,attr,omitempty"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Body    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the report as JUnit XML. No-baseline cases are reported
+// according to noBaselineAs, the same policy that drives ExitCode.
+func WriteJUnit(path string, r Report, noBaselineAs string) error {
+	suite := junitSuite{Name: "qsnap", Timestamp: r.GeneratedAt}
+
+	for _, c := range r.Cases {
+		if c.Status == "" {
+			continue
+		}
+		jc := junitCase{
+			Name:      c.Label(),
+			ClassName: "qsnap." + c.Name,
+		}
+
+		switch c.Status {
+		case "fail":
+			jc.Failure = &junitMessage{Message: "snapshot differs from baseline", Body: caseDetails(c)}
+		case "unstable":
+			jc.Failure = &junitMessage{Message: "capture did not stabilize and differs from baseline", Body: caseDetails(c)}
+		case "flaky":
+			jc.SystemOut = fmt.Sprintf("flaky: passed on attempt %d\n", c.Attempts)
+		case "error", "image-too-large":
+			jc.Error = &junitMessage{Message: c.Error, Body: caseDetails(c)}
+		case "skipped":
+			jc.Skipped = &junitMessage{Message: "run was interrupted"}
+		case "skipped-deadline":
+			jc.Skipped = &junitMessage{Message: "not run before -maxDuration ran out"}
+		case "skipped-config":
+			msg := "skipped in story config"
+			if c.SkipReason != "" {
+				msg += ": " + c.SkipReason
+			}
+			jc.Skipped = &junitMessage{Message: msg}
+		case "no-baseline":
+			msg := &junitMessage{Message: "no baseline", Body: c.Baseline}
+			switch noBaselineAs {
+			case "fail":
+				jc.Failure = msg
+			case "error":
+				jc.Error = msg
+			default:
+				jc.Skipped = msg
+			}
+		}
+
+		switch {
+		case jc.Failure != nil:
+			suite.Failures++
+		case jc.Error != nil:
+			suite.Errors++
+		case jc.Skipped != nil:
+			suite.Skipped++
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, jc)
+	}
+
+	b, err := xml.MarshalIndent(junitSuites{Suites: []junitSuite{suite}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return tools.WriteFileAtomic(path, append([]byte(xml.Header), b...))
+}
+
+func caseDetails(c CaseResult) string {
+	s := fmt.Sprintf("url: %s\nbaseline: %s\n", c.URL, c.Baseline)
+	if c.Actual != "" {
+		s += fmt.Sprintf("actual: %s\n", c.Actual)
+	}
+	if c.PixelDiff != nil {
+		s += fmt.Sprintf("diff: %s\n", c.OutPath)
+	}
+	if c.Debug != "" {
+		s += fmt.Sprintf("debug: %s\n", c.Debug)
+	}
+	if c.HAR != "" {
+		s += fmt.Sprintf("har: %s\n", c.HAR)
+	}
+	return s
+}
@@ -0,0 +1,134 @@
+package report
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/maxischmaxi/qsnap/internal/tools"
+)
+
+// MarkdownOptions control how Markdown links images.
+type MarkdownOptions struct {
+	NoBaselineAs string
+	// Root ist das Verzeichnis, relativ zu dem Bildpfade verlinkt werden
+	Root string
+	// ImageBase ersetzt alles bis einschließlich __image-snapshots__/, z.B. durch
+	// die URL, unter der CI das Snapshot-Verzeichnis veröffentlicht
+	ImageBase string
+	// ImageRoot ist das Snapshot-Verzeichnis, falls es per outputDir anders heißt
+	ImageRoot string
+}
+
+// maxMarkdownCases begrenzt die Liste, damit Step Summaries übersichtlich bleiben
+const maxMarkdownCases = 50
+
+// Markdown renders a short summary for PR checks: a totals table, the cases
+// that need attention with links to their images, and baseline changes.
+func Markdown(r Report, opts MarkdownOptions) string {
+	var b strings.Builder
+	icon := "✅"
+	if ExitCode(r, opts.NoBaselineAs) != ExitOK {
+		icon = "❌"
+	}
+	fmt.Fprintf(&b, "## %s qsnap: %d/%d passed\n\n", icon, r.Passed+r.CachedPass+r.Created+r.Updated+r.Flaky, r.Total)
+
+	b.WriteString("| Passed | Cached | Created | Updated | Failed | Unstable | Flaky | New | Errors | Skipped | Skipped by config |\n")
+	b.WriteString("|---|---|---|---|---|---|---|---|---|---|---|\n")
+	fmt.Fprintf(&b, "| %d | %d | %d | %d | %d | %d | %d | %d | %d | %d | %d |\n\n",
+		r.Passed, r.CachedPass, r.Created, r.Updated, r.Failed, r.Unstable, r.Flaky, r.NoBaseline, r.Errored+r.ImageTooLarge, r.Skipped+r.SkippedDeadline, r.SkippedByConfig)
+
+	var attention []CaseResult
+	for _, c := range r.Cases {
+		switch c.Status {
+		case "fail", "unstable", "flaky", "no-baseline", "error", "image-too-large":
+			attention = append(attention, c)
+		}
+	}
+	if len(attention) > 0 {
+		b.WriteString("### Cases to review\n\n")
+		for i, c := range attention {
+			if i == maxMarkdownCases {
+				fmt.Fprintf(&b, "- … and %d more, see the full report\n", len(attention)-i)
+				break
+			}
+			fmt.Fprintf(&b, "- **%s** `%s`", c.Status, c.Label())
+			if px, ok := c.Pixel(); ok {
+				fmt.Fprintf(&b, " %.4f%%", px.RatioDiff*100)
+				if len(px.Boxes) > 0 {
+					bx := px.Boxes[0]
+					fmt.Fprintf(&b, " in %d regions, largest at %d,%d (%d×%d)", px.Regions, bx.X, bx.Y, bx.Width, bx.Height)
+				}
+			}
+			if c.Error != "" {
+				fmt.Fprintf(&b, ": %s", c.Error)
+			}
+			var links []string
+			for _, l := range []struct{ name, path string }{{"baseline", c.Baseline}, {"actual", c.Actual}, {"diff", diffImage(c)}, {"debug", c.Debug}, {"har", c.HAR}} {
+				if l.path != "" && (l.name != "baseline" || c.Status == "fail" || c.Status == "unstable") {
+					links = append(links, fmt.Sprintf("[%s](%s)", l.name, opts.link(l.path)))
+				}
+			}
+			if len(links) > 0 {
+				b.WriteString(" (" + strings.Join(links, " · ") + ")")
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString("\n")
+	}
+
+	if comment := BaselineComment(r); comment != "" {
+		b.WriteString(comment + "\n")
+	}
+	return b.String()
+}
+
+// diffImage ist nur gesetzt, wenn das Diff-Bild auch geschrieben wurde
+func diffImage(c CaseResult) string {
+	if px, ok := c.Pixel(); ok {
+		return px.DiffImagePath
+	}
+	return ""
+}
+
+func (o MarkdownOptions) link(path string) string {
+	slashed := filepath.ToSlash(path)
+	if o.ImageBase != "" && o.ImageRoot != "" {
+		if rel, err := filepath.Rel(o.ImageRoot, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return strings.TrimSuffix(o.ImageBase, "/") + "/" + strings.ReplaceAll(filepath.ToSlash(rel), " ", "%20")
+		}
+	}
+	if o.ImageBase != "" {
+		if _, rest, ok := strings.Cut(slashed, snapshotsDirName+"/"); ok {
+			return strings.TrimSuffix(o.ImageBase, "/") + "/" + rest
+		}
+	}
+	if o.Root != "" {
+		if rel, err := filepath.Rel(o.Root, path); err == nil {
+			slashed = filepath.ToSlash(rel)
+		}
+	}
+	return strings.ReplaceAll(slashed, " ", "%20")
+}
+
+const snapshotsDirName = "__image-snapshots__"
+
+// WriteMarkdown writes Markdown to path.
+func WriteMarkdown(path string, r Report, opts MarkdownOptions) error {
+	return tools.WriteFileAtomic(path, []byte(Markdown(r, opts)))
+}
+
+// AppendMarkdown appends the summary to path, as GitHub expects for
+// $GITHUB_STEP_SUMMARY (several steps can write to the same file).
+func AppendMarkdown(path string, r Report, opts MarkdownOptions) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(Markdown(r, opts)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
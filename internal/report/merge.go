@@ -0,0 +1,83 @@
+package report
+
+import (
+	"slices"
+	"time"
+)
+
+// Recount sets the summary counters and baseline changes from r.Cases.
+func (r *Report) Recount() {
+	r.Total = len(r.Cases)
+	r.Passed = CountStatus(r.Cases, "pass")
+	r.CachedPass = CountStatus(r.Cases, "cached-pass")
+	r.Created = CountStatus(r.Cases, "created")
+	r.Updated = CountStatus(r.Cases, "updated")
+	r.Failed = CountStatus(r.Cases, "fail")
+	r.Unstable = CountStatus(r.Cases, "unstable")
+	r.Flaky = CountStatus(r.Cases, "flaky")
+	r.NoBaseline = CountStatus(r.Cases, "no-baseline")
+	r.Errored = CountStatus(r.Cases, "error")
+	r.ImageTooLarge = CountStatus(r.Cases, "image-too-large")
+	r.Skipped = CountStatus(r.Cases, "skipped")
+	r.SkippedByConfig = CountStatus(r.Cases, "skipped-config")
+	r.SkippedDeadline = CountStatus(r.Cases, "skipped-deadline")
+	r.Warned = CountWarned(r.Cases)
+	r.BaselineChanges = BaselineChanges(r.Cases)
+}
+
+// Merge combines partial reports, e.g. from CI shards, into one. Counters
+// and analytics are recomputed; timing spans from the earliest start to the
+// latest end with capture and diff time summed up.
+func Merge(reports []Report, topN int) Report {
+	out := Report{GeneratedAt: time.Now().Format(time.RFC3339)}
+	var timing *Timing
+	for _, r := range reports {
+		out.Cases = append(out.Cases, r.Cases...)
+		for _, sc := range r.SkippedConfigs {
+			if !slices.Contains(out.SkippedConfigs, sc) {
+				out.SkippedConfigs = append(out.SkippedConfigs, sc)
+			}
+		}
+		timing = mergeTiming(timing, r.Timing)
+	}
+	out.Recount()
+	out.Analytics = Analyze(out.Cases, topN)
+	out.Timing = timing
+	return out
+}
+
+func mergeTiming(a, b *Timing) *Timing {
+	if a == nil || b == nil {
+		if a == nil {
+			return b
+		}
+		return a
+	}
+	start, end := a.StartedAt, a.FinishedAt
+	if ts, err := time.Parse(time.RFC3339, b.StartedAt); err == nil {
+		if ta, err := time.Parse(time.RFC3339, start); err != nil || ts.Before(ta) {
+			start = b.StartedAt
+		}
+	}
+	if te, err := time.Parse(time.RFC3339, b.FinishedAt); err == nil {
+		if ta, err := time.Parse(time.RFC3339, end); err != nil || te.After(ta) {
+			end = b.FinishedAt
+		}
+	}
+
+	st, _ := time.Parse(time.RFC3339, start)
+	et, _ := time.Parse(time.RFC3339, end)
+	wall := et.Sub(st)
+	capture := time.Duration(a.CaptureMs+b.CaptureMs) * time.Millisecond
+	diff := time.Duration(a.DiffMs+b.DiffMs) * time.Millisecond
+	return &Timing{
+		StartedAt:  start,
+		FinishedAt: end,
+		WallMs:     wall.Milliseconds(),
+		Wall:       humanDuration(wall),
+		CaptureMs:  capture.Milliseconds(),
+		Capture:    humanDuration(capture),
+		DiffMs:     diff.Milliseconds(),
+		Diff:       humanDuration(diff),
+	}
+}
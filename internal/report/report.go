@@ -2,30 +2,169 @@ package report
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/maxischmaxi/qsnap/internal/config"
+	"github.com/maxischmaxi/qsnap/internal/diff"
+	"github.com/maxischmaxi/qsnap/internal/tools"
+)
+
+// SchemaVersion is written to report.json as schemaVersion. It changes when
+// fields are removed or change their type; reports without it are version 1,
+// where pixelDiff, percepDiff and ssimDiff were untyped.
+const SchemaVersion = 2
+
+const (
+	ExitOK       = 0
+	ExitFailures = 1
+	ExitErrors   = 2
+	// ExitInterrupted folgt der Shell-Konvention 128+SIGINT
+	ExitInterrupted = 130
 )
 
 type CaseResult struct {
 	Name   string `json:"name"`
 	URL    string `json:"url"`
-	Status string `json:"status"` // pass | fail | no-baseline | error
-	Error  string `json:"error,omitempty"`
+	Source string `json:"source,omitempty"` // story config file the case comes from
+	// StoryFile ist Source relativ zu -input und bleibt über Checkouts gleich
+	StoryFile   string `json:"storyFile,omitempty"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Browser     string `json:"browser,omitempty"`
+	ColorScheme string `json:"colorScheme,omitempty"`
+	Device      string `json:"device,omitempty"`
+	Status      string `json:"status"` // pass | cached-pass | created | updated | fail | unstable | flaky | no-baseline | error | image-too-large | skipped | skipped-config | skipped-deadline
+	Error       string `json:"error,omitempty"`
+	ErrorKind   string `json:"errorKind,omitempty"`  // timeout | hung | capture | compare | browser | panic | play | worker
+	SkipReason  string `json:"skipReason,omitempty"` // skipped-config: the story's skip reason
+	// Warnings ändern den Status nicht, z.B. ein Capture über dem Soft-Timeout
+	Warnings []string `json:"warnings,omitempty"`
+
+	Baseline    string `json:"baseline"`
+	BaselineKey string `json:"baselineKey,omitempty"` // object key in remote baseline storage
+	// BranchBaseline ist gesetzt, solange der Case mit der Baseline des
+	// Hauptbranches verglichen wird; neue Baselines landen hier
+	BranchBaseline string `json:"branchBaseline,omitempty"`
+	OutPath        string `json:"outPath"`
+	Actual         string `json:"actual,omitempty"` // captured image, kept for fail/no-baseline cases
+	// Debug ist das Verzeichnis mit DOM, Konsole und Netzwerkfehlern eines Fehlers
+	Debug string `json:"debug,omitempty"`
+	// HAR sind die Requests der Seite bei fail, unstable und error
+	HAR string `json:"har,omitempty"`
+
+	Masked    []config.Rect `json:"masked,omitempty"`
+	Threshold float64       `json:"threshold"` // allowed fraction of differing pixels
+	// MaxDiffPixels ersetzt Threshold, wenn gesetzt
+	MaxDiffPixels *int `json:"maxDiffPixels,omitempty"`
+
+	// nur bei verglichenen Cases gesetzt, ssimDiff nur mit ssim oder failWhen
+	PixelDiff  *diff.PixelResult `json:"pixelDiff,omitempty"`
+	PercepDiff *diff.PHashResult `json:"percepDiff,omitempty"`
+	SSIMDiff   *diff.SSIMResult  `json:"ssimDiff,omitempty"`
 
-	Baseline string `json:"baseline"`
-	OutPath  string `json:"outPath"`
+	// gesetzt, wenn ein Comparator-Plugin oder failWhen den Status bestimmt hat
+	ComparedBy     string `json:"comparedBy,omitempty"`
+	CompareMessage string `json:"compareMessage,omitempty"`
+
+	Review string `json:"review,omitempty"` // approved | rejected, set by qsnap review
+
+	// Attempts ist gesetzt, wenn der Case wegen retry mehrfach lief
+	Attempts int `json:"attempts,omitempty"`
+
+	// StartedAt ist der Start des Cases inklusive Warten auf einen Tab
+	StartedAt time.Time `json:"startedAt,omitzero"`
+	// Instance is the browser instance (0-based) that captured the case.
+	Instance *int `json:"instance,omitempty"`
+	// im JSON als waitMs, captureMs und diffMs, siehe MarshalJSON
+	WaitTime    time.Duration `json:"-"`
+	CaptureTime time.Duration `json:"-"`
+	DiffTime    time.Duration `json:"-"`
+}
+
+type caseJSON CaseResult
+
+type caseDurations struct {
+	WaitMs    int64 `json:"waitMs,omitempty"`
+	CaptureMs int64 `json:"captureMs,omitempty"`
+	DiffMs    int64 `json:"diffMs,omitempty"`
+}
 
-	PixelDiff  any `json:"pixelDiff,omitempty"`
-	PercepDiff any `json:"percepDiff,omitempty"`
+// MarshalJSON writes the durations in milliseconds.
+func (c CaseResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		caseJSON
+		caseDurations
+	}{caseJSON(c), caseDurations{c.WaitTime.Milliseconds(), c.CaptureTime.Milliseconds(), c.DiffTime.Milliseconds()}})
+}
+
+func (c *CaseResult) UnmarshalJSON(b []byte) error {
+	var v struct {
+		caseJSON
+		caseDurations
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*c = CaseResult(v.caseJSON)
+	c.WaitTime = time.Duration(v.WaitMs) * time.Millisecond
+	c.CaptureTime = time.Duration(v.CaptureMs) * time.Millisecond
+	c.DiffTime = time.Duration(v.DiffMs) * time.Millisecond
+	return nil
+}
+
+// Label identifies a case including its color scheme, device and size, e.g. "Button_dark_1280x720".
+func (c CaseResult) Label() string {
+	name := c.Name
+	if c.ColorScheme != "" {
+		name += "_" + c.ColorScheme
+	}
+	if c.Device != "" {
+		name += "_" + c.Device
+	}
+	return fmt.Sprintf("%s_%dx%d", name, c.Width, c.Height)
+}
+
+type SkippedConfig struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
 }
 
 type Report struct {
-	GeneratedAt string       `json:"generatedAt"`
-	Total       int          `json:"total"`
-	Passed      int          `json:"passed"`
-	Failed      int          `json:"failed"`
-	NoBaseline  int          `json:"noBaseline"`
-	Errored     int          `json:"errored"`
-	Cases       []CaseResult `json:"cases"`
+	SchemaVersion int    `json:"schemaVersion"`
+	RunID         string `json:"runId,omitempty"`
+	GeneratedAt   string `json:"generatedAt"`
+	Total         int    `json:"total"`
+	Passed        int    `json:"passed"`
+	CachedPass    int    `json:"cachedPass"`
+	Created       int    `json:"created"`
+	Updated       int    `json:"updated"`
+	Failed        int    `json:"failed"`
+	// Unstable sind Fails, deren Captures mit stabilize nie zur Ruhe kamen
+	Unstable int `json:"unstable"`
+	// Flaky sind Fails, die bei einer Wiederholung (retry) bestanden haben;
+	// sie zählen nicht als Fehler
+	Flaky      int `json:"flaky"`
+	NoBaseline int `json:"noBaseline"`
+	Errored    int `json:"errored"`
+	// ImageTooLarge sind Cases, deren Capture oder Baseline maxImageDimension überschreitet
+	ImageTooLarge int `json:"imageTooLarge"`
+	Skipped       int `json:"skipped"`
+	// SkippedDeadline sind Cases, die vor dem Ende von -maxDuration nicht mehr drankamen
+	SkippedDeadline int `json:"skippedDeadline"`
+	// SkippedByConfig sind Stories mit `skip`, getrennt von abgebrochenen Cases
+	SkippedByConfig int          `json:"skippedByConfig"`
+	Warned          int          `json:"warned"`
+	Cases           []CaseResult `json:"cases"`
+
+	SkippedConfigs []SkippedConfig `json:"skippedConfigs,omitempty"`
+	// BaselineChanges listet die Baselines, die dieser Run selbst geschrieben hat
+	BaselineChanges []BaselineChange `json:"baselineChanges,omitempty"`
+	Analytics       *Analytics       `json:"analytics,omitempty"`
+	Timing          *Timing          `json:"timing,omitempty"`
 }
 
 func CountStatus(cases []CaseResult, status string) int {
@@ -38,10 +177,91 @@ func CountStatus(cases []CaseResult, status string) int {
 	return n
 }
 
+// CountWarned counts cases with at least one warning.
+func CountWarned(cases []CaseResult) int {
+	n := 0
+	for _, c := range cases {
+		if len(c.Warnings) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func Read(path string) (Report, error) {
+	var r Report
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return r, err
+	}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return r, err
+	}
+	if r.SchemaVersion > SchemaVersion {
+		return r, fmt.Errorf("%s: report schema version %d is newer than this qsnap supports (%d)", path, r.SchemaVersion, SchemaVersion)
+	}
+	return r, nil
+}
+
+// Write writes r as JSON with the current SchemaVersion.
 func Write(path string, r Report) error {
+	r.SchemaVersion = SchemaVersion
 	b, err := json.MarshalIndent(r, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, b, 0o644)
+	return tools.WriteFileAtomic(path, b)
+}
+
+// ParseFormats splits and validates a comma-separated list of report formats.
+func ParseFormats(csv string) ([]string, error) {
+	var out []string
+	for _, f := range strings.Split(csv, ",") {
+		f = strings.TrimSpace(f)
+		switch f {
+		case "":
+			continue
+		case "json", "junit", "markdown":
+			if !slices.Contains(out, f) {
+				out = append(out, f)
+			}
+		default:
+			return nil, fmt.Errorf("unknown report format %q: expected json, junit or markdown", f)
+		}
+	}
+	if len(out) == 0 {
+		out = []string{"json"}
+	}
+	return out, nil
+}
+
+// ParseNoBaselinePolicy validates how no-baseline cases should be treated by
+// ExitCode and returns the status they are counted as.
+func ParseNoBaselinePolicy(s string) (string, error) {
+	switch s {
+	case "pass", "fail", "error":
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid no-baseline policy %q: expected pass, fail or error", s)
+}
+
+// ExitCode maps the report counts to the process exit code. Errors win over
+// failures; no-baseline cases count as noBaselineAs ("pass", "fail" or "error").
+// Cases cut off by -maxDuration count as failures, a truncated run never passes.
+func ExitCode(r Report, noBaselineAs string) int {
+	errored, failed := r.Errored+r.ImageTooLarge, r.Failed+r.Unstable+r.SkippedDeadline
+	switch noBaselineAs {
+	case "error":
+		errored += r.NoBaseline
+	case "fail":
+		failed += r.NoBaseline
+	}
+
+	if errored > 0 {
+		return ExitErrors
+	}
+	if failed > 0 {
+		return ExitFailures
+	}
+	return ExitOK
 }
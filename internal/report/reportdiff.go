@@ -0,0 +1,67 @@
+package report
+
+import "errors"
+
+// StatusChange is a case whose status differs between two runs. Old is ""
+// for a case that is new, New is "" for a case that is gone.
+type StatusChange struct {
+	Label  string `json:"label"`
+	Source string `json:"source,omitempty"`
+	Old    string `json:"old"`
+	New    string `json:"new"`
+}
+
+// RunDiff compares the cases of two runs.
+type RunDiff struct {
+	// NewlyFailed passed (or was not compared) before and fails or errors now
+	NewlyFailed []StatusChange `json:"newlyFailed"`
+	// NewlyPassed failed or errored before and passes now
+	NewlyPassed []StatusChange `json:"newlyPassed"`
+	// Changed are all other status changes, e.g. pass -> flaky or fail -> error
+	Changed []StatusChange `json:"changed"`
+	Added   []StatusChange `json:"added"`
+	Removed []StatusChange `json:"removed"`
+}
+
+// DiffReports lists the cases whose status changed from old to new, in the
+// order of new (removed cases in the order of old). Two reports without a
+// single case in common are an error, they most likely come from different
+// projects or from a qsnap version that didn't write storyFile yet.
+func DiffReports(old, new Report) (RunDiff, error) {
+	d := RunDiff{NewlyFailed: []StatusChange{}, NewlyPassed: []StatusChange{}, Changed: []StatusChange{}, Added: []StatusChange{}, Removed: []StatusChange{}}
+	before := map[string]CaseResult{}
+	for _, c := range old.Cases {
+		before[caseKey(c)] = c
+	}
+	seen := map[string]bool{}
+	common := 0
+	for _, c := range new.Cases {
+		key := caseKey(c)
+		seen[key] = true
+		o, ok := before[key]
+		if ok {
+			common++
+		}
+		ch := StatusChange{Label: displayLabel(c), Source: c.Source, Old: o.Status, New: c.Status}
+		switch was, is := trendOutcome(o.Status), trendOutcome(c.Status); {
+		case !ok:
+			d.Added = append(d.Added, ch)
+		case o.Status == c.Status:
+		case (is == "fail" || is == "error") && (was == "pass" || was == ""):
+			d.NewlyFailed = append(d.NewlyFailed, ch)
+		case is == "pass" && (was == "fail" || was == "error"):
+			d.NewlyPassed = append(d.NewlyPassed, ch)
+		default:
+			d.Changed = append(d.Changed, ch)
+		}
+	}
+	for _, c := range old.Cases {
+		if !seen[caseKey(c)] {
+			d.Removed = append(d.Removed, StatusChange{Label: displayLabel(c), Source: c.Source, Old: c.Status})
+		}
+	}
+	if common == 0 && len(old.Cases) > 0 && len(new.Cases) > 0 {
+		return d, errors.New("the reports have no case in common")
+	}
+	return d, nil
+}
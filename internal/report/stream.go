@@ -0,0 +1,79 @@
+package report
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// Stream appends one JSON line per finished case, so the results of a run
+// survive a crash before the final report is written.
+type Stream struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// CreateStream truncates path and starts a new stream.
+func CreateStream(path string) (*Stream, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Stream{f: f}, nil
+}
+
+// Add writes c and syncs it to disk. A nil Stream drops everything.
+func (s *Stream) Add(c CaseResult) error {
+	if s == nil {
+		return nil
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return s.f.Sync()
+}
+
+func (s *Stream) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.f.Close()
+}
+
+// ReadStream returns the cases of a stream file. A truncated last line from
+// a crash mid-write is ignored.
+func ReadStream(path string) ([]CaseResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []CaseResult
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			var c CaseResult
+			if err := json.Unmarshal(line, &c); err != nil {
+				return out, err
+			}
+			out = append(out, c)
+		}
+		if errors.Is(err, io.EOF) {
+			return out, nil
+		}
+		if err != nil {
+			return out, err
+		}
+	}
+}
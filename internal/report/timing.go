@@ -0,0 +1,49 @@
+package report
+
+import "time"
+
+// Timing tracks how long the tool itself took, so regressions in qsnap's own
+// run time show up across reports. Durations are given in milliseconds and as
+// a rounded human-readable string.
+type Timing struct {
+	StartedAt  string `json:"startedAt"`
+	FinishedAt string `json:"finishedAt"`
+
+	WallMs    int64  `json:"wallMs"`
+	Wall      string `json:"wall"`
+	CaptureMs int64  `json:"captureMs"`
+	Capture   string `json:"capture"`
+	DiffMs    int64  `json:"diffMs"`
+	Diff      string `json:"diff"`
+}
+
+// NewTiming sums the per-case capture and diff times. Capture and diff are
+// cumulative over all workers and can exceed the wall-clock time.
+func NewTiming(start, end time.Time, cases []CaseResult) *Timing {
+	var capture, diff time.Duration
+	for _, c := range cases {
+		capture += c.CaptureTime
+		diff += c.DiffTime
+	}
+	wall := end.Sub(start)
+	return &Timing{
+		StartedAt:  start.Format(time.RFC3339),
+		FinishedAt: end.Format(time.RFC3339),
+		WallMs:     wall.Milliseconds(),
+		Wall:       humanDuration(wall),
+		CaptureMs:  capture.Milliseconds(),
+		Capture:    humanDuration(capture),
+		DiffMs:     diff.Milliseconds(),
+		Diff:       humanDuration(diff),
+	}
+}
+
+func humanDuration(d time.Duration) string {
+	switch {
+	case d >= time.Minute:
+		return d.Round(time.Second).String()
+	case d >= time.Second:
+		return d.Round(100 * time.Millisecond).String()
+	}
+	return d.Round(time.Millisecond).String()
+}
@@ -0,0 +1,162 @@
+package review
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/maxischmaxi/qsnap/internal/report"
+)
+
+// Pending returns the indexes of cases that need a decision: failures and
+// new cases that have a capture.
+func Pending(r report.Report) []int {
+	var out []int
+	for i, c := range r.Cases {
+		if (c.Status == "fail" || c.Status == "unstable" || c.Status == "no-baseline") && c.Actual != "" {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// Run shows the review screen for the pending cases of r. approve is called
+// for every case the user approves; decisions are stored in Case.Review.
+// It returns true if at least one decision was made.
+func Run(r *report.Report, approve func(*report.CaseResult) error) (bool, error) {
+	m := &model{rep: r, pending: Pending(*r), approve: approve}
+	if len(m.pending) == 0 {
+		return false, nil
+	}
+	if _, err := tea.NewProgram(m, tea.WithAltScreen()).Run(); err != nil {
+		return false, err
+	}
+	return m.changed, nil
+}
+
+type model struct {
+	rep     *report.Report
+	pending []int
+	cursor  int
+	approve func(*report.CaseResult) error
+	status  string
+	changed bool
+}
+
+func (m *model) Init() tea.Cmd { return nil }
+
+func (m *model) current() *report.CaseResult {
+	return &m.rep.Cases[m.pending[m.cursor]]
+}
+
+func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	key, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return m, nil
+	}
+
+	c := m.current()
+	switch key.String() {
+	case "q", "ctrl+c", "esc":
+		return m, tea.Quit
+	case "up", "k":
+		if m.cursor > 0 {
+			m.cursor--
+		}
+		m.status = ""
+	case "down", "j":
+		if m.cursor < len(m.pending)-1 {
+			m.cursor++
+		}
+		m.status = ""
+	case "b":
+		m.open(c.Baseline)
+	case "c":
+		m.open(c.Actual)
+	case "d":
+		m.open(c.OutPath)
+	case "a":
+		if err := m.approve(c); err != nil {
+			m.status = "approve failed: " + err.Error()
+			break
+		}
+		c.Review = "approved"
+		m.changed = true
+		m.status = c.Label() + " approved"
+		m.next()
+	case "r":
+		c.Review = "rejected"
+		m.changed = true
+		m.status = c.Label() + " rejected"
+		m.next()
+	}
+	return m, nil
+}
+
+// next springt zum nächsten Case ohne Entscheidung
+func (m *model) next() {
+	for i := m.cursor + 1; i < len(m.pending); i++ {
+		if m.rep.Cases[m.pending[i]].Review == "" {
+			m.cursor = i
+			return
+		}
+	}
+}
+
+func (m *model) open(path string) {
+	if path == "" {
+		m.status = "no image for this case"
+		return
+	}
+	if err := Open(path); err != nil {
+		m.status = "open failed: " + err.Error()
+		return
+	}
+	m.status = "opened " + path
+}
+
+func (m *model) View() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "qsnap review - %d cases\n\n", len(m.pending))
+	for i, idx := range m.pending {
+		c := m.rep.Cases[idx]
+		cursor := "  "
+		if i == m.cursor {
+			cursor = "> "
+		}
+		decision := c.Review
+		if decision == "" {
+			decision = "-"
+		}
+		detail := c.Status
+		if px, ok := c.Pixel(); ok {
+			detail = fmt.Sprintf("%s %.4f%%", c.Status, px.RatioDiff*100)
+		}
+		fmt.Fprintf(&b, "%s%-9s %-50s %s\n", cursor, decision, c.Label(), detail)
+	}
+	b.WriteString("\n[b] baseline  [c] current  [d] diff  [a] approve  [r] reject  [j/k] move  [q] quit\n")
+	if m.status != "" {
+		b.WriteString("\n" + m.status + "\n")
+	}
+	return b.String()
+}
+
+// Open shows path in the system image viewer.
+func Open(path string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", path)
+	case "windows":
+		cmd = exec.Command("cmd", "/c", "start", "", path)
+	default:
+		cmd = exec.Command("xdg-open", path)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go func() { _ = cmd.Wait() }()
+	return nil
+}
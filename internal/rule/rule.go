@@ -0,0 +1,103 @@
+package rule
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/cel-go/cel"
+)
+
+// Metrics are the variables a failWhen expression can use.
+type Metrics struct {
+	Ratio     float64       // ratio: fraction of differing pixels
+	Threshold float64       // threshold: the case's pixel threshold
+	Hamming   int           // hamming: pHash distance
+	Regions   int           // regions: separate changed areas
+	Duration  time.Duration // duration: capture + diff, in seconds
+	SSIM      float64       // ssim: structural similarity, 1 = identical
+}
+
+// Rule is a compiled CEL expression that decides whether a case fails.
+type Rule struct {
+	Expr string
+	prg  cel.Program
+}
+
+var (
+	envOnce sync.Once
+	env     *cel.Env
+	envErr  error
+
+	// compiled hält kompilierte Ausdrücke, weil jede Story dieselben Regeln teilt
+	compiled sync.Map // string -> *Rule
+)
+
+func celEnv() (*cel.Env, error) {
+	envOnce.Do(func() {
+		env, envErr = cel.NewEnv(
+			cel.Variable("ratio", cel.DoubleType),
+			cel.Variable("threshold", cel.DoubleType),
+			cel.Variable("hamming", cel.IntType),
+			cel.Variable("regions", cel.IntType),
+			cel.Variable("duration", cel.DoubleType),
+			cel.Variable("ssim", cel.DoubleType),
+		)
+	})
+	return env, envErr
+}
+
+// Compile checks that expr is a valid CEL expression returning bool, e.g.
+// "ratio > 0.01 || regions > 3".
+func Compile(expr string) (*Rule, error) {
+	if r, ok := compiled.Load(expr); ok {
+		return r.(*Rule), nil
+	}
+	e, err := celEnv()
+	if err != nil {
+		return nil, err
+	}
+	ast, iss := e.Compile(expr)
+	if iss.Err() != nil {
+		return nil, fmt.Errorf("failWhen %q: %w", expr, iss.Err())
+	}
+	if ast.OutputType() != cel.BoolType {
+		return nil, fmt.Errorf("failWhen %q: must be a condition, got %s", expr, ast.OutputType())
+	}
+	prg, err := e.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("failWhen %q: %w", expr, err)
+	}
+	r := &Rule{Expr: expr, prg: prg}
+	compiled.Store(expr, r)
+	return r, nil
+}
+
+// Fails evaluates the rule against m.
+func (r *Rule) Fails(m Metrics) (bool, error) {
+	out, _, err := r.prg.Eval(map[string]any{
+		"ratio":     m.Ratio,
+		"threshold": m.Threshold,
+		"hamming":   int64(m.Hamming),
+		"regions":   int64(m.Regions),
+		"duration":  m.Duration.Seconds(),
+		"ssim":      m.SSIM,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failWhen %q: %w", r.Expr, err)
+	}
+	fail, ok := out.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("failWhen %q: result is not a bool", r.Expr)
+	}
+	return fail, nil
+}
+
+// Eval compiles expr (cached) and evaluates it against m.
+func Eval(expr string, m Metrics) (bool, error) {
+	r, err := Compile(expr)
+	if err != nil {
+		return false, err
+	}
+	return r.Fails(m)
+}
@@ -0,0 +1,412 @@
+// Package runner captures and compares single cases, with retries, plugins,
+// the result cache and debug bundles.
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/maxischmaxi/qsnap/internal/browser"
+	"github.com/maxischmaxi/qsnap/internal/cache"
+	"github.com/maxischmaxi/qsnap/internal/compare"
+	"github.com/maxischmaxi/qsnap/internal/config"
+	"github.com/maxischmaxi/qsnap/internal/diff"
+	"github.com/maxischmaxi/qsnap/internal/events"
+	"github.com/maxischmaxi/qsnap/internal/firefox"
+	"github.com/maxischmaxi/qsnap/internal/plugin"
+	"github.com/maxischmaxi/qsnap/internal/pool"
+	"github.com/maxischmaxi/qsnap/internal/report"
+	"github.com/maxischmaxi/qsnap/internal/snapshot"
+	"github.com/maxischmaxi/qsnap/internal/storage"
+	"github.com/maxischmaxi/qsnap/internal/tools"
+)
+
+// Runner captures and compares single cases. The CLI's main run, watch mode,
+// workers and the API server share it with pkg/qsnap, which all keep one
+// browser pool for many cases.
+type Runner struct {
+	Config *config.OsnapBaseConfig
+	Layout config.Layout
+	// Store ist nil ohne baselineStorage
+	Store    storage.Backend
+	Browsers browser.Instances
+	// Firefox liefert den geteilten Firefox; nil = Firefox-Stories sind Fehler
+	Firefox func(ctx context.Context) (*firefox.Browser, error)
+	// URL ist die Adresse, unter der eine Story geladen wird
+	URL     func(s *config.OsnapConfig) string
+	Timeout time.Duration
+	// SoftTimeout warnt bei langsamen Captures, 0 = aus
+	SoftTimeout time.Duration
+	EmitNew     bool
+	// Update ist "", "missing" oder "all" (-updateBaselines)
+	Update string
+
+	// Cache ist nil, wenn -cache nicht gesetzt ist
+	Cache     *cache.Cache
+	CacheSalt []byte
+	Plugins   plugin.Set
+	Events    *events.Hub
+}
+
+// Case returns false if ctx was cancelled before the case finished, so the
+// caller can report it as skipped. Finished cases are passed to the plugins.
+// A non-nil tab is reused across the calls of one viewport group.
+func (r *Runner) Case(parent context.Context, s *config.OsnapConfig, missing bool, tab *snapshot.Tab) (report.CaseResult, bool) {
+	var res report.CaseResult
+	ok := true
+	key := r.cacheKey(s)
+	if s.Skip.Enabled {
+		res = compare.Skipped(r.Layout, s)
+	} else if key != "" && r.Cache.Hit(config.CaseFilename(s), key) {
+		res = compare.NewResult(r.Layout, s)
+		res.Status = "cached-pass"
+	} else {
+		res, ok = r.safeRunOne(parent, s, missing, tab)
+		if ok && s.Retry != nil && *s.Retry > 0 {
+			res, ok = r.rerun(parent, s, missing, tab, res)
+		}
+		if ok && key != "" {
+			if res.Status == "pass" {
+				r.Cache.Put(config.CaseFilename(s), key)
+			} else {
+				r.Cache.Delete(config.CaseFilename(s))
+			}
+		}
+	}
+	if ok {
+		r.Events.Publish(events.Event{Type: events.CaseFinished, Case: &res})
+		if err := r.Plugins.CaseFinished(res); err != nil {
+			slog.Warn("plugin hook failed", "case", s.Name, "err", err)
+		}
+	}
+	return res, ok
+}
+
+// rerun runs a failed case up to retry more times. If one of the reruns
+// passes, its result is returned as flaky.
+func (r *Runner) rerun(parent context.Context, s *config.OsnapConfig, missing bool, tab *snapshot.Tab, first report.CaseResult) (report.CaseResult, bool) {
+	if first.Status != "fail" && first.Status != "unstable" {
+		return first, true
+	}
+	for attempt := 2; attempt <= *s.Retry+1; attempt++ {
+		if tab != nil {
+			// mit reuseViewport würde sonst nur neu fotografiert statt neu geladen
+			tab.Reset()
+		}
+		res, ok := r.safeRunOne(parent, s, missing, tab)
+		if !ok {
+			return first, true
+		}
+		if res.Status == "pass" {
+			res.Status = "flaky"
+			res.Attempts = attempt
+			res.Warnings = append(res.Warnings, fmt.Sprintf("failed %d of %d attempts", attempt-1, attempt))
+			slog.Info("rerun passed, case is flaky", "case", s.Name, "attempt", attempt)
+			return res, true
+		}
+	}
+	first.Attempts = *s.Retry + 1
+	return first, true
+}
+
+// acquire returns the instance of tab while it is bound. Otherwise it waits
+// for a free tab slot; with a tab, the slot is kept until tab.Close.
+func (r *Runner) acquire(ctx context.Context, tab *snapshot.Tab) (*browser.Instance, func(), error) {
+	if tab != nil && tab.Instance() != nil {
+		return tab.Instance(), func() {}, nil
+	}
+	b, release, err := r.Browsers.Acquire(ctx)
+	if err != nil || tab == nil {
+		return b, release, err
+	}
+	tab.Bind(b, release)
+	return b, func() {}, nil
+}
+
+// safeRunOne turns a panic during a case into an error result, so one broken
+// case doesn't take down the run and its report.
+func (r *Runner) safeRunOne(parent context.Context, s *config.OsnapConfig, missing bool, tab *snapshot.Tab) (res report.CaseResult, ok bool) {
+	var err error
+	defer func() {
+		var pe *pool.PanicError
+		if !errors.As(err, &pe) {
+			return
+		}
+		slog.Error("panic", "case", s.Name, "value", pe.Value, "stack", string(pe.Stack))
+		res = compare.NewResult(r.Layout, s)
+		res.Status = "error"
+		res.ErrorKind = "panic"
+		res.Error = fmt.Sprintf("panic: %v", pe.Value)
+		ok = true
+	}()
+	defer pool.Recover(&err)
+	return r.runOne(parent, s, missing, tab)
+}
+
+func (r *Runner) runOne(parent context.Context, s *config.OsnapConfig, missing bool, tab *snapshot.Tab) (report.CaseResult, bool) {
+	if parent.Err() != nil {
+		return report.CaseResult{}, false
+	}
+
+	layout, cfg := r.Layout, r.Config
+	res := compare.NewResult(layout, s)
+	res.StartedAt = time.Now()
+	if r.Store != nil {
+		res.BaselineKey = r.Store.Key(r.Layout.RemoteName(s))
+	}
+
+	dbg := &snapshot.Debug{}
+	var (
+		capture func(ctx context.Context) (*snapshot.Result, error)
+		// restart startet den Browser nach einem Absturz neu, falls möglich
+		restart = func() bool { return false }
+	)
+	switch {
+	case s.Browser == "firefox":
+		if r.Firefox == nil {
+			res.Status = "error"
+			res.ErrorKind = "browser"
+			res.Error = `browser "firefox" is not available`
+			return res, true
+		}
+		ff, err := r.Firefox(parent)
+		if err != nil {
+			if parent.Err() != nil {
+				return res, false
+			}
+			res.Status = "error"
+			res.ErrorKind = "browser"
+			res.Error = err.Error()
+			return res, true
+		}
+		opts := snapshot.StoryOptions(s, r.Config.Seed)
+		if ignored := firefox.Unsupported(opts); len(ignored) > 0 {
+			res.Warnings = append(res.Warnings, "firefox ignores "+strings.Join(ignored, ", "))
+		}
+		capture = func(ctx context.Context) (*snapshot.Result, error) {
+			return ff.Capture(ctx, r.URL(s), opts)
+		}
+	case browser.Available(s.Browser):
+		// das Warten auf einen freien Tab zählt nicht zum Timeout
+		b, release, err := r.acquire(parent, tab)
+		if err != nil {
+			return res, false
+		}
+		defer release()
+		id := b.ID
+		res.Instance = &id
+
+		capture = func(ctx context.Context) (*snapshot.Result, error) {
+			opts := snapshot.StoryOptions(s, r.Config.Seed)
+			opts.Debug = dbg
+			if tab != nil {
+				return tab.Capture(ctx, r.URL(s), opts)
+			}
+			return snapshot.Capture(ctx, b, r.URL(s), opts)
+		}
+		// WatchHealth kann die Instanz schon neu gestartet haben, dann ist
+		// sie wieder gesund und RestartIfDead startet nichts mehr
+		gen := b.Generation()
+		restart = func() bool {
+			restarted, _ := b.RestartIfDead()
+			if restarted || b.Generation() != gen {
+				slog.Warn("browser crashed and was restarted, retrying", "case", s.Name, "instance", b.ID)
+			}
+			return restarted
+		}
+	default:
+		res.Status = "error"
+		res.ErrorKind = "browser"
+		res.Error = fmt.Sprintf("browser %q is not available", s.Browser)
+		return res, true
+	}
+	res.WaitTime = time.Since(res.StartedAt)
+
+	ctx, cancel := context.WithTimeout(parent, r.Timeout)
+	defer cancel()
+
+	captureStart := time.Now()
+	shot, err := capture(ctx)
+	if err != nil && parent.Err() == nil {
+		// ist Chrome abgestürzt, einmal auf einer gesunden Instanz wiederholen
+		if restart() {
+			retryCtx, retryCancel := context.WithTimeout(parent, r.Timeout)
+			defer retryCancel()
+			shot, err = capture(retryCtx)
+		}
+	}
+	res.CaptureTime = time.Since(captureStart)
+	if soft := r.SoftTimeout; soft > 0 && res.CaptureTime > soft {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("capture took %s, soft timeout is %s", res.CaptureTime.Round(100*time.Millisecond), soft))
+	}
+	if err != nil {
+		if parent.Err() != nil {
+			return res, false
+		}
+		res.Status = "error"
+		res.ErrorKind = snapshot.ErrorKind(err)
+		res.Error = err.Error()
+		r.writeDebug(s, &res, dbg)
+		return res, true
+	}
+	// das Bundle eines früheren Fehlers ist jetzt überholt
+	_ = os.RemoveAll(layout.DebugDir(s))
+	buf := shot.PNG
+	res.Masked = shot.Masked
+	// riesige Screenshots (z.B. endlose Full-Page-Stories) gar nicht erst dekodieren
+	if err := diff.CheckSize(buf, cfg.MaxImageDimension); errors.Is(err, diff.ErrImageTooLarge) {
+		res.Status = "image-too-large"
+		res.Error = err.Error()
+		return res, true
+	}
+
+	if missing && r.EmitNew {
+		if err := tools.WriteFile(layout.ActualPath(s), buf); err != nil {
+			slog.Warn("could not write capture", "case", s.Name, "err", err)
+		} else {
+			res.Actual = layout.ActualPath(s)
+		}
+	}
+
+	compare.Case(cfg, s, &res, buf)
+	switch res.Status {
+	case "no-baseline":
+		if r.Update != "" {
+			r.updateBaseline(parent, &res, buf, "created")
+		}
+		return res, true
+	case "pass", "fail":
+	default:
+		return res, true
+	}
+
+	if r.Plugins.Comparing() {
+		r.pluginCompare(&res, s, buf)
+	}
+	// ein Capture, das nie zur Ruhe kam, wird auch nicht zur Baseline
+	if shot.Unstable {
+		switch res.Status {
+		case "fail":
+			res.Status = "unstable"
+		case "pass":
+			res.Warnings = append(res.Warnings, "capture did not stabilize")
+		}
+	}
+	if res.Status == "fail" && r.Update == "all" {
+		r.updateBaseline(parent, &res, buf, "updated")
+	}
+	if res.Status == "fail" || res.Status == "unstable" {
+		if err := tools.WriteFile(layout.ActualPath(s), buf); err == nil {
+			res.Actual = layout.ActualPath(s)
+		}
+		r.writeHAR(s, &res, dbg)
+	}
+	return res, true
+}
+
+// writeDebug writes the debug bundle of an errored capture and links it from
+// res. The bundle is best effort: files that can't be written are skipped.
+func (r *Runner) writeDebug(s *config.OsnapConfig, res *report.CaseResult, dbg *snapshot.Debug) {
+	dir := r.Layout.DebugDir(s)
+	_ = os.RemoveAll(dir)
+	console, network := dbg.Lines()
+	files := map[string][]byte{
+		"error.txt":   fmt.Appendf(nil, "url: %s\nkind: %s\n\n%s\n", res.URL, res.ErrorKind, res.Error),
+		"console.log": []byte(strings.Join(console, "\n")),
+		"network.log": []byte(strings.Join(network, "\n")),
+	}
+	if dbg.HTML != "" {
+		files["dom.html"] = []byte(dbg.HTML)
+	}
+	if len(dbg.PNG) > 0 {
+		files["screenshot.png"] = dbg.PNG
+	}
+	for name, b := range files {
+		if err := tools.WriteFile(filepath.Join(dir, name), b); err != nil {
+			slog.Warn("could not write debug bundle", "case", s.Name, "err", err)
+			return
+		}
+	}
+	res.Debug = dir
+	r.writeHAR(s, res, dbg)
+}
+
+// writeHAR writes the requests of the capture to network.har in the case's
+// debug directory.
+func (r *Runner) writeHAR(s *config.OsnapConfig, res *report.CaseResult, dbg *snapshot.Debug) {
+	b, err := dbg.HAR()
+	if err == nil {
+		path := filepath.Join(r.Layout.DebugDir(s), "network.har")
+		if err = tools.WriteFile(path, b); err == nil {
+			res.HAR = path
+			return
+		}
+	}
+	slog.Warn("could not write HAR", "case", s.Name, "err", err)
+}
+
+// updateBaseline writes the capture as the case's baseline and sets status.
+// If that fails the case keeps its status and gets a warning.
+func (r *Runner) updateBaseline(ctx context.Context, res *report.CaseResult, buf []byte, status string) {
+	if err := compare.WriteBaseline(ctx, r.Store, res, buf); err != nil {
+		res.Warnings = append(res.Warnings, "could not write baseline: "+err.Error())
+		return
+	}
+	res.Status = status
+}
+
+// pluginCompare lets comparator plugins override the pixel diff verdict. The
+// capture is written to the actual path so the plugin can read it.
+func (r *Runner) pluginCompare(res *report.CaseResult, s *config.OsnapConfig, buf []byte) {
+	actual := r.Layout.ActualPath(s)
+	if err := tools.WriteFile(actual, buf); err != nil {
+		slog.Warn("could not write capture for plugins", "case", s.Name, "err", err)
+		return
+	}
+	// erst nach dem Urteil entscheiden: auch ein Fail, den ein Plugin zum
+	// Pass macht, behält keine Capture
+	defer func() {
+		if res.Status == "pass" && res.Actual == "" {
+			_ = os.Remove(actual)
+		}
+	}()
+
+	v, err := r.Plugins.Compare(*res, res.Baseline, actual)
+	if err != nil {
+		res.Status = "error"
+		res.ErrorKind = "compare"
+		res.Error = err.Error()
+		return
+	}
+	if v != nil {
+		res.Status = v.Status
+		res.ComparedBy = v.Plugin
+		res.CompareMessage = v.Message
+	}
+}
+
+// cacheKey hashes everything a case's result depends on: the storybook
+// build and base config (cacheSalt), the story entry and the baseline.
+// Cases without a baseline are never cached.
+func (r *Runner) cacheKey(s *config.OsnapConfig) string {
+	if r.Cache == nil {
+		return ""
+	}
+	baselinePath := r.Layout.Baseline(s)
+	baseline, err := os.ReadFile(baselinePath)
+	if err != nil {
+		return ""
+	}
+	entry, err := json.Marshal(s)
+	if err != nil {
+		return ""
+	}
+	return cache.Key(r.CacheSalt, entry, baseline)
+}
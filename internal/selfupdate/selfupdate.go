@@ -0,0 +1,205 @@
+package selfupdate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// DefaultRepo is where release binaries are published.
+const DefaultRepo = "maxischmaxi/qsnap"
+
+// ChecksumsAsset lists "<sha256>  <asset>" lines like sha256sum writes them.
+const ChecksumsAsset = "checksums.txt"
+
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+type Release struct {
+	Tag    string  `json:"tag_name"`
+	Assets []Asset `json:"assets"`
+}
+
+// AssetName is the release binary for this platform, e.g. qsnap_linux_amd64.
+func AssetName() string {
+	name := fmt.Sprintf("qsnap_%s_%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+type Client struct {
+	HTTP *http.Client
+	Repo string
+	// API defaults to https://api.github.com
+	API string
+	// Token is sent as bearer token, e.g. $GITHUB_TOKEN against rate limits.
+	Token string
+}
+
+func (c *Client) get(ctx context.Context, url string, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	hc := c.HTTP
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+// Release fetches the release with tag, or the latest release if tag is empty.
+func (c *Client) Release(ctx context.Context, tag string) (*Release, error) {
+	api := c.API
+	if api == "" {
+		api = "https://api.github.com"
+	}
+	repo := c.Repo
+	if repo == "" {
+		repo = DefaultRepo
+	}
+	url := fmt.Sprintf("%s/repos/%s/releases/latest", strings.TrimRight(api, "/"), repo)
+	if tag != "" {
+		url = fmt.Sprintf("%s/repos/%s/releases/tags/%s", strings.TrimRight(api, "/"), repo, tag)
+	}
+	resp, err := c.get(ctx, url, "application/vnd.github+json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var rel Release
+	if err := json.NewDecoder(resp.Body).Decode(&rel); err != nil {
+		return nil, fmt.Errorf("release %s: %w", url, err)
+	}
+	return &rel, nil
+}
+
+func (r *Release) asset(name string) (Asset, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return Asset{}, false
+}
+
+// Download returns the binary of this platform from rel after checking it
+// against the release's checksums file.
+func (c *Client) Download(ctx context.Context, rel *Release) ([]byte, error) {
+	name := AssetName()
+	bin, ok := rel.asset(name)
+	if !ok {
+		return nil, fmt.Errorf("release %s has no binary %s", rel.Tag, name)
+	}
+	sums, ok := rel.asset(ChecksumsAsset)
+	if !ok {
+		return nil, fmt.Errorf("release %s has no %s, refusing to install an unverified binary", rel.Tag, ChecksumsAsset)
+	}
+
+	want, err := c.checksum(ctx, sums.URL, name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.fetch(ctx, bin.URL)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return nil, fmt.Errorf("checksum mismatch for %s: got %s, want %s", name, got, want)
+	}
+	return data, nil
+}
+
+func (c *Client) fetch(ctx context.Context, url string) ([]byte, error) {
+	resp, err := c.get(ctx, url, "application/octet-stream")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (c *Client) checksum(ctx context.Context, url, name string) (string, error) {
+	data, err := c.fetch(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		// sha256sum markiert Binärmodus mit "*" vor dem Dateinamen
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("%s has no checksum for %s", ChecksumsAsset, name)
+}
+
+// Replace swaps the running executable for data. The new binary is written
+// next to it first, so a failed write leaves the old one in place.
+func Replace(data []byte) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".qsnap-update-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0o755); err != nil {
+		return "", err
+	}
+
+	// Windows kann eine laufende Datei nicht überschreiben, aber umbenennen
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		_ = os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		return "", err
+	}
+	return exe, nil
+}
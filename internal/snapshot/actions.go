@@ -0,0 +1,49 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/chromedp/chromedp"
+	"github.com/maxischmaxi/qsnap/internal/config"
+)
+
+// defaultActionTimeout gilt für Actions ohne timeout
+const defaultActionTimeout = 10 * time.Second
+
+// storyActions übersetzt die actions einer Story: click klickt das erste
+// passende Element, wait wartet auf den Selektor oder, ohne Selektor,
+// timeout Millisekunden
+func storyActions(actions []*config.Action) []chromedp.Action {
+	var out []chromedp.Action
+	for _, a := range actions {
+		timeout := defaultActionTimeout
+		if a.Timeout != nil {
+			timeout = time.Duration(*a.Timeout) * time.Millisecond
+		}
+		var sel string
+		if a.Selector != nil {
+			sel = *a.Selector
+		}
+		switch {
+		case a.Action == "click":
+			out = append(out, chromedp.ActionFunc(func(ctx context.Context) error {
+				if err := chromedp.Run(ctx, waitAny([]string{sel}, true, timeout)); err != nil {
+					return fmt.Errorf("action click %s: %w", sel, err)
+				}
+				return chromedp.Run(ctx, chromedp.Click(sel, chromedp.ByQuery, chromedp.NodeVisible))
+			}))
+		case sel != "":
+			out = append(out, chromedp.ActionFunc(func(ctx context.Context) error {
+				if err := chromedp.Run(ctx, waitAny([]string{sel}, true, timeout)); err != nil {
+					return fmt.Errorf("action wait %s: %w", sel, err)
+				}
+				return nil
+			}))
+		default:
+			out = append(out, chromedp.Sleep(timeout))
+		}
+	}
+	return out
+}
@@ -0,0 +1,126 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// Debug collects what the page did during a capture, so a failed or errored
+// case can be diagnosed. Set Options.Debug; HTML and PNG are only filled in
+// when the capture fails and the page still responds. See HAR for the
+// requests.
+type Debug struct {
+	mu sync.Mutex
+	// requests sind offene, entries abgeschlossene HAR-Einträge
+	requests map[network.RequestID]*harEntry
+	entries  []*harEntry
+	// headers sind die Header aus der Story-Config, ihre Werte bleiben aus der HAR
+	headers map[string]string
+	// Console sind console-Ausgaben und nicht gefangene Exceptions
+	Console []string
+	// Network sind fehlgeschlagene Requests und Antworten mit Status >= 400
+	Network []string
+	HTML    string
+	PNG     []byte
+}
+
+func (d *Debug) add(list *[]string, format string, args ...any) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	*list = append(*list, time.Now().Format("15:04:05.000")+" "+fmt.Sprintf(format, args...))
+}
+
+// Lines returns copies of the collected console and network lines.
+func (d *Debug) Lines() (console, network []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.Console...), append([]string(nil), d.Network...)
+}
+
+// listenDebug hängt einen Listener an den Tab, der in t.debug sammelt; er
+// läuft bis zum Schließen der Seite, auch über wiederverwendete Captures
+func (t *Tab) listenDebug() {
+	urls := map[network.RequestID]string{}
+	var mu sync.Mutex
+	chromedp.ListenTarget(t.ctx, func(ev any) {
+		d := t.debug.Load()
+		if ev, ok := ev.(*network.EventRequestWillBeSent); ok {
+			mu.Lock()
+			urls[ev.RequestID] = ev.Request.URL
+			mu.Unlock()
+			if d != nil {
+				d.harSent(ev)
+			}
+			return
+		}
+		if d == nil {
+			return
+		}
+		switch ev := ev.(type) {
+		case *runtime.EventConsoleAPICalled:
+			args := make([]string, 0, len(ev.Args))
+			for _, a := range ev.Args {
+				args = append(args, remoteString(a))
+			}
+			d.add(&d.Console, "console.%s: %s", ev.Type, strings.Join(args, " "))
+		case *runtime.EventExceptionThrown:
+			d.add(&d.Console, "uncaught: %s", ev.ExceptionDetails.Error())
+		case *network.EventLoadingFailed:
+			mu.Lock()
+			u := urls[ev.RequestID]
+			mu.Unlock()
+			reason := ev.ErrorText
+			if ev.BlockedReason != "" {
+				reason += " (blocked: " + ev.BlockedReason.String() + ")"
+			}
+			d.add(&d.Network, "failed %s: %s", u, reason)
+			d.harDone(ev.RequestID, monoTime(ev.Timestamp), 0, reason)
+		case *network.EventResponseReceived:
+			if r := ev.Response; r != nil && r.Status >= 400 {
+				d.add(&d.Network, "%d %s", r.Status, r.URL)
+			}
+			d.harResponse(ev)
+		case *network.EventLoadingFinished:
+			d.harDone(ev.RequestID, monoTime(ev.Timestamp), ev.EncodedDataLength, "")
+		}
+	})
+}
+
+func remoteString(o *runtime.RemoteObject) string {
+	if len(o.Value) > 0 {
+		var s string
+		if json.Unmarshal(o.Value, &s) == nil {
+			return s
+		}
+		return string(o.Value)
+	}
+	if o.UnserializableValue != "" {
+		return string(o.UnserializableValue)
+	}
+	return o.Description
+}
+
+// dumpPage liest DOM und einen Screenshot der (noch lebenden) Seite nach
+// einem Fehler; hängt die Seite, bleibt beides leer
+func (t *Tab) dumpPage(d *Debug) {
+	if t.ctx == nil || t.ctx.Err() != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(t.ctx, 5*time.Second)
+	defer cancel()
+	var html string
+	var png []byte
+	_ = chromedp.Run(ctx, chromedp.OuterHTML("html", &html, chromedp.ByQuery))
+	_ = chromedp.Run(ctx, chromedp.CaptureScreenshot(&png))
+	d.mu.Lock()
+	d.HTML, d.PNG = html, png
+	d.mu.Unlock()
+}
@@ -0,0 +1,28 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// waitFonts wartet auf document.fonts.ready, damit kein Font-Swap nach dem
+// Screenshot mehr passiert
+func waitFonts(timeout time.Duration) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		var ok bool
+		err := chromedp.Evaluate(`document.fonts ? document.fonts.ready.then(() => true) : true`, &ok,
+			func(p *runtime.EvaluateParams) *runtime.EvaluateParams { return p.WithAwaitPromise(true) },
+		).Do(ctx)
+		if err != nil {
+			return fmt.Errorf("waiting for document.fonts.ready: %w", err)
+		}
+		return nil
+	})
+}
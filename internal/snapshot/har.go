@@ -0,0 +1,250 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/maxischmaxi/qsnap/internal/version"
+)
+
+// HAR 1.2, nur die Felder, die Viewer wie Chrome DevTools brauchen
+type harLog struct {
+	Log struct {
+		Version string     `json:"version"`
+		Creator harCreator `json:"creator"`
+		Entries []harEntry `json:"entries"`
+	} `json:"log"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	// die HAR-Spec erlaubt eigene Felder mit Unterstrich
+	Error string `json:"_error,omitempty"`
+
+	started  time.Time
+	monoSent time.Time
+}
+
+type harRequest struct {
+	Method      string    `json:"method"`
+	URL         string    `json:"url"`
+	HTTPVersion string    `json:"httpVersion"`
+	Headers     []harPair `json:"headers"`
+	QueryString []harPair `json:"queryString"`
+	Cookies     []harPair `json:"cookies"`
+	HeadersSize int       `json:"headersSize"`
+	BodySize    int       `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int64      `json:"status"`
+	StatusText  string     `json:"statusText"`
+	HTTPVersion string     `json:"httpVersion"`
+	Headers     []harPair  `json:"headers"`
+	Cookies     []harPair  `json:"cookies"`
+	Content     harContent `json:"content"`
+	RedirectURL string     `json:"redirectURL"`
+	HeadersSize int        `json:"headersSize"`
+	BodySize    int        `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+type harPair struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// secretHeaders werden in der HAR nie mit Wert geschrieben, sie wird als
+// CI-Artefakt hochgeladen
+var secretHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// harHeaders schreibt die Header, ohne die Werte von secretHeaders und den
+// Headern aus der Story-Config (extra)
+func harHeaders(h network.Headers, extra map[string]string) []harPair {
+	out := []harPair{}
+	for k, v := range h {
+		value := fmt.Sprint(v)
+		if secretHeader(k, extra) {
+			value = "[redacted]"
+		}
+		out = append(out, harPair{Name: k, Value: value})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func secretHeader(name string, extra map[string]string) bool {
+	for _, s := range secretHeaders {
+		if strings.EqualFold(name, s) {
+			return true
+		}
+	}
+	for s := range extra {
+		if strings.EqualFold(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func harQuery(raw string) []harPair {
+	out := []harPair{}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return out
+	}
+	for k, vs := range u.Query() {
+		for _, v := range vs {
+			out = append(out, harPair{Name: k, Value: v})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// harSent startet einen Eintrag; bei einem Redirect kommt dieselbe RequestID
+// erneut, der alte Eintrag wird dann mit der Redirect-Antwort abgeschlossen
+func (d *Debug) harSent(ev *network.EventRequestWillBeSent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.requests == nil {
+		d.requests = map[network.RequestID]*harEntry{}
+	}
+	if prev := d.requests[ev.RequestID]; prev != nil && ev.RedirectResponse != nil {
+		prev.Response = harResponseOf(ev.RedirectResponse, d.headers)
+		prev.Response.RedirectURL = ev.Request.URL
+		prev.finish(monoTime(ev.Timestamp))
+		d.entries = append(d.entries, prev)
+	}
+	e := &harEntry{
+		started:  time.Now(),
+		monoSent: monoTime(ev.Timestamp),
+		Request: harRequest{
+			Method:      ev.Request.Method,
+			URL:         ev.Request.URL + ev.Request.URLFragment,
+			HTTPVersion: "HTTP/1.1",
+			Headers:     harHeaders(ev.Request.Headers, d.headers),
+			QueryString: harQuery(ev.Request.URL),
+			Cookies:     []harPair{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Response: harResponse{Headers: []harPair{}, Cookies: []harPair{}, HeadersSize: -1, BodySize: -1},
+	}
+	if ev.WallTime != nil {
+		e.started = ev.WallTime.Time()
+	}
+	e.StartedDateTime = e.started.Format(time.RFC3339Nano)
+	d.requests[ev.RequestID] = e
+}
+
+func harResponseOf(r *network.Response, extra map[string]string) harResponse {
+	proto := r.Protocol
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	return harResponse{
+		Status:      r.Status,
+		StatusText:  r.StatusText,
+		HTTPVersion: proto,
+		Headers:     harHeaders(r.Headers, extra),
+		Cookies:     []harPair{},
+		Content:     harContent{Size: -1, MimeType: r.MimeType},
+		HeadersSize: -1,
+		BodySize:    -1,
+	}
+}
+
+func (d *Debug) harResponse(ev *network.EventResponseReceived) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e := d.requests[ev.RequestID]
+	if e == nil || ev.Response == nil {
+		return
+	}
+	e.Response = harResponseOf(ev.Response, d.headers)
+	if t := ev.Response.Timing; t != nil && t.SendEnd >= 0 && t.ReceiveHeadersEnd >= t.SendEnd {
+		e.Timings.Send = max(t.SendEnd-t.SendStart, 0)
+		e.Timings.Wait = t.ReceiveHeadersEnd - t.SendEnd
+	}
+}
+
+// harDone schließt einen Eintrag ab; errText ist bei Fehlern gesetzt
+func (d *Debug) harDone(id network.RequestID, at time.Time, size float64, errText string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e := d.requests[id]
+	if e == nil {
+		return
+	}
+	delete(d.requests, id)
+	if size > 0 {
+		e.Response.BodySize = int(size)
+		e.Response.Content.Size = int(size)
+	}
+	e.Error = errText
+	e.finish(at)
+	d.entries = append(d.entries, e)
+}
+
+func monoTime(t *cdp.MonotonicTime) time.Time {
+	if t == nil {
+		return time.Now()
+	}
+	return t.Time()
+}
+
+func (e *harEntry) finish(at time.Time) {
+	e.Time = float64(at.Sub(e.monoSent)) / float64(time.Millisecond)
+	e.Timings.Receive = max(e.Time-e.Timings.Send-e.Timings.Wait, 0)
+}
+
+// HAR returns the requests of the capture as a HAR 1.2 file. Requests that
+// never finished are included with the time up to now.
+func (d *Debug) HAR() ([]byte, error) {
+	d.mu.Lock()
+	entries := make([]harEntry, 0, len(d.entries)+len(d.requests))
+	for _, e := range d.entries {
+		entries = append(entries, *e)
+	}
+	for _, e := range d.requests {
+		open := *e
+		open.Error = "not finished"
+		open.Time = float64(time.Since(open.started)) / float64(time.Millisecond)
+		entries = append(entries, open)
+	}
+	d.mu.Unlock()
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].started.Before(entries[j].started) })
+
+	var h harLog
+	h.Log.Version = "1.2"
+	h.Log.Creator = harCreator{Name: "qsnap", Version: version.Get().Version}
+	h.Log.Entries = entries
+	return json.MarshalIndent(h, "", "  ")
+}
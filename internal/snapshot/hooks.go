@@ -0,0 +1,48 @@
+package snapshot
+
+import (
+	"sync"
+
+	"github.com/chromedp/chromedp"
+)
+
+type Stage int
+
+const (
+	// BeforeScreenshot läuft nach dem Warten auf die Selektoren, direkt vor dem Screenshot
+	BeforeScreenshot Stage = iota
+	// AfterScreenshot läuft im selben Tab, nachdem der Screenshot aufgenommen wurde
+	AfterScreenshot
+)
+
+// Hook builds the action to run for one capture. Returning nil skips it.
+type Hook func(url string, opts Options) chromedp.Action
+
+var (
+	hooksMu sync.RWMutex
+	hooks   = map[Stage][]Hook{}
+)
+
+// RegisterHook adds a hook that runs for every capture at the given stage,
+// in registration order.
+func RegisterHook(stage Stage, h Hook) {
+	if h == nil {
+		return
+	}
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	hooks[stage] = append(hooks[stage], h)
+}
+
+func hookActions(stage Stage, url string, opts Options) []chromedp.Action {
+	hooksMu.RLock()
+	defer hooksMu.RUnlock()
+
+	var out []chromedp.Action
+	for _, h := range hooks[stage] {
+		if a := h(url, opts); a != nil {
+			out = append(out, a)
+		}
+	}
+	return out
+}
@@ -0,0 +1,175 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+	"github.com/maxischmaxi/qsnap/internal/config"
+)
+
+// intercept pauses the tab's requests and continues them with opts.Headers
+// added if they go to the origin of pageURL. Requests to other hosts keep
+// their headers, so tokens and credentials never leave the story's host.
+// Requests matching a mock are answered with its fixture, requests to hosts
+// blocked by BlockHosts or AllowHosts fail.
+func intercept(pageURL string, opts Options) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		all := len(opts.BlockHosts) > 0 || len(opts.AllowHosts) > 0 || len(opts.Mocks) > 0
+		if len(opts.Headers) == 0 && !all {
+			return nil
+		}
+		u, err := url.Parse(pageURL)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("intercept: cannot get the host of %q", pageURL)
+		}
+		origin := u.Scheme + "://" + u.Host
+
+		chromedp.ListenTarget(ctx, func(ev any) {
+			e, ok := ev.(*fetch.EventRequestPaused)
+			if !ok {
+				return
+			}
+			// der Listener darf nicht blockieren, CDP-Aufrufe laufen daneben
+			go func() {
+				c := chromedp.FromContext(ctx)
+				if c == nil || c.Target == nil {
+					return
+				}
+				exec := cdp.WithExecutor(ctx, c.Target)
+				if e.Request.Method == "OPTIONS" {
+					if a := preflight(opts.Mocks, e.Request.URL, e.RequestID); a != nil {
+						_ = a.Do(exec)
+						return
+					}
+				}
+				if m := findMock(opts.Mocks, e.Request.Method, e.Request.URL); m != nil {
+					_ = fulfill(m, e.RequestID).Do(exec)
+					return
+				}
+				ru, err := url.Parse(e.Request.URL)
+				if err == nil && blocked(ru.Hostname(), u.Hostname(), opts) {
+					_ = fetch.FailRequest(e.RequestID, network.ErrorReasonBlockedByClient).Do(exec)
+					return
+				}
+				req := fetch.ContinueRequest(e.RequestID)
+				if len(opts.Headers) > 0 && err == nil && ru.Scheme+"://"+ru.Host == origin {
+					req = req.WithHeaders(withHeaders(e.Request.Headers, opts.Headers))
+				}
+				_ = req.Do(exec)
+			}()
+		})
+		// ohne Block-Regeln und Mocks nur die Requests an den Story-Host anhalten
+		pattern := origin + "/*"
+		if all {
+			pattern = "*"
+		}
+		return fetch.Enable().
+			WithPatterns([]*fetch.RequestPattern{{URLPattern: pattern, RequestStage: fetch.RequestStageRequest}}).
+			Do(ctx)
+	})
+}
+
+func findMock(mocks []config.Mock, method, url string) *config.Mock {
+	for i := range mocks {
+		if mocks[i].Match(method, url) {
+			return &mocks[i]
+		}
+	}
+	return nil
+}
+
+// preflight beantwortet den CORS-Preflight für gemockte fremde APIs, die
+// echte API wird dafür nicht gefragt
+func preflight(mocks []config.Mock, url string, id fetch.RequestID) chromedp.Action {
+	for i := range mocks {
+		if mocks[i].MatchURL(url) {
+			return fetch.FulfillRequest(id, 204).WithResponseHeaders([]*fetch.HeaderEntry{
+				{Name: "Access-Control-Allow-Origin", Value: "*"},
+				{Name: "Access-Control-Allow-Methods", Value: "*"},
+				{Name: "Access-Control-Allow-Headers", Value: "*"},
+			})
+		}
+	}
+	return nil
+}
+
+// fulfill beantwortet den Request mit der Fixture; ist sie nicht lesbar,
+// scheitert er, statt unbemerkt ins Netz zu gehen
+func fulfill(m *config.Mock, id fetch.RequestID) chromedp.Action {
+	status, headers, body, err := m.Response()
+	if err != nil {
+		return fetch.FailRequest(id, network.ErrorReasonFailed)
+	}
+	entries := make([]*fetch.HeaderEntry, 0, len(headers))
+	for k, v := range headers {
+		entries = append(entries, &fetch.HeaderEntry{Name: k, Value: v})
+	}
+	// ohne CORS-Header würde ein Mock für eine fremde API aus dem iframe blockiert
+	if _, ok := headers["Access-Control-Allow-Origin"]; !ok {
+		entries = append(entries, &fetch.HeaderEntry{Name: "Access-Control-Allow-Origin", Value: "*"})
+	}
+	return fetch.FulfillRequest(id, int64(status)).
+		WithResponseHeaders(entries).
+		WithBody(base64.StdEncoding.EncodeToString(body))
+}
+
+// blocked reports whether a request to host must fail. The story's own host
+// is never blocked by AllowHosts.
+func blocked(host, storyHost string, opts Options) bool {
+	if host == "" {
+		return false
+	}
+	for _, p := range opts.BlockHosts {
+		if matchHost(host, p) {
+			return true
+		}
+	}
+	if len(opts.AllowHosts) == 0 || host == storyHost {
+		return false
+	}
+	for _, p := range opts.AllowHosts {
+		if matchHost(host, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchHost: "example.com" und "*.example.com" treffen den Host selbst und
+// alle Subdomains
+func matchHost(host, pattern string) bool {
+	pattern = strings.ToLower(strings.TrimPrefix(pattern, "*."))
+	host = strings.ToLower(host)
+	return host == pattern || strings.HasSuffix(host, "."+pattern)
+}
+
+// withHeaders ersetzt gleichnamige Header unabhängig von der Schreibweise
+func withHeaders(orig map[string]any, extra map[string]string) []*fetch.HeaderEntry {
+	out := make([]*fetch.HeaderEntry, 0, len(orig)+len(extra))
+	for k, v := range orig {
+		if _, ok := lookupFold(extra, k); ok {
+			continue
+		}
+		out = append(out, &fetch.HeaderEntry{Name: k, Value: fmt.Sprint(v)})
+	}
+	for k, v := range extra {
+		out = append(out, &fetch.HeaderEntry{Name: k, Value: v})
+	}
+	return out
+}
+
+func lookupFold(m map[string]string, key string) (string, bool) {
+	for k, v := range m {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
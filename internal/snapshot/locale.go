@@ -0,0 +1,77 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/chromedp"
+)
+
+// emulateLocale sets timezone, locale and geolocation of the tab. The locale
+// covers Intl, navigator.language and the Accept-Language header.
+func emulateLocale(pageURL string, opts Options) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		if opts.Timezone != "" {
+			if err := emulation.SetTimezoneOverride(opts.Timezone).Do(ctx); err != nil {
+				return fmt.Errorf("timezone %q: %w", opts.Timezone, err)
+			}
+		}
+		if opts.Locale != "" {
+			if err := emulation.SetLocaleOverride().WithLocale(opts.Locale).Do(ctx); err != nil {
+				return fmt.Errorf("locale %q: %w", opts.Locale, err)
+			}
+			if err := acceptLanguage(ctx, opts); err != nil {
+				return err
+			}
+		}
+		if g := opts.Geolocation; g != nil {
+			if err := grantGeolocation(ctx, pageURL); err != nil {
+				return err
+			}
+			accuracy := g.Accuracy
+			if accuracy == 0 {
+				accuracy = 1
+			}
+			return emulation.SetGeolocationOverride().WithLatitude(g.Latitude).WithLongitude(g.Longitude).WithAccuracy(accuracy).Do(ctx)
+		}
+		return nil
+	})
+}
+
+// acceptLanguage geht nur über den User-Agent-Override; dabei bleibt der
+// User Agent des Geräts bzw. des Browsers erhalten
+func acceptLanguage(ctx context.Context, opts Options) error {
+	ua := ""
+	if opts.Device != nil {
+		ua = opts.Device.UserAgent
+	} else {
+		_, _, _, userAgent, _, err := browser.GetVersion().Do(ctx)
+		if err != nil {
+			return err
+		}
+		ua = userAgent
+	}
+	return emulation.SetUserAgentOverride(ua).WithAcceptLanguage(opts.Locale).Do(ctx)
+}
+
+// grantGeolocation erlaubt navigator.geolocation für den Origin der Story,
+// sonst bliebe die Abfrage headless unbeantwortet
+func grantGeolocation(ctx context.Context, pageURL string) error {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return err
+	}
+	c := chromedp.FromContext(ctx)
+	if c == nil || c.Browser == nil {
+		return nil
+	}
+	p := browser.GrantPermissions([]browser.PermissionType{browser.PermissionTypeGeolocation}).WithOrigin(u.Scheme + "://" + u.Host)
+	if c.BrowserContextID != "" {
+		p = p.WithBrowserContextID(c.BrowserContextID)
+	}
+	return p.Do(cdp.WithExecutor(ctx, c.Browser))
+}
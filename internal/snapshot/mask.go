@@ -0,0 +1,63 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/chromedp/chromedp"
+	"github.com/maxischmaxi/qsnap/internal/config"
+)
+
+// maskScript legt für jede Region ein schwarzes Overlay über die Seite und
+// gibt die Rechtecke in Seitenkoordinaten zurück
+const maskScript = `(function (masks) {
+	const out = [];
+	for (const m of masks) {
+		const rects = [];
+		if (m.selector) {
+			document.querySelectorAll(m.selector).forEach(function (el) {
+				const r = el.getBoundingClientRect();
+				if (r.width > 0 && r.height > 0) {
+					rects.push({
+						x: Math.floor(r.left + window.scrollX),
+						y: Math.floor(r.top + window.scrollY),
+						width: Math.ceil(r.width),
+						height: Math.ceil(r.height),
+					});
+				}
+			});
+		} else {
+			rects.push({ x: m.x, y: m.y, width: m.width, height: m.height });
+		}
+		for (const r of rects) {
+			const d = document.createElement("div");
+			d.setAttribute("data-qsnap-mask", "");
+			d.style.cssText = "position:absolute;background:#000;pointer-events:none;z-index:2147483647;" +
+				"left:" + r.x + "px;top:" + r.y + "px;width:" + r.width + "px;height:" + r.height + "px";
+			document.body.appendChild(d);
+			out.push(r);
+		}
+	}
+	return out;
+})(%s)`
+
+func applyMasks(masks []config.IgnoreRegion, out *[]config.Rect) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		js, err := MaskScript(masks)
+		if err != nil {
+			return err
+		}
+		return chromedp.Evaluate(js, out).Do(ctx)
+	})
+}
+
+// MaskScript is an expression that covers masks with black overlays and
+// returns the covered rectangles in page coordinates.
+func MaskScript(masks []config.IgnoreRegion) (string, error) {
+	b, err := json.Marshal(masks)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(maskScript, b), nil
+}
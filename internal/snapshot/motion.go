@@ -0,0 +1,50 @@
+package snapshot
+
+import (
+	"context"
+
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+const noMotionCSS = `*, *::before, *::after { animation: none !important; transition: none !important; }`
+
+// NoMotionScript disables CSS animations and transitions. It runs for every
+// new document, before the story starts its first animations.
+const NoMotionScript = `(function () {
+	function add() {
+		const s = document.createElement("style");
+		s.setAttribute("data-qsnap", "no-motion");
+		s.textContent = ` + "`" + noMotionCSS + "`" + `;
+		(document.head || document.documentElement).appendChild(s);
+	}
+	if (document.documentElement) {
+		add();
+	} else {
+		document.addEventListener("readystatechange", add, { once: true });
+	}
+})()`
+
+func emulateMedia(opts Options) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		var features []*emulation.MediaFeature
+		if opts.DisableAnimations {
+			features = append(features, &emulation.MediaFeature{Name: "prefers-reduced-motion", Value: "reduce"})
+		}
+		if opts.ColorScheme != "" {
+			features = append(features, &emulation.MediaFeature{Name: "prefers-color-scheme", Value: opts.ColorScheme})
+		}
+		if len(features) == 0 {
+			return nil
+		}
+		return emulation.SetEmulatedMedia().WithFeatures(features).Do(ctx)
+	})
+}
+
+func disableMotion() chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		_, err := page.AddScriptToEvaluateOnNewDocument(NoMotionScript).Do(ctx)
+		return err
+	})
+}
@@ -0,0 +1,68 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// netTracker counts in-flight requests of a tab from CDP network events.
+type netTracker struct {
+	mu       sync.Mutex
+	inflight map[network.RequestID]bool
+	last     time.Time
+}
+
+// trackNetwork must be called before the first Run on tabCtx so the listener
+// sees the navigation's requests.
+func trackNetwork(tabCtx context.Context) *netTracker {
+	t := &netTracker{inflight: map[network.RequestID]bool{}, last: time.Now()}
+	chromedp.ListenTarget(tabCtx, func(ev any) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		switch ev := ev.(type) {
+		case *network.EventRequestWillBeSent:
+			// Redirects kommen mit derselben RequestID erneut
+			t.inflight[ev.RequestID] = true
+		case *network.EventLoadingFinished:
+			delete(t.inflight, ev.RequestID)
+		case *network.EventLoadingFailed:
+			delete(t.inflight, ev.RequestID)
+		default:
+			return
+		}
+		t.last = time.Now()
+	})
+	return t
+}
+
+func (t *netTracker) state() (int, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.inflight), time.Since(t.last)
+}
+
+// waitNetworkIdle waits until no request has been in flight for idle.
+func waitNetworkIdle(t *netTracker, idle, timeout time.Duration) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		deadline := time.Now().Add(timeout)
+		for {
+			n, quiet := t.state()
+			if n == 0 && quiet >= idle {
+				return nil
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("timeout waiting for network idle (%d requests in flight)", n)
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(25 * time.Millisecond):
+			}
+		}
+	})
+}
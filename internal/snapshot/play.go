@@ -0,0 +1,77 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+// ErrPlayFailed is returned if the story's play function threw.
+var ErrPlayFailed = errors.New("play function failed")
+
+// playListener hängt sich an den Preview-Channel, sobald Storybook ihn
+// anlegt, und merkt sich das Ende der play-Funktion in window.__QSNAP_PLAY__
+const playListener = `(() => {
+	const listen = (ch) => {
+		ch.on('storyRendered', () => { window.__QSNAP_PLAY__ = 'done'; });
+		for (const ev of ['playFunctionThrewException', 'storyThrewException', 'storyErrored']) {
+			ch.on(ev, (e) => { window.__QSNAP_PLAY__ = 'error:' + ((e && (e.message || e.title)) || ev); });
+		}
+	};
+	const poll = () => {
+		const ch = window.__STORYBOOK_ADDONS_CHANNEL__;
+		if (ch && ch.on) { listen(ch); } else { setTimeout(poll, 5); }
+	};
+	poll();
+})();`
+
+// playState fällt auf die Render-Phase des Previews zurück, falls das Event
+// vor dem Listener kam
+const playState = `(() => {
+	if (window.__QSNAP_PLAY__) return window.__QSNAP_PLAY__;
+	const r = window.__STORYBOOK_PREVIEW__ && window.__STORYBOOK_PREVIEW__.currentRender;
+	if (!r) return '';
+	if (['played', 'completing', 'completed', 'finished'].includes(r.phase)) return 'done';
+	if (r.phase === 'errored') return 'error:story errored';
+	return '';
+})()`
+
+func listenPlay() chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		_, err := page.AddScriptToEvaluateOnNewDocument(playListener).Do(ctx)
+		return err
+	})
+}
+
+// waitPlay waits until Storybook reports the story rendered, which for
+// Storybook 7+ is after its play function finished.
+func waitPlay(timeout time.Duration) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		deadline := time.Now().Add(timeout)
+		for {
+			var state string
+			if err := chromedp.Evaluate(playState, &state).Do(ctx); err != nil {
+				return err
+			}
+			if state == "done" {
+				return nil
+			}
+			if msg, ok := strings.CutPrefix(state, "error:"); ok {
+				return fmt.Errorf("%w: %s", ErrPlayFailed, msg)
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("play function did not complete within %s", timeout)
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+	})
+}
@@ -0,0 +1,42 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+// SeedGlobal ist der Storybook-Global, über den ein Decorator den Seed bekommt.
+const SeedGlobal = "seed"
+
+// WithSeedGlobal adds seed to the Storybook globals URL parameter
+// (globals=a:b;seed:42), keeping globals the story URL already sets.
+func WithSeedGlobal(rawURL string, seed int) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	q := u.Query()
+	var parts []string
+	for _, p := range strings.Split(q.Get("globals"), ";") {
+		if p != "" && !strings.HasPrefix(p, SeedGlobal+":") {
+			parts = append(parts, p)
+		}
+	}
+	parts = append(parts, fmt.Sprintf("%s:%d", SeedGlobal, seed))
+	q.Set("globals", strings.Join(parts, ";"))
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// injectSeed setzt window.__QSNAP_SEED__ vor jedem Skript der Seite
+func injectSeed(seed int) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		_, err := page.AddScriptToEvaluateOnNewDocument(fmt.Sprintf("window.__QSNAP_SEED__ = %d;", seed)).Do(ctx)
+		return err
+	})
+}
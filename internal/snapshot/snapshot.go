@@ -2,39 +2,40 @@ package snapshot
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/target"
 	"github.com/chromedp/chromedp"
+	"github.com/chromedp/chromedp/device"
 	"github.com/maxischmaxi/qsnap/internal/browser"
+	"github.com/maxischmaxi/qsnap/internal/config"
 )
 
-func ParseSelectors(csv string) []string {
-	parts := strings.Split(csv, ",")
-	var out []string
-	for _, p := range parts {
-		p = strings.TrimSpace(p)
-		if p != "" {
-			out = append(out, p)
-		}
-	}
-	if len(out) == 0 {
-		out = []string{"body"}
-	}
-	return out
-}
+// ErrHung is returned when a capture did not stop after its context ended,
+// e.g. because the renderer is blocked inside a CDP call.
+var ErrHung = errors.New("capture hung after timeout, tab was force-closed")
 
-func waitAny(selectors []string, timeout time.Duration) chromedp.Action {
+// waitAny wartet, bis einer der Selektoren im DOM (bzw. sichtbar) ist
+func waitAny(selectors []string, visible bool, timeout time.Duration) chromedp.Action {
 	return chromedp.ActionFunc(func(ctx context.Context) error {
 		deadline := time.Now().Add(timeout)
 		for {
 			for _, sel := range selectors {
-				if err := chromedp.Run(ctx, chromedp.WaitReady(sel, chromedp.ByQuery)); err == nil {
+				if found(ctx, sel, visible) {
 					return nil
 				}
 			}
 			if time.Now().After(deadline) {
+				if visible {
+					return fmt.Errorf("timeout waiting for any of %s to become visible", strings.Join(selectors, ", "))
+				}
 				return errors.New("timeout waiting for any selector")
 			}
 			time.Sleep(50 * time.Millisecond)
@@ -42,23 +43,365 @@ func waitAny(selectors []string, timeout time.Duration) chromedp.Action {
 	})
 }
 
-func Capture(ctx context.Context, inst *browser.Instance, url, outPath string, vw, vh int, waitSelectors []string) ([]byte, error) {
-	tabCtx, cancel := chromedp.NewContext(inst.Ctx)
-	defer cancel()
+// found prüft einmal, ohne zu warten: chromedp.WaitReady/WaitVisible würden
+// bei mehreren Selektoren am ersten hängen bleiben
+func found(ctx context.Context, sel string, visible bool) bool {
+	var ok bool
+	if err := chromedp.Run(ctx, chromedp.Evaluate(FoundScript(sel, visible), &ok)); err != nil {
+		return false
+	}
+	return ok
+}
+
+// FoundScript is an expression that is true once sel matches an element,
+// with visible one that has a size and isn't hidden.
+func FoundScript(sel string, visible bool) string {
+	return fmt.Sprintf(`(() => {
+		const el = document.querySelector(%q);
+		if (!el) return false;
+		if (!%t) return true;
+		const r = el.getBoundingClientRect(), s = getComputedStyle(el);
+		return r.width > 0 && r.height > 0 && s.visibility !== "hidden" && s.display !== "none";
+	})()`, sel, visible)
+}
+
+// ErrorKind classifies a Capture error for CaseResult.ErrorKind.
+func ErrorKind(err error) string {
+	switch {
+	case errors.Is(err, ErrHung):
+		return "hung"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, ErrPlayFailed):
+		return "play"
+	}
+	return "capture"
+}
+
+type Options struct {
+	Width  int
+	Height int
+	// Device emuliert Pixel Ratio, User Agent und Touch; der Viewport kommt aus Width/Height
+	Device        *device.Info
+	WaitSelectors []string
+	// WaitVisible wartet, bis ein WaitSelector sichtbar ist, nicht nur im DOM
+	WaitVisible bool
+	// WaitPlay wartet auf das storyRendered-Event, also das Ende der play-Funktion
+	WaitPlay bool
+	// Selector beschränkt den Screenshot auf die Bounding Box des ersten Treffers
+	Selector string
+	// FullPage nimmt die gesamte Scrollhöhe auf statt nur den Viewport
+	FullPage bool
+	// Stitch nimmt FullPage beim Scrollen in Viewport-Abschnitten auf
+	Stitch bool
+	// FreezeFixed macht fixed/sticky Elemente vorher zu normalen (nur mit Stitch)
+	FreezeFixed bool
+	// ColorScheme emuliert prefers-color-scheme ("light" oder "dark"), leer = Browser-Default
+	ColorScheme string
+	// Timezone, Locale und Geolocation werden emuliert, leer bzw. nil = Browser-Default
+	Timezone    string
+	Locale      string
+	Geolocation *config.Geolocation
+	// DisableAnimations schaltet CSS-Animationen/Transitions ab und emuliert prefers-reduced-motion
+	DisableAnimations bool
+	// Masks werden vor dem Screenshot schwarz überdeckt
+	Masks []config.IgnoreRegion
+	// NetworkIdle wartet nach dem Laden, bis so lange kein Request mehr offen war (0 = aus)
+	NetworkIdle time.Duration
+	// Settle ist eine zusätzliche Pause nach dem Laden der Fonts
+	Settle time.Duration
+	// Stabilize ist die Höchstzahl Screenshots, bis zwei gleich sind (< 2 = aus)
+	Stabilize         int
+	StabilizeInterval time.Duration
+	// Seed wird als window.__QSNAP_SEED__ injiziert (nil = aus)
+	Seed *int
+	// HangGrace ist die Zeit nach Ablauf von ctx, bevor ein Capture als hängend gilt (Default 5s)
+	HangGrace time.Duration
+	// Headers gehen mit jedem Request an den Host der Story, z.B. Authorization
+	Headers map[string]string
+	// BlockHosts und AllowHosts lassen Requests an fremde Hosts scheitern
+	BlockHosts []string
+	AllowHosts []string
+	// Mocks beantworten passende Requests mit Fixtures
+	Mocks []config.Mock
+	// Cookies und LocalStorage werden vor dem Laden gesetzt; der Tab bekommt
+	// dann einen eigenen Browser-Kontext
+	Cookies      []config.Cookie
+	LocalStorage map[string]string
+	// Actions sind die actions der Story, sie laufen vor dem Maskieren
+	Actions []chromedp.Action
+	// Before/After laufen zusätzlich zu den registrierten Hooks
+	Before []chromedp.Action
+	After  []chromedp.Action
+	// Debug sammelt Konsole, Netzwerkfehler und bei einem Fehler DOM und Screenshot
+	Debug *Debug
+}
+
+type Result struct {
+	PNG []byte
+	// Masked enthält die tatsächlich überdeckten Bereiche in Seitenkoordinaten
+	Masked []config.Rect
+	// Unstable: mit Stabilize waren keine zwei Screenshots gleich
+	Unstable bool
+}
+
+func Capture(ctx context.Context, inst *browser.Instance, url string, opts Options) (*Result, error) {
+	t := &Tab{}
+	t.Bind(inst, nil)
+	defer t.Close()
+	return t.Capture(ctx, url, opts)
+}
+
+// Tab is a page that stays open between captures (reuseViewport). Capturing
+// the same URL with the same page options again only resizes the viewport
+// instead of loading and rendering the story again.
+type Tab struct {
+	inst    *browser.Instance
+	release func()
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	tracker *netTracker
+	// key ist der pageKey der geladenen Seite, "" = nichts geladen
+	key string
+	// frozen: freezeFixed hat das Layout der Seite verändert
+	frozen bool
+	// debug ist Options.Debug des laufenden Captures, siehe listenDebug
+	debug atomic.Pointer[Debug]
+}
+
+// Bind attaches the tab to inst. release is called by Close, e.g. to give
+// the tab slot of browser.Instances.Acquire back.
+func (t *Tab) Bind(inst *browser.Instance, release func()) {
+	t.inst, t.release = inst, release
+}
+
+// Instance returns the instance the tab is bound to, nil before Bind.
+func (t *Tab) Instance() *browser.Instance { return t.inst }
+
+// Close closes the page and releases the instance.
+func (t *Tab) Close() {
+	t.closePage()
+	if t.release != nil {
+		t.release()
+	}
+	t.inst, t.release = nil, nil
+}
+
+// Reset closes the loaded page, so the next Capture loads it again. The tab
+// keeps its browser instance.
+func (t *Tab) Reset() {
+	t.closePage()
+}
+
+func (t *Tab) closePage() {
+	if t.cancel != nil {
+		t.cancel()
+	}
+	t.ctx, t.cancel, t.tracker, t.key, t.frozen = nil, nil, nil, "", false
+}
+
+// pageKey sind die Optionen, die beim Laden der Seite wirken; Größe, Masken
+// und Ausschnitt dürfen sich zwischen zwei Captures eines Tabs ändern
+func pageKey(url string, opts Options) string {
+	dev, seed, geo := "", "", ""
+	if opts.Device != nil {
+		dev = opts.Device.Name
+	}
+	if opts.Seed != nil {
+		seed = strconv.Itoa(*opts.Seed)
+	}
+	if g := opts.Geolocation; g != nil {
+		geo = fmt.Sprint(*g)
+	}
+	return strings.Join([]string{url, dev, opts.ColorScheme, strconv.FormatBool(opts.DisableAnimations), seed,
+		strconv.FormatBool(opts.WaitPlay), opts.NetworkIdle.String(), opts.Timezone, opts.Locale, geo,
+		jsonKey(opts.Cookies), jsonKey(opts.LocalStorage),
+		jsonKey(opts.Mocks), jsonKey(opts.BlockHosts), jsonKey(opts.AllowHosts),
+		jsonKey(opts.Headers)}, "\x00") // Headers enthält auch basicAuth
+}
+
+// jsonKey macht Listen und Maps vergleichbar; json sortiert die Map-Schlüssel
+func jsonKey(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// Capture takes a screenshot in the tab. It reloads the page unless the
+// previous capture had the same URL and page options; story actions and
+// Before and After actions always get a fresh page, since they can change it. After an error
+// the page is closed and the next capture starts over.
+func (t *Tab) Capture(ctx context.Context, url string, opts Options) (*Result, error) {
+	t.inst.Captured()
+	key := pageKey(url, opts)
+	reuse := t.ctx != nil && t.ctx.Err() == nil && t.key == key && !t.frozen && len(opts.Actions) == 0 && len(opts.Before) == 0 && len(opts.After) == 0
+	if !reuse {
+		t.closePage()
+		var ctxOpts []chromedp.ContextOption
+		if len(opts.Cookies) > 0 || len(opts.LocalStorage) > 0 {
+			// Cookies und localStorage teilen sich sonst alle Tabs der Instanz
+			ctxOpts = append(ctxOpts, chromedp.WithNewBrowserContext())
+		}
+		t.ctx, t.cancel = chromedp.NewContext(t.inst.Context(), ctxOpts...)
+		if opts.NetworkIdle > 0 {
+			t.tracker = trackNetwork(t.ctx)
+		}
+		if opts.Debug != nil {
+			t.listenDebug()
+		}
+	}
+	if opts.Debug != nil {
+		opts.Debug.mu.Lock()
+		opts.Debug.headers = opts.Headers
+		opts.Debug.mu.Unlock()
+	}
+	t.debug.Store(opts.Debug)
+	defer t.debug.Store(nil)
+
+	res := &Result{}
+	var actions []chromedp.Action
+	if reuse {
+		actions = resizeActions(opts)
+	} else {
+		actions = loadActions(url, opts, t.tracker)
+	}
+	actions = append(actions, opts.Actions...)
+	if len(opts.Masks) > 0 {
+		actions = append(actions, applyMasks(opts.Masks, &res.Masked))
+	}
+	actions = append(actions, hookActions(BeforeScreenshot, url, opts)...)
+	actions = append(actions, opts.Before...)
+	actions = append(actions, stableScreenshot(opts, res))
+	actions = append(actions, opts.After...)
+	actions = append(actions, hookActions(AfterScreenshot, url, opts)...)
+
+	if err := t.run(ctx, actions, opts.HangGrace); err != nil {
+		if opts.Debug != nil {
+			t.dumpPage(opts.Debug)
+		}
+		t.closePage()
+		return nil, err
+	}
+	t.key = key
+	t.frozen = opts.FullPage && opts.Stitch && opts.FreezeFixed
+	return res, nil
+}
 
+func loadActions(url string, opts Options, tracker *netTracker) []chromedp.Action {
 	// Set viewport und navigate
-	var buf []byte
-	err := chromedp.Run(tabCtx,
-		chromedp.EmulateViewport(int64(vw), int64(vh)),
+	actions := []chromedp.Action{
+		emulateViewport(opts),
+		emulateMedia(opts),
+		emulateLocale(url, opts),
+	}
+	if opts.DisableAnimations {
+		actions = append(actions, disableMotion())
+	}
+	if opts.Seed != nil {
+		actions = append(actions, injectSeed(*opts.Seed))
+	}
+	if opts.WaitPlay {
+		actions = append(actions, listenPlay())
+	}
+	actions = append(actions,
+		seedState(url, opts),
+		intercept(url, opts),
 		chromedp.Navigate(url),
 		chromedp.WaitReady("body", chromedp.ByQuery), // Grundvoraussetzung
-		waitAny(waitSelectors, 10*time.Second),
-		chromedp.Sleep(50*time.Millisecond), // kleines settle gegen Fonts/Transitions
-		chromedp.FullScreenshot(&buf, 100),
+		waitAny(opts.WaitSelectors, opts.WaitVisible, 10*time.Second),
 	)
-	if err != nil {
-		return nil, err
+	if opts.WaitPlay {
+		actions = append(actions, waitPlay(10*time.Second))
+	}
+	if tracker != nil {
+		actions = append(actions, waitNetworkIdle(tracker, opts.NetworkIdle, 10*time.Second))
 	}
+	return append(actions,
+		waitFonts(10*time.Second),
+		chromedp.Sleep(50*time.Millisecond+opts.Settle), // kleines settle gegen Transitions
+	)
+}
 
-	return buf, nil
+// resizeActions bringen eine schon geladene Seite auf die nächste Größe; die
+// Masken des letzten Captures liegen noch im DOM
+func resizeActions(opts Options) []chromedp.Action {
+	actions := []chromedp.Action{
+		chromedp.Evaluate(`document.querySelectorAll("[data-qsnap-mask]").forEach(function (el) { el.remove(); })`, nil),
+		emulateViewport(opts),
+	}
+	if opts.Locale != "" {
+		// Emulate setzt den User Agent neu, ohne Accept-Language
+		actions = append(actions, chromedp.ActionFunc(func(ctx context.Context) error { return acceptLanguage(ctx, opts) }))
+	}
+	return append(actions,
+		waitAny(opts.WaitSelectors, opts.WaitVisible, 10*time.Second),
+		waitFonts(10*time.Second),
+		chromedp.Sleep(50*time.Millisecond+opts.Settle),
+	)
+}
+
+// run führt actions im Tab aus. Läuft ctx ab, wird der Tab beendet; reagiert
+// CDP nicht mehr, wird das Target hart geschlossen
+func (t *Tab) run(ctx context.Context, actions []chromedp.Action, grace time.Duration) error {
+	tabCtx := t.ctx
+	done := make(chan error, 1)
+	go func() { done <- chromedp.Run(tabCtx, actions...) }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	t.cancel()
+	if grace <= 0 {
+		grace = 5 * time.Second
+	}
+	select {
+	case err := <-done:
+		if err == nil {
+			return nil
+		}
+		return ctx.Err()
+	case <-time.After(grace):
+		forceClose(t.inst, tabCtx)
+		return ErrHung
+	}
+}
+
+// forceClose schließt das Target über die Browser-Session statt über den
+// (hängenden) Tab selbst
+func forceClose(inst *browser.Instance, tabCtx context.Context) {
+	c := chromedp.FromContext(tabCtx)
+	browserCtx := inst.Context()
+	bc := chromedp.FromContext(browserCtx)
+	if c == nil || c.Target == nil || bc == nil || bc.Browser == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(browserCtx, 2*time.Second)
+	defer cancel()
+	_ = target.CloseTarget(c.Target.TargetID).Do(cdp.WithExecutor(ctx, bc.Browser))
+}
+
+func emulateViewport(opts Options) chromedp.Action {
+	if opts.Device == nil {
+		return chromedp.EmulateViewport(int64(opts.Width), int64(opts.Height))
+	}
+	d := *opts.Device
+	d.Width, d.Height = int64(opts.Width), int64(opts.Height)
+	return chromedp.Emulate(d)
+}
+
+func screenshot(opts Options, buf *[]byte) chromedp.Action {
+	if opts.Selector != "" {
+		return chromedp.Screenshot(opts.Selector, buf, chromedp.ByQuery, chromedp.NodeVisible)
+	}
+	if opts.FullPage && opts.Stitch {
+		return stitchScreenshot(opts.FreezeFixed, buf)
+	}
+	if opts.FullPage {
+		return chromedp.FullScreenshot(buf, 100)
+	}
+	return chromedp.CaptureScreenshot(buf)
 }
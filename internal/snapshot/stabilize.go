@@ -0,0 +1,41 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/chromedp/chromedp"
+)
+
+// stableScreenshot takes up to opts.Stabilize screenshots opts.StabilizeInterval
+// apart and stops at the first one that equals its predecessor. If no two
+// consecutive screenshots match, the last one is used and res.Unstable set.
+func stableScreenshot(opts Options, res *Result) chromedp.Action {
+	if opts.Stabilize < 2 {
+		return screenshot(opts, &res.PNG)
+	}
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		var prev []byte
+		for i := 0; i < opts.Stabilize; i++ {
+			if i > 0 {
+				if err := chromedp.Sleep(opts.StabilizeInterval).Do(ctx); err != nil {
+					return err
+				}
+			}
+			var buf []byte
+			if err := screenshot(opts, &buf).Do(ctx); err != nil {
+				return err
+			}
+			// Chrome kodiert gleiche Pixel zu gleichen Bytes, ein Dekodieren
+			// ist für den Vergleich nicht nötig
+			if prev != nil && bytes.Equal(prev, buf) {
+				res.PNG = buf
+				return nil
+			}
+			prev = buf
+		}
+		res.PNG = prev
+		res.Unstable = true
+		return nil
+	})
+}
@@ -0,0 +1,62 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+// localStorageScript füllt localStorage nur im Origin der Story, nicht in
+// eingebetteten fremden Frames
+const localStorageScript = `(function (origin, items) {
+	if (location.origin !== origin) return;
+	try {
+		for (const k in items) localStorage.setItem(k, items[k]);
+	} catch (e) {}
+})(%s, %s);`
+
+// seedState sets opts.Cookies and opts.LocalStorage before pageURL loads.
+// Tabs with state get their own browser context, see Tab.Capture.
+func seedState(pageURL string, opts Options) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		for _, c := range opts.Cookies {
+			p := network.SetCookie(c.Name, c.Value).WithSecure(c.Secure).WithHTTPOnly(c.HTTPOnly)
+			if c.Domain != "" {
+				p = p.WithDomain(c.Domain)
+			} else {
+				p = p.WithURL(pageURL)
+			}
+			path := c.Path
+			if path == "" {
+				path = "/"
+			}
+			p = p.WithPath(path)
+			if c.SameSite != "" {
+				p = p.WithSameSite(network.CookieSameSite(c.SameSite))
+			}
+			if err := p.Do(ctx); err != nil {
+				return fmt.Errorf("cookie %s: %w", c.Name, err)
+			}
+		}
+
+		if len(opts.LocalStorage) == 0 {
+			return nil
+		}
+		u, err := url.Parse(pageURL)
+		if err != nil {
+			return err
+		}
+		origin, _ := json.Marshal(u.Scheme + "://" + u.Host)
+		items, err := json.Marshal(opts.LocalStorage)
+		if err != nil {
+			return err
+		}
+		_, err = page.AddScriptToEvaluateOnNewDocument(fmt.Sprintf(localStorageScript, origin, items)).Do(ctx)
+		return err
+	})
+}
@@ -0,0 +1,126 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"time"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+// freezeScript setzt fixed Elemente an ihre aktuelle Position im Dokument
+// und sticky Elemente in den normalen Fluss, damit sie nicht in jedem
+// Abschnitt auftauchen
+const freezeScript = `(function () {
+	for (const el of document.querySelectorAll("body *")) {
+		const pos = getComputedStyle(el).position;
+		if (pos === "fixed") {
+			const r = el.getBoundingClientRect();
+			el.style.setProperty("position", "absolute", "important");
+			el.style.setProperty("top", (r.top + window.scrollY) + "px", "important");
+			el.style.setProperty("left", (r.left + window.scrollX) + "px", "important");
+			el.style.setProperty("bottom", "auto", "important");
+			el.style.setProperty("right", "auto", "important");
+			el.style.setProperty("width", r.width + "px", "important");
+		} else if (pos === "sticky") {
+			el.style.setProperty("position", "relative", "important");
+		}
+	}
+})()`
+
+type scrollMetrics struct {
+	Height   float64 `json:"height"`
+	Viewport float64 `json:"viewport"`
+}
+
+// maxStitchSegments begrenzt endlos nachladende Seiten (Infinite Scroll)
+const maxStitchSegments = 200
+
+// stitchScreenshot scrolls through the page one viewport at a time and
+// stacks the viewport screenshots. Unlike FullScreenshot it never resizes
+// the viewport, so vh units and fixed elements keep their real size, and
+// lazy content loads while scrolling.
+func stitchScreenshot(freeze bool, buf *[]byte) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		if err := chromedp.Evaluate(`document.documentElement.style.scrollBehavior = "auto"`, nil).Do(ctx); err != nil {
+			return err
+		}
+		if freeze {
+			if err := chromedp.Evaluate(freezeScript, nil).Do(ctx); err != nil {
+				return fmt.Errorf("freezeFixed: %w", err)
+			}
+		}
+
+		var (
+			out    *image.RGBA
+			scale  float64
+			bottom int
+			prevY  = -1.0
+		)
+		for i := 0; ; i++ {
+			if i == maxStitchSegments {
+				return fmt.Errorf("stitch: page is still growing after %d viewports", i)
+			}
+			var m scrollMetrics
+			if err := chromedp.Evaluate(`({height: document.documentElement.scrollHeight, viewport: window.innerHeight})`, &m).Do(ctx); err != nil {
+				return err
+			}
+			var y float64
+			if err := chromedp.Evaluate(fmt.Sprintf(`window.scrollTo(0, %d); window.scrollY`, int(float64(i)*m.Viewport)), &y).Do(ctx); err != nil {
+				return err
+			}
+			// scrollt die Seite nicht weiter (z.B. overflow: hidden), ist sie zu Ende
+			if y == prevY {
+				break
+			}
+			prevY = y
+			// Lazy-Loading und Scroll-Handler kurz laufen lassen
+			if err := chromedp.Sleep(100 * time.Millisecond).Do(ctx); err != nil {
+				return err
+			}
+
+			shot, err := page.CaptureScreenshot().WithFormat(page.CaptureScreenshotFormatPng).Do(ctx)
+			if err != nil {
+				return err
+			}
+			seg, err := png.Decode(bytes.NewReader(shot))
+			if err != nil {
+				return fmt.Errorf("stitch: %w", err)
+			}
+			if out == nil {
+				scale = float64(seg.Bounds().Dy()) / m.Viewport
+			}
+			// die Seite kann beim Scrollen wachsen, das Bild wächst mit
+			height := int(m.Height*scale + 0.5)
+			if out == nil || out.Bounds().Dy() < height {
+				grown := image.NewRGBA(image.Rect(0, 0, seg.Bounds().Dx(), height))
+				if out != nil {
+					draw.Draw(grown, out.Bounds(), out, image.Point{}, draw.Src)
+				}
+				out = grown
+			}
+			top := int(y*scale + 0.5)
+			draw.Draw(out, seg.Bounds().Add(image.Pt(0, top)), seg, seg.Bounds().Min, draw.Src)
+			bottom = max(bottom, top+seg.Bounds().Dy())
+
+			if y+m.Viewport >= m.Height-1 {
+				break
+			}
+		}
+		if err := chromedp.Evaluate(`window.scrollTo(0, 0)`, nil).Do(ctx); err != nil {
+			return err
+		}
+
+		var b bytes.Buffer
+		if err := png.Encode(&b, out.SubImage(image.Rect(0, 0, out.Bounds().Dx(), min(bottom, out.Bounds().Dy())))); err != nil {
+			return err
+		}
+		*buf = b.Bytes()
+		return nil
+	})
+}
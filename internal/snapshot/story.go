@@ -0,0 +1,71 @@
+package snapshot
+
+import (
+	"encoding/base64"
+	"maps"
+	"time"
+
+	"github.com/chromedp/chromedp/device"
+	"github.com/maxischmaxi/qsnap/internal/config"
+	"github.com/maxischmaxi/qsnap/internal/devices"
+)
+
+// StoryOptions returns the capture options for a resolved story case. seed
+// is the base config's seed (nil = off).
+func StoryOptions(s *config.OsnapConfig, seed *int) Options {
+	var idle, settle time.Duration
+	if s.NetworkIdleMs != nil {
+		idle = time.Duration(*s.NetworkIdleMs) * time.Millisecond
+	}
+	if s.SettleMs != nil {
+		settle = time.Duration(*s.SettleMs) * time.Millisecond
+	}
+	headers := maps.Clone(s.Headers)
+	if a := s.BasicAuth; a != nil {
+		if headers == nil {
+			headers = map[string]string{}
+		}
+		headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(a.Username+":"+a.Password))
+	}
+	var dev *device.Info
+	if d, ok := devices.Lookup(s.Device); ok {
+		dev = &d
+	}
+	return Options{
+		Width:             s.Width,
+		Height:            s.Height,
+		Device:            dev,
+		WaitSelectors:     s.WaitSelectors,
+		WaitVisible:       s.WaitFor == "visible",
+		WaitPlay:          s.WaitForPlay != nil && *s.WaitForPlay,
+		Selector:          s.Selector,
+		FullPage:          s.FullScreen != nil && *s.FullScreen,
+		Stitch:            s.Stitch != nil && *s.Stitch,
+		FreezeFixed:       s.FreezeFixed != nil && *s.FreezeFixed,
+		Masks:             s.Ignore,
+		DisableAnimations: !s.Animations,
+		ColorScheme:       s.ColorScheme,
+		Timezone:          s.Timezone,
+		Locale:            s.Locale,
+		Geolocation:       s.Geolocation,
+		NetworkIdle:       idle,
+		Settle:            settle,
+		Seed:              seed,
+		Stabilize:         deref(s.Stabilize),
+		StabilizeInterval: time.Duration(deref(s.StabilizeIntervalMs)) * time.Millisecond,
+		Headers:           headers,
+		BlockHosts:        s.BlockHosts,
+		AllowHosts:        s.AllowHosts,
+		Mocks:             s.Mocks,
+		Cookies:           s.Cookies,
+		LocalStorage:      s.LocalStorage,
+		Actions:           storyActions(s.Actions),
+	}
+}
+
+func deref(p *int) int {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
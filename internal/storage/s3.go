@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/maxischmaxi/qsnap/internal/tools"
+)
+
+type credentials struct {
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+// s3Backend spricht die S3-REST-API (path-style) mit SigV4-Signaturen
+type s3Backend struct {
+	endpoint string
+	bucket   string
+	prefix   string
+	region   string
+	creds    credentials
+	client   http.Client
+}
+
+func (b *s3Backend) Key(filename string) string {
+	return joinKey(b.prefix, filename)
+}
+
+func (b *s3Backend) Download(ctx context.Context, key, localPath string) error {
+	resp, err := b.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("storage: %s: %w", key, fs.ErrNotExist)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("storage: GET %s: %s", key, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return tools.WriteFile(localPath, data)
+}
+
+func (b *s3Backend) Upload(ctx context.Context, localPath, key string) error {
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return err
+	}
+
+	resp, err := b.do(ctx, http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("storage: PUT %s: %s", key, resp.Status)
+	}
+	return nil
+}
+
+func (b *s3Backend) do(ctx context.Context, method, key string, body []byte) (*http.Response, error) {
+	uri := "/" + awsEscape(b.bucket) + "/" + awsEscapePath(key)
+
+	req, err := http.NewRequestWithContext(ctx, method, b.endpoint+uri, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if method == http.MethodPut {
+		req.Header.Set("Content-Type", "image/png")
+	}
+	b.sign(req, uri, body, time.Now())
+
+	return b.client.Do(req)
+}
+
+func (b *s3Backend) sign(req *http.Request, uri string, body []byte, now time.Time) {
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if b.creds.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", b.creds.sessionToken)
+	}
+
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	canonHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	if b.creds.sessionToken != "" {
+		signed = append(signed, "x-amz-security-token")
+		canonHeaders += "x-amz-security-token:" + b.creds.sessionToken + "\n"
+	}
+	signedHeaders := strings.Join(signed, ";")
+
+	canonReq := strings.Join([]string{req.Method, uri, "", canonHeaders, signedHeaders, payloadHash}, "\n")
+	reqHash := sha256.Sum256([]byte(canonReq))
+
+	scope := date + "/" + b.region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(reqHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+b.creds.secretKey), date)
+	key = hmacSHA256(key, b.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.creds.accessKey, scope, signedHeaders, sig,
+	))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func awsEscapePath(key string) string {
+	parts := strings.Split(key, "/")
+	for i, p := range parts {
+		parts[i] = awsEscape(p)
+	}
+	return strings.Join(parts, "/")
+}
+
+// awsEscape kodiert alles außer den unreserved characters aus RFC 3986,
+// so wie es SigV4 für den kanonischen Pfad verlangt
+func awsEscape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			sb.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&sb, "%%%02X", c)
+	}
+	return sb.String()
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// Backend stores baseline images outside of the repository.
+// Download returns an error wrapping fs.ErrNotExist if the key is missing.
+type Backend interface {
+	Key(filename string) string
+	Download(ctx context.Context, key, localPath string) error
+	Upload(ctx context.Context, localPath, key string) error
+}
+
+// New parses a storage URI like "s3://bucket/prefix?region=eu-central-1" or
+// "gs://bucket/prefix". An empty URI returns a nil Backend (local baselines only).
+func New(uri string) (Backend, error) {
+	if uri == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("storage: invalid uri %q: %w", uri, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("storage: missing bucket in %q", uri)
+	}
+
+	q := u.Query()
+	b := &s3Backend{
+		bucket: u.Host,
+		prefix: strings.Trim(u.Path, "/"),
+		region: q.Get("region"),
+		creds: credentials{
+			accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		},
+	}
+
+	switch u.Scheme {
+	case "s3":
+		if b.region == "" {
+			b.region = os.Getenv("AWS_REGION")
+		}
+		if b.region == "" {
+			b.region = "us-east-1"
+		}
+		b.endpoint = "https://s3." + b.region + ".amazonaws.com"
+	case "gs":
+		// GCS versteht SigV4 über die XML-API, wenn HMAC-Keys verwendet werden
+		if b.region == "" {
+			b.region = "auto"
+		}
+		b.endpoint = "https://storage.googleapis.com"
+		if id := os.Getenv("GCS_HMAC_ACCESS_ID"); id != "" {
+			b.creds = credentials{accessKey: id, secretKey: os.Getenv("GCS_HMAC_SECRET")}
+		}
+	default:
+		return nil, fmt.Errorf("storage: unsupported scheme %q (expected s3 or gs)", u.Scheme)
+	}
+
+	if ep := q.Get("endpoint"); ep != "" {
+		b.endpoint = strings.TrimRight(ep, "/")
+	}
+	if b.creds.accessKey == "" || b.creds.secretKey == "" {
+		return nil, fmt.Errorf("storage: missing credentials for %s", uri)
+	}
+
+	return b, nil
+}
+
+func joinKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return path.Join(prefix, name)
+}
@@ -0,0 +1,79 @@
+package storybook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotStorybook is returned by CheckStorybook if the server on the port
+// answers, but not like Storybook.
+var ErrNotStorybook = errors.New("storybook: server is not storybook")
+
+// CheckStorybook tells Storybook apart from an unrelated server on port:
+// static builds have project.json, dev servers and builds both serve an
+// iframe.html that loads the preview.
+func CheckStorybook(port int) error {
+	client := &http.Client{Timeout: 5 * time.Second}
+	base := fmt.Sprintf("http://127.0.0.1:%d", port)
+
+	if b, ok := get(client, base+"/project.json"); ok && json.Valid(b) {
+		return nil
+	}
+	if b, ok := get(client, base+"/iframe.html"); ok && strings.Contains(strings.ToLower(string(b)), "storybook") {
+		return nil
+	}
+	return fmt.Errorf("%w: port %d serves neither project.json nor a storybook iframe.html", ErrNotStorybook, port)
+}
+
+func get(client *http.Client, url string) ([]byte, bool) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, false
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	return b, err == nil
+}
+
+// ParsePortRange parses "3000-3100". An empty string returns 0, 0.
+func ParsePortRange(s string) (first, last int, err error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	lo, hi, ok := strings.Cut(s, "-")
+	if !ok {
+		hi = lo
+	}
+	if first, err = strconv.Atoi(strings.TrimSpace(lo)); err == nil {
+		last, err = strconv.Atoi(strings.TrimSpace(hi))
+	}
+	if err != nil || first < 1 || last > 65535 || first > last {
+		return 0, 0, fmt.Errorf("invalid port range %q: expected <first>-<last>", s)
+	}
+	return first, last, nil
+}
+
+// FreePort returns the first port in first..last nothing listens on.
+func FreePort(first, last int) (int, error) {
+	for p := first; p <= last; p++ {
+		l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", p))
+		if err != nil {
+			continue
+		}
+		// zwischen Close und ListenAndServe kann ein anderer Prozess den Port
+		// nehmen, dann schlägt WaitHTTP fehl
+		_ = l.Close()
+		return p, nil
+	}
+	return 0, fmt.Errorf("storybook: no free port in %d-%d", first, last)
+}
@@ -11,6 +11,8 @@ import (
 	"path/filepath"
 	"strings"
 	"time"
+
+	"github.com/maxischmaxi/qsnap/internal/tools"
 )
 
 type Controller struct {
@@ -20,6 +22,12 @@ type Controller struct {
 	port    int
 }
 
+// Started reports whether qsnap serves the build itself (as opposed to using
+// a server that was already running).
+func (c *Controller) Started() bool {
+	return c != nil && c.started
+}
+
 func (c *Controller) Stop() {
 	if c == nil {
 		return
@@ -36,28 +44,53 @@ func (c *Controller) Stop() {
 
 // ---------- Build ----------
 
-func BuildIfNeeded(ctx context.Context, buildCmd, buildDir, workDir string, force bool) error {
+// DefaultBuildScript is run with the detected package manager when no build
+// command is given.
+const DefaultBuildScript = "project:build:storybook"
+
+// BuildIfNeeded runs buildCmd in workDir unless buildDir already exists. An
+// empty buildCmd runs DefaultBuildScript with the package manager found by
+// DetectPackageManager. stdout and stderr of the build go to logFile
+// ("" = discarded).
+func BuildIfNeeded(ctx context.Context, buildCmd, buildDir, workDir string, force bool, logFile string) error {
 	if !force {
 		if st, err := os.Stat(buildDir); err == nil && st.IsDir() {
 			return nil
 		}
 	}
 
-	bin, args := splitCmd(buildCmd)
-	if bin == "" {
+	if strings.TrimSpace(buildCmd) == "" {
+		buildCmd = DetectPackageManager(workDir) + " run " + DefaultBuildScript
+	}
+	parts, err := tools.SplitCommand(buildCmd)
+	if err != nil {
+		return fmt.Errorf("storybook: build command: %w", err)
+	}
+	if len(parts) == 0 {
 		return errors.New("storybook: build command empty")
 	}
 
-	cmd := exec.CommandContext(ctx, bin, args...)
+	cmd := exec.CommandContext(ctx, parts[0], parts[1:]...)
 	if workDir != "" {
 		cmd.Dir = workDir
 	}
 
-	cmd.Stdout = nil
-	cmd.Stderr = nil
+	if logFile != "" {
+		f, err := os.Create(logFile)
+		if err != nil {
+			return fmt.Errorf("storybook: build log: %w", err)
+		}
+		defer f.Close()
+		fmt.Fprintf(f, "$ %s\n", buildCmd)
+		cmd.Stdout = f
+		cmd.Stderr = f
+	}
 
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("storybook: build command failed: %w", err)
+		if logFile != "" {
+			return fmt.Errorf("storybook: build command %q failed (output in %s): %w", buildCmd, logFile, err)
+		}
+		return fmt.Errorf("storybook: build command %q failed: %w", buildCmd, err)
 	}
 
 	if st, err := os.Stat(buildDir); err != nil || !st.IsDir() {
@@ -67,12 +100,36 @@ func BuildIfNeeded(ctx context.Context, buildCmd, buildDir, workDir string, forc
 	return nil
 }
 
-func splitCmd(s string) (string, []string) {
-	parts := strings.Fields(s)
-	if len(parts) == 0 {
-		return "", nil
+// lockfiles in der Reihenfolge, in der sie geprüft werden
+var lockfiles = []struct{ file, manager string }{
+	{"pnpm-lock.yaml", "pnpm"},
+	{"yarn.lock", "yarn"},
+	{"bun.lock", "bun"},
+	{"bun.lockb", "bun"},
+	{"package-lock.json", "npm"},
+	{"npm-shrinkwrap.json", "npm"},
+}
+
+// DetectPackageManager returns the package manager whose lockfile is found in
+// dir or the nearest parent directory (for workspaces), "npm" if there is
+// none.
+func DetectPackageManager(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "npm"
+	}
+	for {
+		for _, l := range lockfiles {
+			if _, err := os.Stat(filepath.Join(dir, l.file)); err == nil {
+				return l.manager
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "npm"
+		}
+		dir = parent
 	}
-	return parts[0], parts[1:]
 }
 
 func ServeBuildIfNeeded(
@@ -0,0 +1,40 @@
+package storybook
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// VerifyBuild checks that the server on port serves the same index.html as
+// the local build in dir, so a stale Storybook left running by someone else
+// is not silently used.
+func VerifyBuild(port int, dir string) error {
+	local, err := os.ReadFile(filepath.Join(dir, "index.html"))
+	if err != nil {
+		return fmt.Errorf("storybook: cannot read local build: %w", err)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d/index.html", port))
+	if err != nil {
+		return fmt.Errorf("storybook: cannot fetch index.html from port %d: %w", port, err)
+	}
+	defer resp.Body.Close()
+
+	remote, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	lh, rh := sha256.Sum256(local), sha256.Sum256(remote)
+	if !bytes.Equal(lh[:], rh[:]) {
+		return fmt.Errorf("storybook: server on port %d does not serve the build in %s (index.html %x != %x)", port, dir, rh[:6], lh[:6])
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package tools
+
+import "errors"
+
+func FreeDiskSpace(dir string) (uint64, error) {
+	return 0, errors.New("free disk space is not available on this platform")
+}
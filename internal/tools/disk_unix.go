@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+
+package tools
+
+import "golang.org/x/sys/unix"
+
+// FreeDiskSpace returns the bytes available to the current user on the
+// file system of dir.
+func FreeDiskSpace(dir string) (uint64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
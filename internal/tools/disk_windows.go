@@ -0,0 +1,17 @@
+package tools
+
+import "golang.org/x/sys/windows"
+
+// FreeDiskSpace returns the bytes available to the current user on the
+// volume of dir.
+func FreeDiskSpace(dir string) (uint64, error) {
+	p, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var free uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &free, nil, nil); err != nil {
+		return 0, err
+	}
+	return free, nil
+}
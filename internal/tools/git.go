@@ -0,0 +1,31 @@
+package tools
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// CurrentBranch returns the git branch being tested: from the CI
+// environment (GitHub Actions, GitLab CI) or `git rev-parse` in dir. It is
+// "" for a detached HEAD outside CI.
+func CurrentBranch(dir string) (string, error) {
+	// bei Pull Requests steht in GITHUB_REF_NAME nur "123/merge"
+	for _, key := range []string{"GITHUB_HEAD_REF", "GITHUB_REF_NAME", "CI_MERGE_REQUEST_SOURCE_BRANCH_NAME", "CI_COMMIT_REF_NAME"} {
+		if v := os.Getenv(key); v != "" {
+			return v, nil
+		}
+	}
+
+	cmd := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	branch := strings.TrimSpace(string(out))
+	if branch == "HEAD" {
+		return "", nil
+	}
+	return branch, nil
+}
@@ -0,0 +1,74 @@
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Version is set by release builds:
+//
+//	go build -ldflags "-X github.com/maxischmaxi/qsnap/internal/version.Version=v1.2.0" ./cmd/qsnap
+//
+// Otherwise it is taken from the module version of `go install ...@v1.2.0`.
+var Version = ""
+
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	CommitAt  string `json:"commitTime,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"goVersion"`
+	Platform  string `json:"platform"`
+}
+
+func Get() Info {
+	info := Info{Version: Version, GoVersion: runtime.Version(), Platform: runtime.GOOS + "/" + runtime.GOARCH}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		if info.Version == "" {
+			info.Version = "dev"
+		}
+		return info
+	}
+	if info.Version == "" {
+		info.Version = bi.Main.Version
+	}
+	// "(devel)" bei go build aus einem Checkout
+	if info.Version == "" || info.Version == "(devel)" {
+		info.Version = "dev"
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Commit = s.Value
+		case "vcs.time":
+			info.CommitAt = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
+}
+
+func (i Info) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "qsnap %s", i.Version)
+	if i.Commit != "" {
+		commit := i.Commit
+		if len(commit) > 12 {
+			commit = commit[:12]
+		}
+		if i.Modified {
+			commit += "-dirty"
+		}
+		fmt.Fprintf(&b, " (%s", commit)
+		if i.CommitAt != "" {
+			fmt.Fprintf(&b, ", %s", i.CommitAt)
+		}
+		b.WriteString(")")
+	}
+	fmt.Fprintf(&b, " %s %s", i.GoVersion, i.Platform)
+	return b.String()
+}
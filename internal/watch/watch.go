@@ -0,0 +1,91 @@
+package watch
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+type stamp struct {
+	mod  time.Time
+	size int64
+}
+
+// Poller detects changed files by comparing modification times and sizes
+// between scans. Polling keeps qsnap free of platform-specific notify APIs.
+type Poller struct {
+	Roots    []string
+	SkipDirs []string // directory names or absolute paths that are never scanned
+	Interval time.Duration
+
+	last map[string]stamp
+}
+
+func (p *Poller) scan() map[string]stamp {
+	out := map[string]stamp{}
+	for _, root := range p.Roots {
+		_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				name := d.Name()
+				if path != root && (strings.HasPrefix(name, ".") || slices.Contains(p.SkipDirs, name) || slices.Contains(p.SkipDirs, path)) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			out[path] = stamp{mod: info.ModTime(), size: info.Size()}
+			return nil
+		})
+	}
+	return out
+}
+
+// Next blocks until at least one file was created, changed or removed since
+// the previous call (or since the first scan) and returns those paths.
+func (p *Poller) Next(ctx context.Context) ([]string, error) {
+	if p.last == nil {
+		p.last = p.scan()
+	}
+	interval := p.Interval
+	if interval <= 0 {
+		interval = 500 * time.Millisecond
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+
+		cur := p.scan()
+		var changed []string
+		for path, st := range cur {
+			if old, ok := p.last[path]; !ok || old != st {
+				changed = append(changed, path)
+			}
+		}
+		for path := range p.last {
+			if _, ok := cur[path]; !ok {
+				changed = append(changed, path)
+			}
+		}
+		p.last = cur
+
+		if len(changed) > 0 {
+			slices.Sort(changed)
+			return changed, nil
+		}
+	}
+}
@@ -0,0 +1,265 @@
+// Package qsnap runs qsnap's capture and compare pipeline from Go code, e.g.
+// from a custom test harness. It does not build or serve Storybook: the
+// stories are captured from Options.URL or the base config's baseUrl.
+//
+//	cfg, err := qsnap.LoadConfig("osnap.config.yaml")
+//	r, err := qsnap.New(qsnap.Options{Dir: ".", Config: cfg, URL: "http://localhost:6006"})
+//	rep, err := r.Run(ctx)
+package qsnap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maxischmaxi/qsnap/internal/browser"
+	"github.com/maxischmaxi/qsnap/internal/compare"
+	"github.com/maxischmaxi/qsnap/internal/config"
+	"github.com/maxischmaxi/qsnap/internal/pool"
+	"github.com/maxischmaxi/qsnap/internal/report"
+	"github.com/maxischmaxi/qsnap/internal/runner"
+	"github.com/maxischmaxi/qsnap/internal/snapshot"
+	"github.com/maxischmaxi/qsnap/internal/storage"
+	"github.com/maxischmaxi/qsnap/internal/tools"
+)
+
+type (
+	Report     = report.Report
+	CaseResult = report.CaseResult
+	// Story is one resolved case: a story entry at one size, color scheme
+	// and device.
+	Story      = config.OsnapConfig
+	BaseConfig = config.OsnapBaseConfig
+	// Storage keeps baselines in a remote bucket, see NewStorage.
+	Storage = storage.Backend
+
+	MarkdownOptions = report.MarkdownOptions
+)
+
+// LoadConfig reads and validates a base config (osnap.config.yaml).
+func LoadConfig(path string) (*BaseConfig, error) {
+	return config.NewOsnapBaseConfig(path)
+}
+
+// NewStorage parses a storage URI like "s3://bucket/prefix" or
+// "gs://bucket/prefix". An empty URI returns nil (local baselines only).
+func NewStorage(uri string) (Storage, error) {
+	return storage.New(uri)
+}
+
+// Reporter receives the report after a run.
+type Reporter interface {
+	WriteReport(r Report) error
+}
+
+// ReporterFunc adapts a function to Reporter.
+type ReporterFunc func(r Report) error
+
+func (f ReporterFunc) WriteReport(r Report) error { return f(r) }
+
+// JSONReporter writes the report as report.json to path.
+func JSONReporter(path string) Reporter {
+	return ReporterFunc(func(r Report) error { return report.Write(path, r) })
+}
+
+// JUnitReporter writes a JUnit XML report to path. noBaselineAs is "pass",
+// "fail" or "error", like -noBaselineAs.
+func JUnitReporter(path, noBaselineAs string) Reporter {
+	return ReporterFunc(func(r Report) error { return report.WriteJUnit(path, r, noBaselineAs) })
+}
+
+// MarkdownReporter writes the Markdown summary to path.
+func MarkdownReporter(path string, opts MarkdownOptions) Reporter {
+	return ReporterFunc(func(r Report) error { return report.WriteMarkdown(path, r, opts) })
+}
+
+type Options struct {
+	// Dir is the project directory; snapshots go to the config's outputDir
+	// or Dir/../__image-snapshots__ like with -input.
+	Dir string
+	// Config is the base config. Required.
+	Config *BaseConfig
+	// Stories are the cases to run. nil discovers the story config files
+	// below Dir and applies `only: true`.
+	Stories []*Story
+	// URL is the Storybook (or site) the stories are captured from. Defaults
+	// to Config.BaseURL.
+	URL string
+
+	Concurrency int // default 10
+	Instances   int // default 4
+	// ChromeArgs are appended to the base config's chromeArgs.
+	ChromeArgs []string
+	// Timeout per case, default 30s.
+	Timeout time.Duration
+
+	// Storage overrides the base config's baselineStorage.
+	Storage   Storage
+	Reporters []Reporter
+	// UpdateBaselines is "", "missing" or "all", like -updateBaselines.
+	UpdateBaselines string
+	// Branch uses branch baselines like branchBaselines with -branch. ""
+	// or the config's mainBranch use the shared ones.
+	Branch string
+}
+
+// Runner runs a set of stories against one browser pool. A Runner can be
+// run several times, but not concurrently.
+type Runner struct {
+	opts    Options
+	stories []*Story
+	skipped []report.SkippedConfig
+	store   Storage
+	layout  config.Layout
+}
+
+// New checks opts, applies the defaults and discovers the stories of
+// opts.Dir if opts.Stories is nil.
+func New(opts Options) (*Runner, error) {
+	if opts.Config == nil {
+		return nil, errors.New("qsnap: Options.Config is required")
+	}
+	if opts.Dir == "" {
+		opts.Dir = "."
+	}
+	dir, err := tools.ExpandPath(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	opts.Dir = dir
+	if opts.URL == "" {
+		opts.URL = opts.Config.BaseURL
+	}
+	if opts.URL == "" {
+		return nil, errors.New("qsnap: Options.URL or the config's baseUrl is required")
+	}
+	switch opts.UpdateBaselines {
+	case "", "missing", "all":
+	default:
+		return nil, fmt.Errorf("qsnap: invalid UpdateBaselines %q: expected missing or all", opts.UpdateBaselines)
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 10
+	}
+	if opts.Instances <= 0 {
+		opts.Instances = 4
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+
+	r := &Runner{opts: opts, stories: opts.Stories, store: opts.Storage, layout: opts.Config.Layout(opts.Dir)}
+	if opts.Branch != opts.Config.MainBranch {
+		r.layout.Branch = opts.Branch
+	}
+	if r.stories == nil {
+		discovered, err := opts.Config.FindAndParseConfigs(opts.Dir)
+		if err != nil {
+			return nil, err
+		}
+		r.stories, _ = config.Only(discovered.Configs)
+		for _, fe := range discovered.Errors {
+			r.skipped = append(r.skipped, report.SkippedConfig{Path: fe.Path, Error: fe.Err.Error()})
+		}
+	}
+	if r.store == nil {
+		store, err := storage.New(opts.Config.BaselineStorage)
+		if err != nil {
+			return nil, err
+		}
+		r.store = store
+	}
+	return r, nil
+}
+
+// Stories returns the cases Run will capture.
+func (r *Runner) Stories() []*Story { return r.stories }
+
+// Run captures and compares all stories and passes the report to the
+// reporters. A cancelled ctx reports the remaining cases as skipped. The
+// error is about the run itself (browser launch, storage, reporters); case
+// failures are only in the report, see ExitCode.
+func (r *Runner) Run(ctx context.Context) (Report, error) {
+	started := time.Now()
+	cfg := r.opts.Config
+
+	browsers, err := browser.LaunchPool(ctx, r.opts.Instances, append(slices.Clone(cfg.ChromeArgs), r.opts.ChromeArgs...))
+	if err != nil {
+		return Report{}, err
+	}
+	defer browsers.CloseAll()
+	browsers.SetTabLimit((r.opts.Concurrency + len(browsers) - 1) / len(browsers))
+
+	if r.store != nil {
+		if _, err := compare.PullBaselines(ctx, r.store, r.layout, r.stories); err != nil {
+			return Report{}, err
+		}
+	}
+
+	// derselbe Ablauf wie in der CLI: Retry, Debug-Bundles, HAR und Warnungen
+	cases := &runner.Runner{
+		Config:   cfg,
+		Layout:   r.layout,
+		Store:    r.store,
+		Browsers: browsers,
+		URL:      r.storyURL,
+		Timeout:  r.opts.Timeout,
+		Update:   r.opts.UpdateBaselines,
+	}
+	results := make([]report.CaseResult, len(r.stories))
+	var mu sync.Mutex
+	p := pool.New(r.opts.Concurrency)
+	for i, s := range r.stories {
+		p.Go(func() error {
+			missing := !s.Skip.Enabled && !tools.FileExists(r.layout.Baseline(s))
+			res, ok := cases.Case(ctx, s, missing, nil)
+			if !ok {
+				return nil
+			}
+			mu.Lock()
+			results[i] = res
+			mu.Unlock()
+			return nil
+		})
+	}
+	poolErr := p.Wait()
+
+	for i, s := range r.stories {
+		if results[i].Status == "" {
+			results[i] = compare.NewResult(r.layout, s)
+			results[i].Status = "skipped"
+		}
+	}
+	finished := time.Now()
+	rep := report.Report{
+		GeneratedAt:    finished.Format(time.RFC3339),
+		Cases:          results,
+		SkippedConfigs: r.skipped,
+		Analytics:      report.Analyze(results, 10),
+		Timing:         report.NewTiming(started, finished, results),
+	}
+	rep.Recount()
+
+	errs := []error{poolErr}
+	for _, rp := range r.opts.Reporters {
+		errs = append(errs, rp.WriteReport(rep))
+	}
+	return rep, errors.Join(errs...)
+}
+
+func (r *Runner) storyURL(s *Story) string {
+	u := strings.TrimRight(r.opts.URL, "/") + "/" + strings.TrimLeft(s.URL, "/")
+	if seed := r.opts.Config.Seed; seed != nil {
+		u = snapshot.WithSeedGlobal(u, *seed)
+	}
+	return u
+}
+
+// ExitCode maps a report to qsnap's exit codes (0 ok, 1 failures, 2 errors).
+func ExitCode(r Report, noBaselineAs string) int {
+	return report.ExitCode(r, noBaselineAs)
+}